@@ -2,4 +2,8 @@ module github.com/AlisherFozilov/ib-core
 
 go 1.13
 
-require github.com/mattn/go-sqlite3 v2.0.3+incompatible
+require (
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v2.0.3+incompatible
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+)
@@ -0,0 +1,257 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// New accounts get identifiers of this bank and branch. Changing them
+// doesn't touch the identifiers already given.
+var (
+	AccountIDCountry = "TJ"
+	AccountIDBank    = "0001"
+	AccountIDBranch  = "0001"
+)
+
+const (
+	accountIDBankDigits   = 4
+	accountIDBranchDigits = 4
+	accountIDSerialDigits = 10
+	accountIDLength       = 4 + accountIDBankDigits + accountIDBranchDigits + accountIDSerialDigits
+)
+
+// AccountID is the IBAN-like identifier of an account people can type:
+// country, two ISO 7064 mod 97-10 check digits, bank, branch and serial,
+// for example TJ58 0001 0001 1000000001. The first digit of the serial tells
+// client accounts from service ones, so serials are unique across both.
+type AccountID struct {
+	Country string
+	Bank    string
+	Branch  string
+	Serial  string
+}
+
+func newAccountID(account LedgerAccount, accountId int64) AccountID {
+	kind := 1
+	if account == LedgerService {
+		kind = 2
+	}
+	return AccountID{
+		Country: AccountIDCountry,
+		Bank:    AccountIDBank,
+		Branch:  AccountIDBranch,
+		Serial:  fmt.Sprintf("%d%0*d", kind, accountIDSerialDigits-1, accountId),
+	}
+}
+
+func (receiver AccountID) bban() string {
+	return receiver.Bank + receiver.Branch + receiver.Serial
+}
+
+func (receiver AccountID) CheckDigits() string {
+	return fmt.Sprintf("%02d", 98-mod97(receiver.bban()+receiver.Country+"00"))
+}
+
+func (receiver AccountID) String() string {
+	return receiver.Country + receiver.CheckDigits() + receiver.bban()
+}
+
+// ParseAccountID accepts the identifier in any case and with spaces.
+func ParseAccountID(accountID string) (AccountID, error) {
+	normalized := strings.ToUpper(strings.Join(strings.Fields(accountID), ""))
+	if len(normalized) != accountIDLength ||
+		!isUpperLetters(normalized[:2]) || !isDigits(normalized[2:]) {
+
+		return AccountID{}, fmt.Errorf("%w: %q", ErrInvalidAccountID, accountID)
+	}
+	// the check digits go after the country, the whole must give 1
+	if mod97(normalized[4:]+normalized[:4]) != 1 {
+		return AccountID{}, fmt.Errorf("%w: check digits of %q", ErrInvalidAccountID, accountID)
+	}
+	bban := normalized[4:]
+	return AccountID{
+		Country: normalized[:2],
+		Bank:    bban[:accountIDBankDigits],
+		Branch:  bban[accountIDBankDigits : accountIDBankDigits+accountIDBranchDigits],
+		Serial:  bban[accountIDBankDigits+accountIDBranchDigits:],
+	}, nil
+}
+
+func ValidateAccountID(accountID string) error {
+	_, err := ParseAccountID(accountID)
+	return err
+}
+
+// mod97 reads letters as 10 for A up to 35 for Z.
+func mod97(value string) int {
+	remainder := 0
+	for _, char := range value {
+		digits := strconv.Itoa(int(char - '0'))
+		if 'A' <= char && char <= 'Z' {
+			digits = strconv.Itoa(int(char-'A') + 10)
+		}
+		for _, digit := range digits {
+			remainder = (remainder*10 + int(digit-'0')) % 97
+		}
+	}
+	return remainder
+}
+
+func isUpperLetters(value string) bool {
+	for _, char := range value {
+		if char < 'A' || 'Z' < char {
+			return false
+		}
+	}
+	return true
+}
+func isDigits(value string) bool {
+	for _, char := range value {
+		if char < '0' || '9' < char {
+			return false
+		}
+	}
+	return true
+}
+
+func (receiver *Store) assignAccountID(ctx context.Context,
+	account LedgerAccount, accountId int64) error {
+
+	_, err := receiver.conn().ExecContext(ctx, insertAccountIdentifierSQL,
+		sql.Named("identifier", newAccountID(account, accountId).String()),
+		sql.Named("account_type", string(account)),
+		sql.Named("account_id", accountId),
+	)
+	if err != nil {
+		return queryError(insertAccountIdentifierSQL, err)
+	}
+	return nil
+}
+
+// assignMissingAccountIDs gives identifiers to accounts made before they
+// existed.
+func (receiver *Store) assignMissingAccountIDs(ctx context.Context) error {
+	for _, accounts := range []accountQueries{clientAccounts, serviceAccounts} {
+		ids, err := receiver.queryIds(ctx, accounts.getWithoutIdentifier)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			err = receiver.assignAccountID(ctx, accounts.ledgerAccount, id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+func (receiver *Store) queryIds(ctx context.Context, query string) ([]int64, error) {
+	rows, err := receiver.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, queryError(query, err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// FindAccountByID returns the client or service account with the identifier.
+func (receiver *Store) FindAccountByID(ctx context.Context,
+	accountID string) (record AccountRecord, err error) {
+
+	parsed, err := ParseAccountID(accountID)
+	if err != nil {
+		return record, err
+	}
+	identifier := parsed.String()
+
+	var accountType string
+	var accountId int64
+	err = receiver.conn().QueryRowContext(ctx, getAccountByIdentifierSQL,
+		identifier).Scan(&accountType, &accountId)
+	if err == sql.ErrNoRows {
+		return record, fmt.Errorf("%w: %s", ErrAccountNotFound, identifier)
+	}
+	if err != nil {
+		return record, queryError(getAccountByIdentifierSQL, err)
+	}
+
+	accounts := clientAccounts
+	if LedgerAccount(accountType) == LedgerService {
+		accounts = serviceAccounts
+	}
+	record.Account = accounts.ledgerAccount
+	err = receiver.conn().QueryRowContext(ctx, accounts.getById, accountId).Scan(
+		&record.Id, &record.OwnerId, &record.AccountNumber, &record.Balance)
+	if err == sql.ErrNoRows {
+		return record, fmt.Errorf("%w: %s", ErrAccountNotFound, identifier)
+	}
+	if err != nil {
+		return record, queryError(accounts.getById, err)
+	}
+	return record, nil
+}
+
+func (receiver *Store) AccountIDOf(ctx context.Context,
+	account LedgerAccount, ownerId, accountNumber int64) (string, error) {
+
+	accounts := clientAccounts
+	if account == LedgerService {
+		accounts = serviceAccounts
+	}
+	var identifier string
+	err := receiver.conn().QueryRowContext(ctx, accounts.getIdentifier,
+		sql.Named("id", ownerId),
+		sql.Named("account_number", accountNumber),
+	).Scan(&identifier)
+	if err == sql.ErrNoRows {
+		return "", accountError(accounts.ledgerAccount, ownerId, accountNumber,
+			accounts.accountNotFound)
+	}
+	if err != nil {
+		return "", queryError(accounts.getIdentifier, err)
+	}
+	return identifier, nil
+}
+
+// TransferByAccountID is a transfer to a client or a service payment,
+// depending on the receiver account.
+func (receiver *Store) TransferByAccountID(ctx context.Context, transfer AccountTransfer) error {
+	sender, err := receiver.FindAccountByID(ctx, transfer.SenderAccountID)
+	if err != nil {
+		return err
+	}
+	if sender.Account != LedgerClient {
+		return fmt.Errorf("%w: %s is not a client account",
+			ErrInvalidAccountID, transfer.SenderAccountID)
+	}
+	recipient, err := receiver.FindAccountByID(ctx, transfer.ReceiverAccountID)
+	if err != nil {
+		return err
+	}
+
+	tfr := MoneyTransfer{
+		Amount:                transfer.Amount,
+		SenderId:              sender.OwnerId,
+		SenderAccountNumber:   sender.AccountNumber,
+		ReceiverId:            recipient.OwnerId,
+		ReceiverAccountNumber: recipient.AccountNumber,
+		IdempotencyKey:        transfer.IdempotencyKey,
+	}
+	if recipient.Account == LedgerService {
+		return receiver.transferByReceiverAccountId(ctx, tfr, KindServicePayment, serviceAccounts)
+	}
+	return receiver.transferByReceiverAccountId(ctx, tfr, KindTransfer, clientAccounts)
+}
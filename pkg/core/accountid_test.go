@@ -0,0 +1,155 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_parseAccountID(t *testing.T) {
+	// the check digits of a real IBAN
+	if mod97("WEST12345698765432GB82") != 1 {
+		t.Error("want GB82 WEST 1234 5698 7654 32 to be valid")
+	}
+
+	id := newAccountID(LedgerService, 42)
+	if id.String() != "TJ"+id.CheckDigits()+"0001"+"0001"+"2000000042" {
+		t.Errorf("unexpected layout: %s", id)
+	}
+	for _, typed := range []string{id.String(), " tj" + id.CheckDigits() + " 0001 0001 2000000042 "} {
+		parsed, err := ParseAccountID(typed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != id {
+			t.Errorf("want: %v, got: %v", id, parsed)
+		}
+	}
+
+	for _, invalid := range []string{
+		"",
+		"TJ58000100011000000002",  // wrong check digits
+		"TJ5800010001100000001",   // too short
+		"TJ580001000110000000011", // too long
+		"1258000100011000000001",
+		"TJ5800010001100000000A",
+	} {
+		err := ValidateAccountID(invalid)
+		if !errors.Is(err, ErrInvalidAccountID) {
+			t.Errorf("%q: want: %v, got: %v", invalid, ErrInvalidAccountID, err)
+		}
+	}
+	err := ValidateAccountID("TJ58 0001 0001 1000 0000 01")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_transferByAccountID(t *testing.T) {
+	db, cleanup := createDBinFile(t)
+	defer cleanup()
+
+	// an account made before identifiers
+	ctx := context.Background()
+	err := Migrate(ctx, db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+INSERT INTO clients (login, password, name, phone) VALUES ('sender', '', '', '');
+INSERT INTO bank_accounts (client_id, account_number, balance) VALUES (1, 0, 0);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = AddClient(defaultAdminId, Client{Login: "receiver"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddBankAccountToClient(defaultAdminId, 2, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = AddService(defaultAdminId, Service{Name: "internet"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReplenishBankAccount(defaultAdminId, 1, 0, 1000, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	senderID, err := AccountIDOf(LedgerClient, 1, 0, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiverID, err := AccountIDOf(LedgerClient, 2, 0, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serviceID, err := AccountIDOf(LedgerService, 1, 0, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{senderID, receiverID, serviceID} {
+		err = ValidateAccountID(id)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	_, err = AccountIDOf(LedgerClient, 2, 1, db)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("want: %v, got: %v", ErrAccountNotFound, err)
+	}
+
+	record, err := FindAccountByID(receiverID, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Account != LedgerClient || record.OwnerId != 2 || record.AccountNumber != 0 {
+		t.Errorf("want account 0 of client 2, got: %+v", record)
+	}
+	_, err = FindAccountByID(newAccountID(LedgerClient, 99).String(), db)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("want: %v, got: %v", ErrAccountNotFound, err)
+	}
+
+	err = TransferByAccountID(AccountTransfer{
+		Amount:            300,
+		SenderAccountID:   senderID,
+		ReceiverAccountID: receiverID,
+	}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = TransferByAccountID(AccountTransfer{
+		Amount:            200,
+		SenderAccountID:   senderID,
+		ReceiverAccountID: serviceID,
+	}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = TransferByAccountID(AccountTransfer{
+		Amount:            1,
+		SenderAccountID:   serviceID,
+		ReceiverAccountID: senderID,
+	}, db)
+	if !errors.Is(err, ErrInvalidAccountID) {
+		t.Errorf("want: %v, got: %v", ErrInvalidAccountID, err)
+	}
+
+	for id, want := range map[string]int64{senderID: 500, receiverID: 300, serviceID: 200} {
+		record, err := FindAccountByID(id, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Balance != want {
+			t.Errorf("%s: want: %d, got: %d", id, want, record.Balance)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AtmLimits are in minor units of the account currency. The daily limit
+// applies to every account separately, from midnight of the local time.
+type AtmLimits struct {
+	PerTransaction int64
+	Daily          int64
+}
+
+var (
+	AtmWithdrawalLimits = AtmLimits{PerTransaction: 500_000, Daily: 2_000_000}
+	AtmDepositLimits    = AtmLimits{PerTransaction: 2_000_000, Daily: 10_000_000}
+)
+
+type AtmOperation struct {
+	Id            int64
+	AtmId         int64
+	Kind          TransactionKind
+	ClientId      int64
+	AccountNumber int64
+	Amount        int64
+	TransactionId int64
+	CreatedAt     time.Time
+	// Banknotes are the ones a withdrawal gave, they aren't listed later.
+	Banknotes []Banknotes
+}
+
+// AtmReconciliation compares the operations of an ATM in [From, To) with the
+// journal. LedgerNet is what the journal says left the ATM: withdrawn less
+// deposited.
+type AtmReconciliation struct {
+	AtmId       int64
+	From        time.Time
+	To          time.Time
+	Withdrawals int
+	Withdrawn   int64
+	Deposits    int
+	Deposited   int64
+	LedgerNet   int64
+}
+
+func (receiver AtmReconciliation) Ok() bool {
+	return receiver.Withdrawn-receiver.Deposited == receiver.LedgerNet
+}
+
+func (receiver *Store) WithdrawAtATM(ctx context.Context,
+	atmId, clientId, accountNumber, amount int64) (AtmOperation, error) {
+
+	return receiver.atmOperation(ctx, KindAtmWithdrawal, AtmWithdrawalLimits,
+		atmId, clientId, accountNumber, amount)
+}
+func (receiver *Store) DepositAtATM(ctx context.Context,
+	atmId, clientId, accountNumber, amount int64) (AtmOperation, error) {
+
+	return receiver.atmOperation(ctx, KindAtmDeposit, AtmDepositLimits,
+		atmId, clientId, accountNumber, amount)
+}
+
+func (receiver *Store) atmOperation(ctx context.Context,
+	kind TransactionKind, limits AtmLimits,
+	atmId, clientId, accountNumber, amount int64) (operation AtmOperation, err error) {
+
+	if amount < 1 {
+		return AtmOperation{}, ErrInvalidAmount
+	}
+	if amount > limits.PerTransaction {
+		return AtmOperation{}, accountError(LedgerClient, clientId, accountNumber,
+			fmt.Errorf("%w: %s of %d is over %d", ErrAtmLimitExceeded, kind, amount,
+				limits.PerTransaction))
+	}
+
+	err = receiver.InTx(ctx, func(tx *Store) error {
+		err := tx.changeBalanceAtAtm(ctx, kind, clientId, accountNumber, amount)
+		if err != nil {
+			return err
+		}
+
+		atm, err := tx.GetATM(ctx, atmId)
+		if err != nil {
+			return err
+		}
+		if atm.Status != AtmOnline {
+			return fmt.Errorf("%w: ATM %d is %s", ErrAtmUnavailable, atmId, atm.Status)
+		}
+		if kind == KindAtmDeposit && !atm.CashIn {
+			return fmt.Errorf("%w: ATM %d takes no cash", ErrAtmUnavailable, atmId)
+		}
+
+		var accountId, balance int64
+		err = tx.conn().QueryRowContext(ctx, clientAccounts.getIdAndBalance,
+			sql.Named("id", clientId),
+			sql.Named("account_number", accountNumber),
+		).Scan(&accountId, &balance)
+		if err != nil {
+			return queryError(clientAccounts.getIdAndBalance, err)
+		}
+		currency, err := tx.accountCurrency(ctx, LedgerClient, accountId)
+		if err != nil {
+			return err
+		}
+		if !atm.hasCurrency(currency) {
+			return fmt.Errorf("%w: ATM %d has no %s", ErrAtmUnavailable, atmId, currency)
+		}
+
+		current := now()
+		year, month, day := current.Date()
+		var total int64
+		err = tx.conn().QueryRowContext(ctx, getAtmOperationsTotalSQL,
+			sql.Named("bank_account_id", accountId),
+			sql.Named("kind", string(kind)),
+			sql.Named("since", time.Date(year, month, day, 0, 0, 0, 0, current.Location()).Unix()),
+		).Scan(&total)
+		if err != nil {
+			return queryError(getAtmOperationsTotalSQL, err)
+		}
+		if total+amount > limits.Daily {
+			return accountError(LedgerClient, clientId, accountNumber,
+				fmt.Errorf("%w: %s of %d after %d today is over %d",
+					ErrAtmLimitExceeded, kind, amount, total, limits.Daily))
+		}
+
+		// the ATM leg mirrors cash: money leaving through it is positive
+		postings := []Posting{
+			{Account: LedgerClient, AccountId: accountId, Amount: -amount},
+			{Account: LedgerAtm, AccountId: atmId, Amount: amount},
+		}
+		if kind == KindAtmDeposit {
+			postings[0].Amount, postings[1].Amount = amount, -amount
+		}
+		transactionId, err := tx.writeJournal(ctx, kind, postings)
+		if err != nil {
+			return err
+		}
+
+		operation = AtmOperation{
+			AtmId:         atmId,
+			Kind:          kind,
+			ClientId:      clientId,
+			AccountNumber: accountNumber,
+			Amount:        amount,
+			TransactionId: transactionId,
+			CreatedAt:     time.Unix(current.Unix(), 0),
+		}
+		operation.Id, err = tx.dialect.insertReturningId(ctx, tx.conn(), insertAtmOperationSQL,
+			sql.Named("atm_id", atmId),
+			sql.Named("kind", string(kind)),
+			sql.Named("bank_account_id", accountId),
+			sql.Named("amount", amount),
+			sql.Named("transaction_id", transactionId),
+			sql.Named("created_at", current.Unix()),
+		)
+		if err != nil || kind != KindAtmWithdrawal {
+			return err
+		}
+		operation.Banknotes, err = tx.dispenseCash(ctx, atmId, operation.Id, amount)
+		return err
+	})
+	if err != nil {
+		return AtmOperation{}, err
+	}
+	return operation, nil
+}
+
+// changeBalanceAtAtm never takes a balance below zero.
+func (receiver *Store) changeBalanceAtAtm(ctx context.Context,
+	kind TransactionKind, clientId, accountNumber, amount int64) error {
+
+	query := increaseBalanceByClientIdAndAccountNumberSQL
+	if kind == KindAtmWithdrawal {
+		query = withdrawFromBankAccountSQL
+	}
+	result, err := receiver.conn().ExecContext(ctx, query,
+		sql.Named("amount", amount),
+		sql.Named("id", clientId),
+		sql.Named("account_number", accountNumber),
+	)
+	if err != nil {
+		return queryError(query, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated != 0 {
+		return nil
+	}
+
+	var accountId, balance int64
+	err = receiver.conn().QueryRowContext(ctx, clientAccounts.getIdAndBalance,
+		sql.Named("id", clientId),
+		sql.Named("account_number", accountNumber),
+	).Scan(&accountId, &balance)
+	if err == sql.ErrNoRows {
+		return accountError(LedgerClient, clientId, accountNumber, ErrAccountNotFound)
+	}
+	if err != nil {
+		return queryError(clientAccounts.getIdAndBalance, err)
+	}
+	return accountError(LedgerClient, clientId, accountNumber, ErrInsufficientFunds)
+}
+
+// AtmOperations returns the operations of an ATM in [from, to), oldest first.
+func (receiver *Store) AtmOperations(ctx context.Context,
+	atmId int64, from, to time.Time) ([]AtmOperation, error) {
+
+	rows, err := receiver.conn().QueryContext(ctx, getAtmOperationsSQL,
+		sql.Named("atm_id", atmId),
+		sql.Named("from", from.Unix()),
+		sql.Named("to", to.Unix()),
+	)
+	if err != nil {
+		return nil, queryError(getAtmOperationsSQL, err)
+	}
+	defer rows.Close()
+
+	operations := make([]AtmOperation, 0)
+	for rows.Next() {
+		var operation AtmOperation
+		var kind string
+		var createdAt int64
+		err = rows.Scan(&operation.Id, &operation.AtmId, &kind, &operation.ClientId,
+			&operation.AccountNumber, &operation.Amount, &operation.TransactionId, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		operation.Kind = TransactionKind(kind)
+		operation.CreatedAt = time.Unix(createdAt, 0)
+		operations = append(operations, operation)
+	}
+	return operations, rows.Err()
+}
+
+func (receiver *Store) ReconcileAtm(ctx context.Context,
+	atmId int64, from, to time.Time) (AtmReconciliation, error) {
+
+	reconciliation := AtmReconciliation{AtmId: atmId, From: from, To: to}
+	operations, err := receiver.AtmOperations(ctx, atmId, from, to)
+	if err != nil {
+		return reconciliation, err
+	}
+	for _, operation := range operations {
+		if operation.Kind == KindAtmWithdrawal {
+			reconciliation.Withdrawals++
+			reconciliation.Withdrawn += operation.Amount
+		} else {
+			reconciliation.Deposits++
+			reconciliation.Deposited += operation.Amount
+		}
+	}
+
+	err = receiver.conn().QueryRowContext(ctx, getAtmLedgerNetSQL,
+		sql.Named("atm_id", atmId),
+		sql.Named("from", from.Unix()),
+		sql.Named("to", to.Unix()),
+	).Scan(&reconciliation.LedgerNet)
+	if err != nil {
+		return reconciliation, queryError(getAtmLedgerNetSQL, err)
+	}
+	return reconciliation, nil
+}
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_atmOperations(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := createDBinFile(t)
+	defer cleanup()
+	store := NewStore(db)
+	err := store.Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddClient(ctx, defaultAdminId, Client{Login: "client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddBankAccountToClient(ctx, defaultAdminId, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.CreateATM(ctx, defaultAdminId, Atm{Address: "first street", CashIn: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.LoadCassette(ctx, defaultAdminId, 1, 1000, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.ReplenishBankAccount(ctx, defaultAdminId, 1, 0, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2020, 5, 1, 10, 0, 0, 0, time.Local)
+	current := start
+	defer func() { now = time.Now }()
+	now = func() time.Time { return current }
+	defer func(limits AtmLimits) { AtmWithdrawalLimits = limits }(AtmWithdrawalLimits)
+	AtmWithdrawalLimits = AtmLimits{PerTransaction: 5000, Daily: 6000}
+
+	operation, err := store.WithdrawAtATM(ctx, 1, 1, 0, 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if operation.Id != 1 || operation.AtmId != 1 || operation.Kind != KindAtmWithdrawal ||
+		operation.Amount != 3000 || !operation.CreatedAt.Equal(current) ||
+		len(operation.Banknotes) != 1 || operation.Banknotes[0] != (Banknotes{1000, 3}) {
+
+		t.Errorf("want a withdrawal of 3000 in 3 banknotes at ATM 1, got: %+v", operation)
+	}
+	for _, test := range []struct {
+		atmId, accountNumber, amount int64
+		want                         error
+	}{
+		{atmId: 9, amount: 100, want: ErrAtmNotFound},
+		{atmId: 1, accountNumber: 5, amount: 100, want: ErrAccountNotFound},
+		{atmId: 1, amount: 0, want: ErrInvalidAmount},
+		{atmId: 1, amount: 5001, want: ErrAtmLimitExceeded},
+		{atmId: 1, amount: 3001, want: ErrAtmLimitExceeded},
+	} {
+		_, err = store.WithdrawAtATM(ctx, test.atmId, 1, test.accountNumber, test.amount)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%+v: want: %v, got: %v", test, test.want, err)
+		}
+	}
+	_, err = store.WithdrawAtATM(ctx, 1, 1, 0, 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current = current.Add(24 * time.Hour)
+	_, err = store.WithdrawAtATM(ctx, 1, 1, 0, 4001)
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("want: %v, got: %v", ErrInsufficientFunds, err)
+	}
+	_, err = store.DepositAtATM(ctx, 1, 1, 0, 2500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, 1, 0, 6500, db)
+
+	reconciliation, err := store.ReconcileAtm(ctx, 1, start, current.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reconciliation.Withdrawals != 2 || reconciliation.Withdrawn != 6000 ||
+		reconciliation.Deposits != 1 || reconciliation.Deposited != 2500 || !reconciliation.Ok() {
+
+		t.Errorf("want 2 withdrawals and a deposit that agree with the journal, got: %+v",
+			reconciliation)
+	}
+	operations, err := store.AtmOperations(ctx, 1, start, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(operations) != 2 {
+		t.Errorf("want operations before now, got: %+v", operations)
+	}
+	page, err := store.AccountHistory(ctx, 1, 0, HistoryFilter{Kinds: []TransactionKind{KindAtmDeposit}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Operations) != 1 || page.Operations[0].Counterparty.Account != LedgerAtm {
+		t.Errorf("want the deposit from the ATM, got: %+v", page.Operations)
+	}
+	report, err := store.VerifyLedger(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Ok() {
+		t.Errorf("want consistent ledger, got: %+v", report)
+	}
+}
+
+func Test_atmCapabilities(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := createDBinFile(t)
+	defer cleanup()
+	store := NewStore(db)
+	err := store.Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddClient(ctx, defaultAdminId, Client{Login: "client"})
+	if err == nil {
+		err = store.AddBankAccountToClient(ctx, defaultAdminId, 1)
+	}
+	if err == nil {
+		err = store.AddBankAccountToClientInCurrency(ctx, defaultAdminId, 1, "USD")
+	}
+	if err == nil {
+		_, err = store.ReplenishBankAccount(ctx, defaultAdminId, 1, 0, 10000)
+	}
+	if err == nil {
+		_, err = store.ReplenishBankAccount(ctx, defaultAdminId, 1, 1, 10000)
+	}
+	if err == nil {
+		err = store.AddATM(ctx, defaultAdminId, "no cash in")
+	}
+	if err == nil {
+		_, err = store.CreateATM(ctx, defaultAdminId, Atm{Address: "dollars", CashIn: true,
+			Currencies: []string{"USD"}})
+	}
+	if err == nil {
+		err = store.LoadCassette(ctx, defaultAdminId, 1, 100, 10)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name                 string
+		operation            func(ctx context.Context, atmId, clientId, accountNumber, amount int64) (AtmOperation, error)
+		atmId, accountNumber int64
+		want                 error
+	}{
+		{name: "deposit without cash in", operation: store.DepositAtATM, atmId: 1, want: ErrAtmUnavailable},
+		{name: "withdrawal without cash in", operation: store.WithdrawAtATM, atmId: 1},
+		{name: "deposit of other currency", operation: store.DepositAtATM, atmId: 1, accountNumber: 1,
+			want: ErrAtmUnavailable},
+		{name: "withdrawal of other currency", operation: store.WithdrawAtATM, atmId: 2,
+			want: ErrAtmUnavailable},
+		{name: "deposit of dollars", operation: store.DepositAtATM, atmId: 2, accountNumber: 1},
+	} {
+		_, err = test.operation(ctx, test.atmId, 1, test.accountNumber, 100)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: want: %v, got: %v", test.name, test.want, err)
+		}
+	}
+	assertBalance(t, 1, 0, 9900, db)
+	assertBalance(t, 1, 1, 10100, db)
+}
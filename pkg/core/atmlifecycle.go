@@ -0,0 +1,290 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type AtmAction string
+
+const (
+	AtmCreated       AtmAction = "created"
+	AtmUpdated       AtmAction = "updated"
+	AtmStatusChanged AtmAction = "status"
+	AtmDeleted       AtmAction = "deleted"
+)
+
+// AtmChange is an entry of the history of an ATM, Atm is the ATM as the
+// change left it. ManagerId is zero for changes no manager made.
+type AtmChange struct {
+	Id        int64
+	AtmId     int64
+	Action    AtmAction
+	ManagerId int64
+	Reason    string
+	Atm       Atm
+	CreatedAt time.Time
+}
+
+// atmTransitions are the statuses an ATM can go to from every status.
+var atmTransitions = map[AtmStatus][]AtmStatus{
+	AtmOnline:      {AtmOffline, AtmMaintenance},
+	AtmOffline:     {AtmOnline, AtmMaintenance},
+	AtmMaintenance: {AtmOnline, AtmOffline},
+}
+
+// CreateATM ignores atm.Id and returns the ATM as it was saved.
+func (receiver *Store) CreateATM(ctx context.Context, managerId int64, atm Atm) (Atm, error) {
+	err := receiver.Authorize(ctx, managerId, PermissionAtmsWrite)
+	if err != nil {
+		return Atm{}, err
+	}
+	atm, err = normalizeAtm(atm)
+	if err != nil {
+		return Atm{}, err
+	}
+	err = receiver.InTx(ctx, func(tx *Store) error {
+		atm.Id, err = tx.dialect.insertReturningId(ctx, tx.conn(), insertAtmWithoutIdSQL,
+			sql.Named("address", atm.Address),
+		)
+		if err != nil {
+			return err
+		}
+		err = tx.setAtmDetails(ctx, atm)
+		if err != nil {
+			return err
+		}
+		return tx.logAtmChange(ctx, AtmCreated, managerId, "", atm)
+	})
+	if err != nil {
+		return Atm{}, err
+	}
+	return atm, nil
+}
+
+// UpdateATM replaces the address and the details of an ATM, its status
+// changes only through SetAtmStatus.
+func (receiver *Store) UpdateATM(ctx context.Context, managerId int64, atm Atm) (Atm, error) {
+	err := receiver.Authorize(ctx, managerId, PermissionAtmsWrite)
+	if err != nil {
+		return Atm{}, err
+	}
+	err = receiver.InTx(ctx, func(tx *Store) error {
+		result, err := tx.conn().ExecContext(ctx, updateAtmAddressSQL,
+			sql.Named("address", atm.Address),
+			sql.Named("id", atm.Id),
+		)
+		if err != nil {
+			return queryError(updateAtmAddressSQL, err)
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return fmt.Errorf("%w: %d", ErrAtmNotFound, atm.Id)
+		}
+
+		current, err := tx.GetATM(ctx, atm.Id)
+		if err != nil {
+			return err
+		}
+		atm.Status = current.Status
+		atm, err = normalizeAtm(atm)
+		if err != nil {
+			return err
+		}
+		err = tx.setAtmDetails(ctx, atm)
+		if err != nil {
+			return err
+		}
+		return tx.logAtmChange(ctx, AtmUpdated, managerId, "", atm)
+	})
+	if err != nil {
+		return Atm{}, err
+	}
+	return atm, nil
+}
+
+// SetAtmStatus moves an ATM along atmTransitions, and the reason for it is
+// kept in the history.
+func (receiver *Store) SetAtmStatus(ctx context.Context,
+	managerId, atmId int64, status AtmStatus, reason string) (atm Atm, err error) {
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return Atm{}, fmt.Errorf("%w: a status change needs a reason", ErrInvalidAtm)
+	}
+	if _, ok := atmTransitions[status]; !ok {
+		return Atm{}, fmt.Errorf("%w: status %q", ErrInvalidAtm, status)
+	}
+	err = receiver.Authorize(ctx, managerId, PermissionAtmsWrite)
+	if err != nil {
+		return Atm{}, err
+	}
+
+	err = receiver.InTx(ctx, func(tx *Store) error {
+		_, err := tx.conn().ExecContext(ctx, lockAtmDetailsSQL, atmId)
+		if tx.dialect.isForeignKeyViolation(err) {
+			return fmt.Errorf("%w: %d", ErrAtmNotFound, atmId)
+		}
+		if err != nil {
+			return queryError(lockAtmDetailsSQL, err)
+		}
+
+		atm, err = tx.GetATM(ctx, atmId)
+		if err != nil {
+			return err
+		}
+		allowed := false
+		for _, next := range atmTransitions[atm.Status] {
+			allowed = allowed || next == status
+		}
+		if !allowed {
+			return fmt.Errorf("%w: ATM %d from %s to %s",
+				ErrInvalidAtmTransition, atmId, atm.Status, status)
+		}
+
+		_, err = tx.conn().ExecContext(ctx, setAtmStatusSQL,
+			sql.Named("status", string(status)),
+			sql.Named("atm_id", atmId),
+		)
+		if err != nil {
+			return queryError(setAtmStatusSQL, err)
+		}
+		atm.Status = status
+		return tx.logAtmChange(ctx, AtmStatusChanged, managerId, reason, atm)
+	})
+	if err != nil {
+		return Atm{}, err
+	}
+	return atm, nil
+}
+
+// DeleteATM takes an ATM out of service for good. Its operations and
+// history stay, but nothing finds or changes it anymore.
+func (receiver *Store) DeleteATM(ctx context.Context, managerId, atmId int64, reason string) error {
+	err := receiver.Authorize(ctx, managerId, PermissionAtmsWrite)
+	if err != nil {
+		return err
+	}
+	return receiver.InTx(ctx, func(tx *Store) error {
+		result, err := tx.conn().ExecContext(ctx, deleteAtmSQL,
+			sql.Named("atm_id", atmId),
+			sql.Named("deleted_at", now().Unix()),
+		)
+		if tx.dialect.isForeignKeyViolation(err) {
+			return fmt.Errorf("%w: %d", ErrAtmNotFound, atmId)
+		}
+		if err != nil {
+			return queryError(deleteAtmSQL, err)
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return fmt.Errorf("%w: %d is deleted already", ErrAtmNotFound, atmId)
+		}
+
+		atm, _, err := tx.atmById(ctx, atmId)
+		if err != nil {
+			return err
+		}
+		return tx.logAtmChange(ctx, AtmDeleted, managerId, strings.TrimSpace(reason), atm)
+	})
+}
+
+func (receiver *Store) GetATM(ctx context.Context, atmId int64) (Atm, error) {
+	atm, deleted, err := receiver.atmById(ctx, atmId)
+	if err != nil {
+		return Atm{}, err
+	}
+	if deleted {
+		return Atm{}, fmt.Errorf("%w: %d is deleted", ErrAtmNotFound, atmId)
+	}
+	return atm, nil
+}
+func (receiver *Store) atmById(ctx context.Context, atmId int64) (atm Atm, deleted bool, err error) {
+	atm, err = scanAtm(receiver.conn().QueryRowContext(ctx, getAtmSQL, atmId), &deleted)
+	if err == sql.ErrNoRows {
+		return Atm{}, false, fmt.Errorf("%w: %d", ErrAtmNotFound, atmId)
+	}
+	if err != nil {
+		return Atm{}, false, queryError(getAtmSQL, err)
+	}
+	return atm, deleted, nil
+}
+
+// ListATMs returns the ATMs that aren't deleted.
+func (receiver *Store) ListATMs(ctx context.Context) ([]Atm, error) {
+	rows, err := receiver.conn().QueryContext(ctx, getAllAtmDataSQL)
+	if err != nil {
+		return nil, queryError(getAllAtmDataSQL, err)
+	}
+	defer rows.Close()
+
+	atms := make([]Atm, 0)
+	for rows.Next() {
+		atm, err := scanAtm(rows)
+		if err != nil {
+			return nil, err
+		}
+		atms = append(atms, atm)
+	}
+	return atms, rows.Err()
+}
+
+// AtmHistory returns the changes of an ATM, oldest first.
+func (receiver *Store) AtmHistory(ctx context.Context, atmId int64) ([]AtmChange, error) {
+	rows, err := receiver.conn().QueryContext(ctx, getAtmHistorySQL, atmId)
+	if err != nil {
+		return nil, queryError(getAtmHistorySQL, err)
+	}
+	defer rows.Close()
+
+	changes := make([]AtmChange, 0)
+	for rows.Next() {
+		var change AtmChange
+		var action, state string
+		var createdAt int64
+		err = rows.Scan(&change.Id, &change.AtmId, &action, &change.ManagerId,
+			&change.Reason, &state, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(state), &change.Atm)
+		if err != nil {
+			return nil, err
+		}
+		change.Action = AtmAction(action)
+		change.CreatedAt = time.Unix(createdAt, 0)
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+func (receiver *Store) logAtmChange(ctx context.Context,
+	action AtmAction, managerId int64, reason string, atm Atm) error {
+
+	state, err := json.Marshal(atm)
+	if err != nil {
+		return err
+	}
+	_, err = receiver.conn().ExecContext(ctx, insertAtmHistorySQL,
+		sql.Named("atm_id", atm.Id),
+		sql.Named("action", string(action)),
+		sql.Named("manager_id", nullId(managerId)),
+		sql.Named("reason", reason),
+		sql.Named("state", string(state)),
+		sql.Named("created_at", now().Unix()),
+	)
+	if err != nil {
+		return queryError(insertAtmHistorySQL, err)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_atmLifecycle(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := createDBinFile(t)
+	defer cleanup()
+	store := NewStore(db)
+	err := store.Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddClient(ctx, defaultAdminId, Client{Login: "client"})
+	if err == nil {
+		err = store.AddBankAccountToClient(ctx, defaultAdminId, 1)
+	}
+	if err == nil {
+		_, err = store.ReplenishBankAccount(ctx, defaultAdminId, 1, 0, 10000)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	atm, err := store.CreateATM(ctx, defaultAdminId, Atm{Address: "Rudaki 1", Location: &GeoPoint{38.5688, 68.787}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Atm{Id: 1, Address: "Rudaki 1", Location: &GeoPoint{38.5688, 68.787},
+		Status: AtmOnline, Currencies: []string{"TJS"}}
+	if !reflect.DeepEqual(atm, want) {
+		t.Errorf("want: %+v, got: %+v", want, atm)
+	}
+	want.Address, want.CashIn, want.OpeningHours = "Rudaki 10", true, "08:00-22:00"
+	atm, err = store.UpdateATM(ctx, defaultAdminId, Atm{Id: 1, Address: "Rudaki 10", Location: want.Location,
+		CashIn: true, OpeningHours: "08:00-22:00", Status: AtmOffline})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.GetATM(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(atm, want) || !reflect.DeepEqual(got, want) {
+		t.Errorf("want an update that keeps the status: %+v, got: %+v and %+v", want, atm, got)
+	}
+
+	for _, test := range []struct {
+		status AtmStatus
+		reason string
+		want   error
+	}{
+		{status: AtmMaintenance, want: ErrInvalidAtm},
+		{status: "closed", reason: "why not", want: ErrInvalidAtm},
+		{status: AtmOnline, reason: "again", want: ErrInvalidAtmTransition},
+		{status: AtmMaintenance, reason: "cash jam"},
+	} {
+		_, err = store.SetAtmStatus(ctx, defaultAdminId, 1, test.status, test.reason)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%+v: want: %v, got: %v", test, test.want, err)
+		}
+	}
+	_, err = store.WithdrawAtATM(ctx, 1, 1, 0, 100)
+	if !errors.Is(err, ErrAtmUnavailable) {
+		t.Errorf("want: %v, got: %v", ErrAtmUnavailable, err)
+	}
+	assertBalance(t, 1, 0, 10000, db)
+	_, err = store.SetAtmStatus(ctx, defaultAdminId, 1, AtmOnline, "fixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.DepositAtATM(ctx, 1, 1, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.AddATM(ctx, defaultAdminId, "Somoni 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	atm, err = store.SetAtmStatus(ctx, defaultAdminId, 2, AtmOffline, "no power")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atm.Address != "Somoni 2" || atm.Status != AtmOffline {
+		t.Errorf("want Somoni 2 offline, got: %+v", atm)
+	}
+
+	err = store.DeleteATM(ctx, defaultAdminId, 2, "moved out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.GetATM(ctx, 2)
+	if !errors.Is(err, ErrAtmNotFound) {
+		t.Errorf("want: %v, got: %v", ErrAtmNotFound, err)
+	}
+	for name, change := range map[string]func() error{
+		"delete": func() error { return store.DeleteATM(ctx, defaultAdminId, 2, "again") },
+		"update": func() error {
+			_, err := store.UpdateATM(ctx, defaultAdminId, Atm{Id: 2, Address: "Somoni 3"})
+			return err
+		},
+		"status": func() error {
+			_, err := store.SetAtmStatus(ctx, defaultAdminId, 2, AtmOnline, "back")
+			return err
+		},
+		"deposit": func() error {
+			_, err := store.DepositAtATM(ctx, 2, 1, 0, 100)
+			return err
+		},
+		"missing": func() error { return store.DeleteATM(ctx, defaultAdminId, 9, "") },
+	} {
+		err = change()
+		if !errors.Is(err, ErrAtmNotFound) {
+			t.Errorf("%s: want: %v, got: %v", name, ErrAtmNotFound, err)
+		}
+	}
+	atms, err := store.ListATMs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses, err := store.AtmsList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(atms) != 1 || atms[0].Id != 1 || len(addresses) != 1 {
+		t.Errorf("want only the ATM that is left, got: %+v and %v", atms, addresses)
+	}
+
+	history, err := store.AtmHistory(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := make([]AtmAction, 0)
+	for _, change := range history {
+		actions = append(actions, change.Action)
+	}
+	wantActions := []AtmAction{AtmCreated, AtmUpdated, AtmStatusChanged, AtmStatusChanged}
+	if !reflect.DeepEqual(actions, wantActions) || history[2].Reason != "cash jam" ||
+		history[2].Atm.Status != AtmMaintenance || history[1].Atm.Address != "Rudaki 10" {
+
+		t.Errorf("want the changes of ATM 1: %v, got: %+v", wantActions, history)
+	}
+	history, err = store.AtmHistory(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[0].Action != AtmCreated ||
+		history[2].Action != AtmDeleted || history[2].Reason != "moved out" {
+
+		t.Errorf("want ATM 2 deleted, got: %+v", history)
+	}
+}
+
+func Test_atmsExportImport(t *testing.T) {
+	ctx := context.Background()
+	defer os.Remove("atms.json")
+	defer os.Remove("atms.xml")
+
+	db, cleanup := createDBinFile(t)
+	defer cleanup()
+	store := NewStore(db)
+	err := store.Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, atm := range []Atm{
+		{Address: "Rudaki 1", Location: &GeoPoint{38.5688, 68.787}, OpeningHours: "22:00-06:00",
+			Status: AtmMaintenance, CashIn: true, Currencies: []string{"TJS", "USD"}},
+		{Address: "Somoni 2"},
+		{Address: "Bokhtar 3"},
+	} {
+		_, err = store.CreateATM(ctx, defaultAdminId, atm)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.DeleteATM(ctx, defaultAdminId, 3, "closed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := store.ListATMs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.ExportAtmsToJSON(ctx, defaultAdminId)
+	if err == nil {
+		err = store.ExportAtmsToXML(ctx, defaultAdminId)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, importAtms := range map[string]func(*Store, context.Context, int64) error{
+		"json": (*Store).ImportAtmsFromJSON,
+		"xml":  (*Store).ImportAtmsFromXML,
+	} {
+		db, cleanup := createDBinFile(t)
+		store := NewStore(db)
+		err = store.Init(ctx)
+		if err == nil {
+			err = importAtms(store, ctx, defaultAdminId)
+		}
+		if err != nil {
+			cleanup()
+			t.Fatal(name, err)
+		}
+		got, err := store.ListATMs(ctx)
+		if err != nil {
+			cleanup()
+			t.Fatal(name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: want: %+v, got: %+v", name, want, got)
+		}
+		_, err = store.GetATM(ctx, 3)
+		if !errors.Is(err, ErrAtmNotFound) {
+			t.Errorf("%s: want ATM 3 deleted, got: %v", name, err)
+		}
+		atm, err := store.CreateATM(ctx, defaultAdminId, Atm{Address: "Bazaar"})
+		if err != nil || atm.Id != 4 {
+			t.Errorf("%s: want new ATMs after the imported ones, got: %+v, %v", name, atm, err)
+		}
+
+		// the ATMs that are there already take the details of the file
+		_, err = store.UpdateATM(ctx, defaultAdminId, Atm{Id: 2, Address: "Somoni 20"})
+		if err == nil {
+			err = importAtms(store, ctx, defaultAdminId)
+		}
+		if err != nil {
+			cleanup()
+			t.Fatal(name, err)
+		}
+		atm, err = store.GetATM(ctx, 2)
+		if err != nil || atm.Address != "Somoni 2" {
+			t.Errorf("%s: want ATM 2 at Somoni 2, got: %+v, %v", name, atm, err)
+		}
+		history, err := store.AtmHistory(ctx, 2)
+		if err != nil || len(history) != 3 || history[2].Action != AtmUpdated || history[2].Reason != "import" {
+			t.Errorf("%s: want the import in the history of ATM 2, got: %+v, %v", name, history, err)
+		}
+		cleanup()
+	}
+}
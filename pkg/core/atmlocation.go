@@ -0,0 +1,307 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GeoPoint is in degrees.
+type GeoPoint struct {
+	Latitude  float64
+	Longitude float64
+}
+
+type AtmStatus string
+
+const (
+	AtmOnline      AtmStatus = "online"
+	AtmOffline     AtmStatus = "offline"
+	AtmMaintenance AtmStatus = "maintenance"
+)
+
+// AtmFilter zero values mean "no restriction". OpenAt keeps the ATMs that
+// are open at the wall clock time of OpenAt.
+type AtmFilter struct {
+	Status   AtmStatus
+	CashIn   bool
+	Currency string
+	OpenAt   time.Time
+	Limit    int
+	Offset   int
+}
+
+// NearbyAtm is an ATM Distance meters away.
+type NearbyAtm struct {
+	Atm
+	Distance float64
+}
+
+type NearbyAtmsPage struct {
+	Atms    []NearbyAtm
+	HasMore bool
+}
+
+const (
+	defaultAtmsLimit = 20
+	maxAtmsLimit     = 100
+	// earthRadius is the mean radius in meters
+	earthRadius = 6_371_008.8
+)
+
+// setAtmDetails replaces everything about the ATM but its address.
+func (receiver *Store) setAtmDetails(ctx context.Context, atm Atm) error {
+	atm, err := normalizeAtm(atm)
+	if err != nil {
+		return err
+	}
+	var latitude, longitude sql.NullFloat64
+	if atm.Location != nil {
+		latitude = sql.NullFloat64{Float64: atm.Location.Latitude, Valid: true}
+		longitude = sql.NullFloat64{Float64: atm.Location.Longitude, Valid: true}
+	}
+	_, err = receiver.conn().ExecContext(ctx, setAtmDetailsSQL,
+		sql.Named("atm_id", atm.Id),
+		sql.Named("latitude", latitude),
+		sql.Named("longitude", longitude),
+		sql.Named("opening_hours", atm.OpeningHours),
+		sql.Named("status", string(atm.Status)),
+		sql.Named("cash_in", atm.CashIn),
+		sql.Named("currencies", strings.Join(atm.Currencies, ",")),
+	)
+	if receiver.dialect.isForeignKeyViolation(err) {
+		return fmt.Errorf("%w: %d", ErrAtmNotFound, atm.Id)
+	}
+	if err != nil {
+		return queryError(setAtmDetailsSQL, err)
+	}
+	return nil
+}
+
+// normalizeAtm fills in the defaults and checks the rest.
+func normalizeAtm(atm Atm) (Atm, error) {
+	if atm.Location != nil && !atm.Location.valid() {
+		return atm, fmt.Errorf("%w: %+v", ErrInvalidLocation, *atm.Location)
+	}
+	switch atm.Status {
+	case "":
+		atm.Status = AtmOnline
+	case AtmOnline, AtmOffline, AtmMaintenance:
+	default:
+		return atm, fmt.Errorf("%w: status %q", ErrInvalidAtm, atm.Status)
+	}
+	_, _, err := parseOpeningHours(atm.OpeningHours)
+	if err != nil {
+		return atm, err
+	}
+	if len(atm.Currencies) == 0 {
+		atm.Currencies = []string{DefaultCurrency}
+	}
+	currencies := make([]string, len(atm.Currencies))
+	for i, code := range atm.Currencies {
+		currency, err := LookupCurrency(code)
+		if err != nil {
+			return atm, fmt.Errorf("%w: %v", ErrInvalidAtm, err)
+		}
+		currencies[i] = currency.Code
+	}
+	atm.Currencies = currencies
+	return atm, nil
+}
+
+// parseOpeningHours takes "09:00-18:00", or "22:00-06:00" over midnight,
+// and returns minutes since midnight. Empty hours are around the clock.
+func parseOpeningHours(hours string) (from, to int, err error) {
+	if hours == "" {
+		return 0, 24 * 60, nil
+	}
+	parts := strings.Split(hours, "-")
+	if len(parts) == 2 {
+		from, err = parseClock(parts[0])
+		if err == nil {
+			to, err = parseClock(parts[1])
+		}
+		if err == nil && from != to && from < 24*60 {
+			return from, to, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("%w: opening hours %q", ErrInvalidAtm, hours)
+}
+func parseClock(clock string) (int, error) {
+	var hour, minute int
+	_, err := fmt.Sscanf(strings.TrimSpace(clock), "%d:%d", &hour, &minute)
+	if err != nil {
+		return 0, err
+	}
+	if hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("no such time %q", clock)
+	}
+	return hour*60 + minute, nil
+}
+
+func (receiver Atm) openAt(at time.Time) bool {
+	from, to, err := parseOpeningHours(receiver.OpeningHours)
+	if err != nil {
+		return false
+	}
+	minute := at.Hour()*60 + at.Minute()
+	if from < to {
+		return from <= minute && minute < to
+	}
+	return minute >= from || minute < to
+}
+
+func (receiver AtmFilter) matches(atm Atm) bool {
+	if receiver.Status != "" && atm.Status != receiver.Status {
+		return false
+	}
+	if receiver.CashIn && !atm.CashIn {
+		return false
+	}
+	if receiver.Currency != "" && !atm.hasCurrency(receiver.Currency) {
+		return false
+	}
+	return receiver.OpenAt.IsZero() || atm.openAt(receiver.OpenAt)
+}
+
+func (receiver Atm) hasCurrency(code string) bool {
+	for _, currency := range receiver.Currencies {
+		if strings.EqualFold(currency, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindNearestATMs returns the ATMs within radius meters of the point that
+// pass the filter, nearest first.
+func (receiver *Store) FindNearestATMs(ctx context.Context,
+	latitude, longitude, radius float64, filter AtmFilter) (NearbyAtmsPage, error) {
+
+	center := GeoPoint{Latitude: latitude, Longitude: longitude}
+	if !center.valid() || !(radius > 0) {
+		return NearbyAtmsPage{}, fmt.Errorf("%w: %+v, radius %v", ErrInvalidLocation, center, radius)
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAtmsLimit
+	}
+	if limit > maxAtmsLimit {
+		limit = maxAtmsLimit
+	}
+	offset := filter.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	// the box narrows the search down for the index, distances are exact
+	min, max := center.boundingBox(radius)
+	rows, err := receiver.conn().QueryContext(ctx, getAtmsInBoxSQL,
+		sql.Named("min_latitude", min.Latitude),
+		sql.Named("max_latitude", max.Latitude),
+		sql.Named("min_longitude", min.Longitude),
+		sql.Named("max_longitude", max.Longitude),
+	)
+	if err != nil {
+		return NearbyAtmsPage{}, queryError(getAtmsInBoxSQL, err)
+	}
+	defer rows.Close()
+
+	nearby := make([]NearbyAtm, 0)
+	for rows.Next() {
+		atm, err := scanAtm(rows)
+		if err != nil {
+			return NearbyAtmsPage{}, err
+		}
+		distance := center.distanceTo(*atm.Location)
+		if distance <= radius && filter.matches(atm) {
+			nearby = append(nearby, NearbyAtm{Atm: atm, Distance: distance})
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return NearbyAtmsPage{}, err
+	}
+
+	sort.Slice(nearby, func(i, j int) bool {
+		if nearby[i].Distance != nearby[j].Distance {
+			return nearby[i].Distance < nearby[j].Distance
+		}
+		return nearby[i].Id < nearby[j].Id
+	})
+	page := NearbyAtmsPage{Atms: make([]NearbyAtm, 0)}
+	if offset < len(nearby) {
+		page.Atms = nearby[offset:]
+	}
+	if len(page.Atms) > limit {
+		page.Atms = page.Atms[:limit]
+		page.HasMore = true
+	}
+	return page, nil
+}
+
+// scanAtm reads id, address and then the columns of atm_details, and
+// whatever else the query has into more.
+func scanAtm(row interface{ Scan(...interface{}) error }, more ...interface{}) (Atm, error) {
+	var atm Atm
+	var latitude, longitude sql.NullFloat64
+	var status, currencies string
+	err := row.Scan(append([]interface{}{&atm.Id, &atm.Address, &latitude, &longitude,
+		&atm.OpeningHours, &status, &atm.CashIn, &currencies}, more...)...)
+	if err != nil {
+		return Atm{}, err
+	}
+	if latitude.Valid && longitude.Valid {
+		atm.Location = &GeoPoint{Latitude: latitude.Float64, Longitude: longitude.Float64}
+	}
+	atm.Status = AtmStatus(status)
+	atm.Currencies = strings.Split(currencies, ",")
+	return atm, nil
+}
+
+func (receiver GeoPoint) valid() bool {
+	return receiver.Latitude >= -90 && receiver.Latitude <= 90 &&
+		receiver.Longitude >= -180 && receiver.Longitude <= 180
+}
+
+// distanceTo is the haversine distance in meters.
+func (receiver GeoPoint) distanceTo(other GeoPoint) float64 {
+	latitude1, latitude2 := radians(receiver.Latitude), radians(other.Latitude)
+	sinLatitude := math.Sin((latitude2 - latitude1) / 2)
+	sinLongitude := math.Sin(radians(other.Longitude-receiver.Longitude) / 2)
+	h := sinLatitude*sinLatitude +
+		math.Cos(latitude1)*math.Cos(latitude2)*sinLongitude*sinLongitude
+	return 2 * earthRadius * math.Asin(math.Sqrt(math.Min(1, h)))
+}
+
+// boundingBox holds every point within radius meters. Near the poles and
+// across the antimeridian it takes every longitude.
+func (receiver GeoPoint) boundingBox(radius float64) (min, max GeoPoint) {
+	angle := radius / earthRadius
+	min = GeoPoint{Latitude: receiver.Latitude - degrees(angle), Longitude: -180}
+	max = GeoPoint{Latitude: receiver.Latitude + degrees(angle), Longitude: 180}
+	if min.Latitude <= -90 || max.Latitude >= 90 {
+		return min, max
+	}
+	spread := math.Sin(angle) / math.Cos(radians(receiver.Latitude))
+	if spread >= 1 {
+		return min, max
+	}
+	delta := degrees(math.Asin(spread))
+	if receiver.Longitude-delta < -180 || receiver.Longitude+delta > 180 {
+		return min, max
+	}
+	min.Longitude, max.Longitude = receiver.Longitude-delta, receiver.Longitude+delta
+	return min, max
+}
+
+func radians(angle float64) float64 {
+	return angle * math.Pi / 180
+}
+func degrees(angle float64) float64 {
+	return angle * 180 / math.Pi
+}
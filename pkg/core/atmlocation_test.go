@@ -0,0 +1,144 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func Test_findNearestATMs(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := createDBinFile(t)
+	defer cleanup()
+	store := NewStore(db)
+	err := store.Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, atm := range []Atm{
+		{Address: "Rudaki 1", Location: &GeoPoint{38.5688, 68.7870}, CashIn: true,
+			Currencies: []string{"tjs", "usd"}},
+		{Address: "Somoni 2", Location: &GeoPoint{38.5598, 68.8100}, Status: AtmMaintenance},
+		{Address: "Khujand", Location: &GeoPoint{40.2833, 69.6222}},
+		{Address: "Unknown"},
+		{Address: "Bazaar", Location: &GeoPoint{38.5598, 68.7870}, OpeningHours: "09:00-18:00"},
+	} {
+		_, err = store.CreateATM(ctx, defaultAdminId, atm)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		atm  Atm
+		want error
+	}{
+		{atm: Atm{Location: &GeoPoint{91, 0}}, want: ErrInvalidLocation},
+		{atm: Atm{Status: "closed"}, want: ErrInvalidAtm},
+		{atm: Atm{OpeningHours: "09:00-25:00"}, want: ErrInvalidAtm},
+		{atm: Atm{OpeningHours: "09:00"}, want: ErrInvalidAtm},
+		{atm: Atm{Currencies: []string{"XXX"}}, want: ErrInvalidAtm},
+	} {
+		_, err = store.CreateATM(ctx, defaultAdminId, test.atm)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%+v: want: %v, got: %v", test.atm, test.want, err)
+		}
+	}
+
+	ids := func(page NearbyAtmsPage) []int64 {
+		ids := make([]int64, 0)
+		for _, atm := range page.Atms {
+			ids = append(ids, atm.Id)
+		}
+		return ids
+	}
+	for _, test := range []struct {
+		radius  float64
+		filter  AtmFilter
+		want    []int64
+		hasMore bool
+	}{
+		{radius: 3000, want: []int64{5, 1, 2}},
+		{radius: 1500, want: []int64{5, 1}},
+		{radius: 300_000, want: []int64{5, 1, 2, 3}},
+		{radius: 3000, filter: AtmFilter{Status: AtmOnline}, want: []int64{5, 1}},
+		{radius: 3000, filter: AtmFilter{CashIn: true}, want: []int64{1}},
+		{radius: 3000, filter: AtmFilter{Currency: "usd"}, want: []int64{1}},
+		{radius: 3000, filter: AtmFilter{OpenAt: time.Date(2020, 5, 1, 20, 0, 0, 0, time.UTC)},
+			want: []int64{1, 2}},
+		{radius: 3000, filter: AtmFilter{Limit: 2}, want: []int64{5, 1}, hasMore: true},
+		{radius: 3000, filter: AtmFilter{Limit: 2, Offset: 2}, want: []int64{2}},
+		{radius: 3000, filter: AtmFilter{Offset: 5}, want: []int64{}},
+	} {
+		page, err := store.FindNearestATMs(ctx, 38.5598, 68.7870, test.radius, test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ids(page)
+		if len(got) != len(test.want) || page.HasMore != test.hasMore {
+			t.Errorf("%v %+v: want: %v, got: %v, more: %v", test.radius, test.filter, test.want, got, page.HasMore)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%v %+v: want: %v, got: %v", test.radius, test.filter, test.want, got)
+				break
+			}
+		}
+	}
+
+	page, err := store.FindNearestATMs(ctx, 38.5598, 68.7870, 1500, AtmFilter{CashIn: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	atm := page.Atms[0]
+	if math.Abs(atm.Distance-1000.76) > 1 || atm.Address != "Rudaki 1" || atm.Status != AtmOnline ||
+		len(atm.Currencies) != 2 || atm.Currencies[1] != "USD" {
+
+		t.Errorf("want Rudaki 1 a kilometer away, got: %+v", atm)
+	}
+	_, err = store.FindNearestATMs(ctx, 38.5598, 181, 1000, AtmFilter{})
+	if !errors.Is(err, ErrInvalidLocation) {
+		t.Errorf("want: %v, got: %v", ErrInvalidLocation, err)
+	}
+	_, err = store.FindNearestATMs(ctx, 38.5598, 68.7870, 0, AtmFilter{})
+	if !errors.Is(err, ErrInvalidLocation) {
+		t.Errorf("want: %v, got: %v", ErrInvalidLocation, err)
+	}
+
+	// across the antimeridian the box takes every longitude
+	_, err = store.UpdateATM(ctx, defaultAdminId, Atm{Id: 3, Address: "Khujand", Location: &GeoPoint{0, 179.999}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err = store.FindNearestATMs(ctx, 0, -179.999, 1000, AtmFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Atms) != 1 || page.Atms[0].Id != 3 || math.Abs(page.Atms[0].Distance-222.39) > 1 {
+		t.Errorf("want the ATM on the other side of the antimeridian, got: %+v", page.Atms)
+	}
+}
+
+func Test_atmOpenAt(t *testing.T) {
+	for _, test := range []struct {
+		hours string
+		clock int
+		want  bool
+	}{
+		{hours: "", clock: 3, want: true},
+		{hours: "09:00-18:00", clock: 9, want: true},
+		{hours: "09:00-18:00", clock: 18, want: false},
+		{hours: "22:00-06:00", clock: 23, want: true},
+		{hours: "22:00-06:00", clock: 5, want: true},
+		{hours: "22:00-06:00", clock: 12, want: false},
+		{hours: "08:00-24:00", clock: 23, want: true},
+	} {
+		at := time.Date(2020, 5, 1, test.clock, 0, 0, 0, time.UTC)
+		got := Atm{OpeningHours: test.hours}.openAt(at)
+		if got != test.want {
+			t.Errorf("%q at %d: want: %v, got: %v", test.hours, test.clock, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+type CardStatus string
+
+const (
+	CardActive  CardStatus = "active"
+	CardBlocked CardStatus = "blocked"
+	// CardExpired isn't stored, a card is expired from ExpiresAt on
+	CardExpired CardStatus = "expired"
+)
+
+// Card is a debit card of a client bank account. Pan is the full card
+// number, Masked is the one to show. A reissued card is blocked and
+// ReplacedBy is its new card.
+type Card struct {
+	Id            int64
+	ClientId      int64
+	AccountNumber int64
+	Pan           string
+	ExpiresAt     time.Time
+	Status        CardStatus
+	BlockReason   string
+	ReplacedBy    int64
+	CreatedAt     time.Time
+}
+
+var (
+	// CardBIN starts the number of every card the bank issues.
+	CardBIN = "415000"
+	// CardValidity is in months, the month of issue is the first one and a
+	// card expires at the end of the last one.
+	CardValidity = 48
+)
+
+const (
+	cardScope    = "card"
+	panDigits    = 16
+	panAttempts  = 10
+	minPinDigits = 4
+	maxPinDigits = 6
+)
+
+// Masked keeps the BIN and the last four digits: "4150 00** **** 1234".
+func (receiver Card) Masked() string {
+	if len(receiver.Pan) != panDigits {
+		return receiver.Pan
+	}
+	masked := receiver.Pan[:6] + "******" + receiver.Pan[12:]
+	return masked[:4] + " " + masked[4:8] + " " + masked[8:12] + " " + masked[12:]
+}
+
+// Expiry is the month printed on the card, as MM/YY.
+func (receiver Card) Expiry() string {
+	return receiver.ExpiresAt.AddDate(0, 0, -1).Format("01/06")
+}
+
+func (receiver *Store) IssueCard(ctx context.Context,
+	managerId, clientId, accountNumber int64, pin string) (Card, error) {
+
+	err := receiver.Authorize(ctx, managerId, PermissionCardsWrite)
+	if err != nil {
+		return Card{}, err
+	}
+	pinHash, err := hashPin(pin)
+	if err != nil {
+		return Card{}, err
+	}
+	accountId, err := receiver.clientBankAccountId(ctx, clientId, accountNumber)
+	if err != nil {
+		return Card{}, err
+	}
+	cardId, err := receiver.insertCard(ctx, accountId, pinHash)
+	if err != nil {
+		return Card{}, err
+	}
+	return receiver.GetCard(ctx, cardId)
+}
+
+// ReissueCard gives the account of a card a new one with a new number and
+// expiry and the same PIN. The old card is blocked for good.
+func (receiver *Store) ReissueCard(ctx context.Context, managerId, cardId int64) (Card, error) {
+	err := receiver.Authorize(ctx, managerId, PermissionCardsWrite)
+	if err != nil {
+		return Card{}, err
+	}
+	var newId int64
+	err = receiver.InTx(ctx, func(tx *Store) error {
+		result, err := tx.conn().ExecContext(ctx, retireCardSQL, cardId)
+		if err != nil {
+			return queryError(retireCardSQL, err)
+		}
+		retired, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if retired == 0 {
+			_, err = tx.GetCard(ctx, cardId)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("%w: %d is reissued already", ErrCardNotActive, cardId)
+		}
+
+		var accountId int64
+		var pinHash string
+		err = tx.conn().QueryRowContext(ctx, getCardPinHashSQL, cardId).Scan(&accountId, &pinHash)
+		if err != nil {
+			return queryError(getCardPinHashSQL, err)
+		}
+		newId, err = tx.insertCard(ctx, accountId, pinHash)
+		if err != nil {
+			return err
+		}
+		_, err = tx.conn().ExecContext(ctx, setCardReplacementSQL,
+			sql.Named("replaced_by", newId),
+			sql.Named("id", cardId),
+		)
+		if err != nil {
+			return queryError(setCardReplacementSQL, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Card{}, err
+	}
+	return receiver.GetCard(ctx, newId)
+}
+
+func (receiver *Store) insertCard(ctx context.Context, accountId int64, pinHash string) (int64, error) {
+	pan, err := receiver.newPan(ctx)
+	if err != nil {
+		return 0, err
+	}
+	current := now()
+	year, month, _ := current.Date()
+	expiresAt := time.Date(year, month+time.Month(CardValidity), 1, 0, 0, 0, 0, current.Location())
+	return receiver.dialect.insertReturningId(ctx, receiver.conn(), insertCardSQL,
+		sql.Named("bank_account_id", accountId),
+		sql.Named("pan", pan),
+		sql.Named("pin_hash", pinHash),
+		sql.Named("expires_at", expiresAt.Unix()),
+		sql.Named("created_at", current.Unix()),
+	)
+}
+
+// newPan draws card numbers until one is free: CardBIN, random digits and
+// the Luhn check digit.
+func (receiver *Store) newPan(ctx context.Context) (string, error) {
+	digits := panDigits - len(CardBIN) - 1
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+	for attempt := 0; attempt < panAttempts; attempt++ {
+		number, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", err
+		}
+		payload := fmt.Sprintf("%s%0*d", CardBIN, digits, number)
+		pan := payload + strconv.Itoa(luhnCheckDigit(payload))
+
+		var taken int
+		err = receiver.conn().QueryRowContext(ctx, panExistsSQL, pan).Scan(&taken)
+		if err != nil {
+			return "", queryError(panExistsSQL, err)
+		}
+		if taken == 0 {
+			return pan, nil
+		}
+	}
+	return "", fmt.Errorf("no free card number in %d attempts", panAttempts)
+}
+
+func (receiver *Store) BlockCard(ctx context.Context, managerId, cardId int64, reason string) error {
+	return receiver.changeCardStatus(ctx, managerId, cardId, blockCardSQL, ErrCardNotActive,
+		sql.Named("reason", reason),
+		sql.Named("id", cardId),
+	)
+}
+
+// UnblockCard doesn't bring back expired or reissued cards.
+func (receiver *Store) UnblockCard(ctx context.Context, managerId, cardId int64) error {
+	return receiver.changeCardStatus(ctx, managerId, cardId, unblockCardSQL, ErrCardNotBlocked,
+		sql.Named("id", cardId),
+		sql.Named("now", now().Unix()),
+	)
+}
+
+// changeCardStatus runs a conditional update, when it changes nothing the
+// card is either missing or in the wrong status.
+func (receiver *Store) changeCardStatus(ctx context.Context, managerId, cardId int64,
+	query string, wrongStatus error, args ...interface{}) error {
+
+	err := receiver.Authorize(ctx, managerId, PermissionCardsWrite)
+	if err != nil {
+		return err
+	}
+	result, err := receiver.conn().ExecContext(ctx, query, args...)
+	if err != nil {
+		return queryError(query, err)
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if changed != 0 {
+		return nil
+	}
+	card, err := receiver.GetCard(ctx, cardId)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %d is %s", wrongStatus, cardId, card.Status)
+}
+
+// CheckCardPin returns the card when it's active and the PIN is right.
+// Wrong PINs are throttled like wrong passwords, per card. Unknown numbers
+// fail like wrong PINs, and only the right PIN tells the card isn't active.
+func (receiver *Store) CheckCardPin(ctx context.Context, pan, pin string) (Card, error) {
+	err := receiver.checkLoginThrottle(ctx, cardScope, pan, "")
+	if err != nil {
+		return Card{}, err
+	}
+	card, err := receiver.queryCard(ctx, getCardByPanSQL, pan)
+	found := err == nil
+	if err != nil && !errors.Is(err, ErrCardNotFound) {
+		return Card{}, err
+	}
+
+	pinHash := dummyPasswordHash()
+	if found {
+		var accountId int64
+		err = receiver.conn().QueryRowContext(ctx, getCardPinHashSQL, card.Id).Scan(&accountId, &pinHash)
+		if err != nil {
+			return Card{}, queryError(getCardPinHashSQL, err)
+		}
+	}
+	ok, _, err := verifyPassword(pinHash, pin)
+	if err != nil {
+		return Card{}, err
+	}
+	if !ok || !found {
+		err = receiver.recordLoginFailure(ctx, cardScope, pan, "")
+		if err != nil {
+			return Card{}, err
+		}
+		return Card{}, ErrInvalidPin
+	}
+	err = receiver.resetLoginFailures(ctx, cardScope, pan)
+	if err != nil {
+		return Card{}, err
+	}
+	if card.Status != CardActive {
+		return Card{}, fmt.Errorf("%w: %s is %s", ErrCardNotActive, card.Masked(), card.Status)
+	}
+	return card, nil
+}
+
+func (receiver *Store) GetCard(ctx context.Context, cardId int64) (Card, error) {
+	return receiver.queryCard(ctx, getCardByIdSQL, cardId)
+}
+func (receiver *Store) queryCard(ctx context.Context, query string, key interface{}) (Card, error) {
+	card, err := scanCard(receiver.conn().QueryRowContext(ctx, query, key))
+	if err == sql.ErrNoRows {
+		// the key may be a card number, it stays out of the error
+		return Card{}, ErrCardNotFound
+	}
+	if err != nil {
+		return Card{}, queryError(query, err)
+	}
+	return card, nil
+}
+
+// ListClientCards returns every card of the client, reissued ones too.
+func (receiver *Store) ListClientCards(ctx context.Context, clientId int64) ([]Card, error) {
+	rows, err := receiver.conn().QueryContext(ctx, getClientCardsSQL, clientId)
+	if err != nil {
+		return nil, queryError(getClientCardsSQL, err)
+	}
+	defer rows.Close()
+
+	cards := make([]Card, 0)
+	for rows.Next() {
+		card, err := scanCard(rows)
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, card)
+	}
+	return cards, rows.Err()
+}
+
+func scanCard(row interface{ Scan(...interface{}) error }) (Card, error) {
+	var card Card
+	var status string
+	var expiresAt, createdAt int64
+	err := row.Scan(&card.Id, &card.ClientId, &card.AccountNumber, &card.Pan, &expiresAt,
+		&status, &card.BlockReason, &card.ReplacedBy, &createdAt)
+	if err != nil {
+		return Card{}, err
+	}
+	card.ExpiresAt = time.Unix(expiresAt, 0)
+	card.CreatedAt = time.Unix(createdAt, 0)
+	card.Status = CardStatus(status)
+	if card.Status == CardActive && !now().Before(card.ExpiresAt) {
+		card.Status = CardExpired
+	}
+	return card, nil
+}
+
+func hashPin(pin string) (string, error) {
+	if len(pin) < minPinDigits || len(pin) > maxPinDigits {
+		return "", fmt.Errorf("%w: %d to %d digits", ErrInvalidPin, minPinDigits, maxPinDigits)
+	}
+	for _, digit := range pin {
+		if digit < '0' || digit > '9' {
+			return "", fmt.Errorf("%w: digits only", ErrInvalidPin)
+		}
+	}
+	return hashPassword(pin)
+}
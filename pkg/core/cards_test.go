@@ -0,0 +1,162 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_cards(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := createDBinFile(t)
+	defer cleanup()
+	store := NewStore(db)
+	err := store.Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, login := range []string{"first", "second"} {
+		err = store.AddClient(ctx, defaultAdminId, Client{Login: login})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.AddBankAccountToClient(ctx, defaultAdminId, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := time.Date(2020, 5, 15, 10, 0, 0, 0, time.Local)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return current }
+
+	for _, test := range []struct {
+		accountNumber int64
+		pin           string
+		want          error
+	}{
+		{pin: "123", want: ErrInvalidPin},
+		{pin: "12a4", want: ErrInvalidPin},
+		{pin: "1234567", want: ErrInvalidPin},
+		{accountNumber: 5, pin: "1234", want: ErrAccountNotFound},
+	} {
+		_, err = store.IssueCard(ctx, defaultAdminId, 1, test.accountNumber, test.pin)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%+v: want: %v, got: %v", test, test.want, err)
+		}
+	}
+
+	card, err := store.IssueCard(ctx, defaultAdminId, 1, 0, "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pan := card.Pan
+	if len(pan) != 16 || !strings.HasPrefix(pan, CardBIN) ||
+		strconv.Itoa(luhnCheckDigit(pan[:15])) != pan[15:] {
+
+		t.Errorf("want a Luhn-valid number of the bank, got: %q", pan)
+	}
+	if card.Masked() != "4150 00** **** "+pan[12:] {
+		t.Errorf("want the number masked, got: %q", card.Masked())
+	}
+	if card.Expiry() != "04/24" || card.Status != CardActive || card.ClientId != 1 {
+		t.Errorf("want an active card till 04/24, got: %+v", card)
+	}
+
+	_, err = store.CheckCardPin(ctx, pan, "4321")
+	if !errors.Is(err, ErrInvalidPin) {
+		t.Errorf("want: %v, got: %v", ErrInvalidPin, err)
+	}
+	checked, err := store.CheckCardPin(ctx, pan, "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked.Id != card.Id {
+		t.Errorf("want card %d, got: %+v", card.Id, checked)
+	}
+
+	err = store.BlockCard(ctx, defaultAdminId, card.Id, "lost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.CheckCardPin(ctx, pan, "4321")
+	if !errors.Is(err, ErrInvalidPin) {
+		t.Errorf("want the PIN checked before the status: %v, got: %v", ErrInvalidPin, err)
+	}
+	_, err = store.CheckCardPin(ctx, pan, "1234")
+	if !errors.Is(err, ErrCardNotActive) {
+		t.Errorf("want: %v, got: %v", ErrCardNotActive, err)
+	}
+	unknown := CardBIN + "0000000000"
+	_, err = store.CheckCardPin(ctx, unknown, "1234")
+	if !errors.Is(err, ErrInvalidPin) {
+		t.Errorf("want an unknown number to fail like a wrong PIN: %v, got: %v", ErrInvalidPin, err)
+	}
+	var failures int
+	err = db.QueryRow(`SELECT failures FROM login_attempts WHERE scope = ? AND key = ?`,
+		cardScope, unknown).Scan(&failures)
+	if err != nil || failures != 1 {
+		t.Errorf("want a failure of the unknown number, got: %d, %v", failures, err)
+	}
+	err = store.BlockCard(ctx, defaultAdminId, card.Id, "again")
+	if !errors.Is(err, ErrCardNotActive) {
+		t.Errorf("want: %v, got: %v", ErrCardNotActive, err)
+	}
+	err = store.BlockCard(ctx, defaultAdminId, 99, "")
+	if !errors.Is(err, ErrCardNotFound) {
+		t.Errorf("want: %v, got: %v", ErrCardNotFound, err)
+	}
+	err = store.UnblockCard(ctx, defaultAdminId, card.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.UnblockCard(ctx, defaultAdminId, card.Id)
+	if !errors.Is(err, ErrCardNotBlocked) {
+		t.Errorf("want: %v, got: %v", ErrCardNotBlocked, err)
+	}
+
+	current = current.AddDate(1, 0, 0)
+	reissued, err := store.ReissueCard(ctx, defaultAdminId, card.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reissued.Pan == pan || reissued.Expiry() != "04/25" || reissued.AccountNumber != 0 {
+		t.Errorf("want a new card of the account till 04/25, got: %+v", reissued)
+	}
+	_, err = store.CheckCardPin(ctx, reissued.Pan, "1234")
+	if err != nil {
+		t.Errorf("want the PIN of the old card, got: %v", err)
+	}
+	_, err = store.ReissueCard(ctx, defaultAdminId, card.Id)
+	if !errors.Is(err, ErrCardNotActive) {
+		t.Errorf("want: %v, got: %v", ErrCardNotActive, err)
+	}
+	err = store.UnblockCard(ctx, defaultAdminId, card.Id)
+	if !errors.Is(err, ErrCardNotBlocked) {
+		t.Errorf("want: %v, got: %v", ErrCardNotBlocked, err)
+	}
+
+	current = time.Date(2025, 6, 1, 0, 0, 0, 0, time.Local)
+	cards, err := store.ListClientCards(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 2 || cards[0].Status != CardBlocked || cards[0].BlockReason != "reissued" ||
+		cards[0].ReplacedBy != reissued.Id || cards[1].Status != CardExpired {
+
+		t.Errorf("want the old card blocked and the new one expired, got: %+v", cards)
+	}
+	_, err = store.CheckCardPin(ctx, reissued.Pan, "1234")
+	if !errors.Is(err, ErrCardNotActive) {
+		t.Errorf("want: %v, got: %v", ErrCardNotActive, err)
+	}
+	cards, err = store.ListClientCards(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 0 {
+		t.Errorf("want no cards of the second client, got: %+v", cards)
+	}
+}
@@ -0,0 +1,280 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+)
+
+// Cassette holds the banknotes of one denomination, in minor units, of an
+// ATM. It's low on cash when Count is at or below LowThreshold.
+type Cassette struct {
+	AtmId        int64
+	Denomination int64
+	Count        int64
+	LowThreshold int64
+}
+
+type Banknotes struct {
+	Denomination int64
+	Count        int64
+}
+
+const (
+	cassetteLoad     = "load"
+	cassetteUnload   = "unload"
+	cassetteDispense = "dispense"
+)
+
+// LoadCassette adds banknotes to the cassette of the denomination, making
+// the cassette when the ATM has none.
+func (receiver *Store) LoadCassette(ctx context.Context,
+	managerId, atmId, denomination, count int64) error {
+
+	if denomination < 1 || count < 1 {
+		return fmt.Errorf("%w: %d banknotes of %d", ErrInvalidAmount, count, denomination)
+	}
+	err := receiver.Authorize(ctx, managerId, PermissionAtmsWrite)
+	if err != nil {
+		return err
+	}
+	return receiver.InTx(ctx, func(tx *Store) error {
+		_, err := tx.conn().ExecContext(ctx, loadAtmCassetteSQL,
+			sql.Named("atm_id", atmId),
+			sql.Named("denomination", denomination),
+			sql.Named("count", count),
+		)
+		if tx.dialect.isForeignKeyViolation(err) {
+			return fmt.Errorf("%w: %d", ErrAtmNotFound, atmId)
+		}
+		if err != nil {
+			return queryError(loadAtmCassetteSQL, err)
+		}
+		return tx.logCassette(ctx, atmId, denomination, count, cassetteLoad, managerId, 0)
+	})
+}
+func (receiver *Store) UnloadCassette(ctx context.Context,
+	managerId, atmId, denomination, count int64) error {
+
+	if denomination < 1 || count < 1 {
+		return fmt.Errorf("%w: %d banknotes of %d", ErrInvalidAmount, count, denomination)
+	}
+	err := receiver.Authorize(ctx, managerId, PermissionAtmsWrite)
+	if err != nil {
+		return err
+	}
+	return receiver.InTx(ctx, func(tx *Store) error {
+		result, err := tx.conn().ExecContext(ctx, unloadAtmCassetteSQL,
+			sql.Named("atm_id", atmId),
+			sql.Named("denomination", denomination),
+			sql.Named("count", count),
+		)
+		if err != nil {
+			return queryError(unloadAtmCassetteSQL, err)
+		}
+		unloaded, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if unloaded == 0 {
+			return fmt.Errorf("%w: ATM %d has less than %d banknotes of %d",
+				ErrNotEnoughCash, atmId, count, denomination)
+		}
+		return tx.logCassette(ctx, atmId, denomination, -count, cassetteUnload, managerId, 0)
+	})
+}
+
+func (receiver *Store) SetLowCashThreshold(ctx context.Context,
+	managerId, atmId, denomination, threshold int64) error {
+
+	if threshold < 0 {
+		return fmt.Errorf("%w: threshold %d", ErrInvalidAmount, threshold)
+	}
+	err := receiver.Authorize(ctx, managerId, PermissionAtmsWrite)
+	if err != nil {
+		return err
+	}
+	result, err := receiver.conn().ExecContext(ctx, setAtmCassetteThresholdSQL,
+		sql.Named("low_threshold", threshold),
+		sql.Named("atm_id", atmId),
+		sql.Named("denomination", denomination),
+	)
+	if err != nil {
+		return queryError(setAtmCassetteThresholdSQL, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return fmt.Errorf("%w: ATM %d has no cassette of %d", ErrAtmNotFound, atmId, denomination)
+	}
+	return nil
+}
+
+// logCassette takes zero as no manager and no ATM operation.
+func (receiver *Store) logCassette(ctx context.Context, atmId, denomination, change int64,
+	reason string, managerId, atmOperationId int64) error {
+
+	_, err := receiver.conn().ExecContext(ctx, insertAtmCassetteLogSQL,
+		sql.Named("atm_id", atmId),
+		sql.Named("denomination", denomination),
+		sql.Named("change", change),
+		sql.Named("reason", reason),
+		sql.Named("manager_id", nullId(managerId)),
+		sql.Named("atm_operation_id", nullId(atmOperationId)),
+		sql.Named("created_at", now().Unix()),
+	)
+	if err != nil {
+		return queryError(insertAtmCassetteLogSQL, err)
+	}
+	return nil
+}
+func nullId(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+func (receiver *Store) AtmCassettes(ctx context.Context, atmId int64) ([]Cassette, error) {
+	return receiver.queryCassettes(ctx, getAtmCassettesSQL, atmId)
+}
+
+// LowCashAlerts returns the cassettes of every ATM that are low on cash.
+func (receiver *Store) LowCashAlerts(ctx context.Context) ([]Cassette, error) {
+	return receiver.queryCassettes(ctx, getLowCashCassettesSQL)
+}
+
+func (receiver *Store) queryCassettes(ctx context.Context,
+	query string, args ...interface{}) ([]Cassette, error) {
+
+	rows, err := receiver.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, queryError(query, err)
+	}
+	defer rows.Close()
+
+	cassettes := make([]Cassette, 0)
+	for rows.Next() {
+		var cassette Cassette
+		err = rows.Scan(&cassette.AtmId, &cassette.Denomination,
+			&cassette.Count, &cassette.LowThreshold)
+		if err != nil {
+			return nil, err
+		}
+		cassettes = append(cassettes, cassette)
+	}
+	return cassettes, rows.Err()
+}
+
+// dispenseCash takes the banknotes of a withdrawal out of the cassettes.
+func (receiver *Store) dispenseCash(ctx context.Context,
+	atmId, atmOperationId, amount int64) ([]Banknotes, error) {
+
+	cassettes, err := receiver.AtmCassettes(ctx, atmId)
+	if err != nil {
+		return nil, err
+	}
+	banknotes, err := dispense(cassettes, amount)
+	if err != nil {
+		return nil, fmt.Errorf("ATM %d: %w", atmId, err)
+	}
+	for _, notes := range banknotes {
+		_, err = receiver.conn().ExecContext(ctx, unloadAtmCassetteSQL,
+			sql.Named("atm_id", atmId),
+			sql.Named("denomination", notes.Denomination),
+			sql.Named("count", notes.Count),
+		)
+		if err != nil {
+			return nil, queryError(unloadAtmCassetteSQL, err)
+		}
+		err = receiver.logCassette(ctx, atmId, notes.Denomination, -notes.Count,
+			cassetteDispense, 0, atmOperationId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return banknotes, nil
+}
+
+// dispense makes up amount from the fewest banknotes the cassettes hold.
+// Greedy picking fails on denominations like 50 and 20 for 60, so it's a
+// bounded knapsack in steps of the common divisor of the denominations.
+func dispense(cassettes []Cassette, amount int64) ([]Banknotes, error) {
+	var total, step int64
+	for _, cassette := range cassettes {
+		if cassette.Count > 0 {
+			total += cassette.Denomination * cassette.Count
+			step = gcd(step, cassette.Denomination)
+		}
+	}
+	if total < amount {
+		return nil, fmt.Errorf("%w: %d of %d", ErrNotEnoughCash, total, amount)
+	}
+	if step == 0 || amount%step != 0 {
+		return nil, fmt.Errorf("%w: %d", ErrCannotDispense, amount)
+	}
+
+	// every cassette splits into bundles of 1, 2, 4... notes, so that any
+	// count up to the one in the cassette is a choice of bundles
+	type bundle struct {
+		cassette int
+		notes    int64
+		steps    int64
+	}
+	var bundles []bundle
+	for i, cassette := range cassettes {
+		for left, notes := cassette.Count, int64(1); left > 0; notes *= 2 {
+			if notes > left {
+				notes = left
+			}
+			bundles = append(bundles, bundle{i, notes, notes * cassette.Denomination / step})
+			left -= notes
+		}
+	}
+
+	size := amount / step
+	fewest := make([]int64, size+1)
+	for i := range fewest {
+		fewest[i] = math.MaxInt64
+	}
+	fewest[0] = 0
+	taken := make([][]bool, len(bundles))
+	for i, bundle := range bundles {
+		taken[i] = make([]bool, size+1)
+		for value := size; value >= bundle.steps; value-- {
+			rest := fewest[value-bundle.steps]
+			if rest != math.MaxInt64 && rest+bundle.notes < fewest[value] {
+				fewest[value] = rest + bundle.notes
+				taken[i][value] = true
+			}
+		}
+	}
+	if fewest[size] == math.MaxInt64 {
+		return nil, fmt.Errorf("%w: %d", ErrCannotDispense, amount)
+	}
+
+	counts := make([]int64, len(cassettes))
+	for i, value := len(bundles)-1, size; i >= 0; i-- {
+		if taken[i][value] {
+			counts[bundles[i].cassette] += bundles[i].notes
+			value -= bundles[i].steps
+		}
+	}
+	var banknotes []Banknotes
+	for i, count := range counts {
+		if count > 0 {
+			banknotes = append(banknotes, Banknotes{
+				Denomination: cassettes[i].Denomination,
+				Count:        count,
+			})
+		}
+	}
+	return banknotes, nil
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_dispense(t *testing.T) {
+	for _, test := range []struct {
+		cassettes []Cassette
+		amount    int64
+		want      []Banknotes
+		err       error
+	}{
+		{
+			cassettes: []Cassette{{Denomination: 50, Count: 5}, {Denomination: 20, Count: 5}},
+			amount:    60,
+			want:      []Banknotes{{20, 3}},
+		},
+		{
+			cassettes: []Cassette{{Denomination: 50, Count: 5}, {Denomination: 20, Count: 5}},
+			amount:    110,
+			want:      []Banknotes{{50, 1}, {20, 3}},
+		},
+		{
+			cassettes: []Cassette{{Denomination: 100, Count: 2}, {Denomination: 50, Count: 1},
+				{Denomination: 10, Count: 10}},
+			amount: 270,
+			want:   []Banknotes{{100, 2}, {50, 1}, {10, 2}},
+		},
+		{
+			cassettes: []Cassette{{Denomination: 50, Count: 5}, {Denomination: 20, Count: 5}},
+			amount:    30,
+			err:       ErrCannotDispense,
+		},
+		{
+			cassettes: []Cassette{{Denomination: 50, Count: 1}, {Denomination: 20, Count: 1}},
+			amount:    60,
+			err:       ErrCannotDispense,
+		},
+		{
+			cassettes: []Cassette{{Denomination: 50, Count: 1}, {Denomination: 20, Count: 0}},
+			amount:    70,
+			err:       ErrNotEnoughCash,
+		},
+		{
+			amount: 10,
+			err:    ErrNotEnoughCash,
+		},
+	} {
+		got, err := dispense(test.cassettes, test.amount)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%d of %+v: want: %v, got: %v", test.amount, test.cassettes, test.err, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d of %+v: want: %+v, got: %+v", test.amount, test.cassettes, test.want, got)
+		}
+	}
+}
+
+func Test_cassettes(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := createDBinFile(t)
+	defer cleanup()
+	store := NewStore(db)
+	err := store.Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddClient(ctx, defaultAdminId, Client{Login: "client"})
+	if err == nil {
+		err = store.AddBankAccountToClient(ctx, defaultAdminId, 1)
+	}
+	if err == nil {
+		err = store.AddATM(ctx, defaultAdminId, "first street")
+	}
+	if err == nil {
+		_, err = store.ReplenishBankAccount(ctx, defaultAdminId, 1, 0, 10000)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.LoadCassette(ctx, defaultAdminId, 9, 500, 10)
+	if !errors.Is(err, ErrAtmNotFound) {
+		t.Errorf("want: %v, got: %v", ErrAtmNotFound, err)
+	}
+	for _, load := range []Banknotes{{500, 4}, {200, 10}, {500, 2}} {
+		err = store.LoadCassette(ctx, defaultAdminId, 1, load.Denomination, load.Count)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.UnloadCassette(ctx, defaultAdminId, 1, 200, 11)
+	if !errors.Is(err, ErrNotEnoughCash) {
+		t.Errorf("want: %v, got: %v", ErrNotEnoughCash, err)
+	}
+	err = store.UnloadCassette(ctx, defaultAdminId, 1, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.SetLowCashThreshold(ctx, defaultAdminId, 1, 500, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.WithdrawAtATM(ctx, 1, 1, 0, 300)
+	if !errors.Is(err, ErrCannotDispense) {
+		t.Errorf("want: %v, got: %v", ErrCannotDispense, err)
+	}
+	assertBalance(t, 1, 0, 10000, db)
+	operation, err := store.WithdrawAtATM(ctx, 1, 1, 0, 2600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Banknotes{{500, 4}, {200, 3}}
+	if !reflect.DeepEqual(operation.Banknotes, want) {
+		t.Errorf("want: %+v, got: %+v", want, operation.Banknotes)
+	}
+
+	cassettes, err := store.AtmCassettes(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCassettes := []Cassette{
+		{AtmId: 1, Denomination: 500, Count: 2, LowThreshold: 3},
+		{AtmId: 1, Denomination: 200, Count: 5},
+	}
+	if !reflect.DeepEqual(cassettes, wantCassettes) {
+		t.Errorf("want: %+v, got: %+v", wantCassettes, cassettes)
+	}
+	alerts, err := store.LowCashAlerts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 1 || alerts[0] != wantCassettes[0] {
+		t.Errorf("want the cassette of 500 low on cash, got: %+v", alerts)
+	}
+}
@@ -1,347 +1,724 @@
 package core
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"encoding/xml"
-	"errors"
 	"fmt"
 	"io/ioutil"
-	"strconv"
+	"strings"
 )
 
-func Init(db *sql.DB) (err error) {
-	ddls := []string{
-		managersDDL,
-		clientsDDL,
-		bankAccountsDDL,
-		bankAccountsServicesDDL,
-		servicesDDL,
-		atmsDDL,
-	}
-	err = execQueries(ddls, db)
+func (receiver *Store) Init(ctx context.Context) (err error) {
+	err = receiver.Migrate(ctx, LatestVersion)
 	if err != nil {
 		return err
 	}
 
-	initialData := []string{managersInitData}
-	err = execQueries(initialData, db)
+	adminPassword, err := hashPassword(defaultAdminPassword)
+	if err != nil {
+		return err
+	}
+	_, err = receiver.conn().ExecContext(ctx, managersInitData,
+		sql.Named("password", adminPassword))
+	if err != nil {
+		return queryError(managersInitData, err)
+	}
+	err = receiver.dialect.syncIdSequence(ctx, receiver.conn(), "managers")
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	err = receiver.assignMissingAccountIDs(ctx)
+	if err != nil {
+		return err
+	}
 
-func execQueries(queries []string, db *sql.DB) (err error) {
-	for _, query := range queries {
-		_, err = db.Exec(query)
+	err = receiver.initRoles(ctx)
+	if err != nil {
+		return err
+	}
+	// the default admin gets the role only when nobody has it, so a revoked
+	// role stays revoked while there is another admin
+	var admins int
+	err = receiver.conn().QueryRowContext(ctx, countManagersWithRoleSQL, string(RoleAdmin)).Scan(&admins)
+	if err != nil {
+		return queryError(countManagersWithRoleSQL, err)
+	}
+	if admins == 0 {
+		err = receiver.grantRole(ctx, defaultAdminId, RoleAdmin)
 		if err != nil {
-			return fmt.Errorf("can't execute db query '%v': %w", query, err)
+			return err
 		}
 	}
+
 	return nil
 }
 
+const (
+	defaultAdminId       = 1
+	defaultAdminPassword = "top-secret"
+)
+
 //---------------Manager
-func checkManagerLoginOnUnique(login string, db *sql.DB) (bool, error) {
-	return checkLoginOnUnique(login, getManagerLoginByLogin, db)
-}
-func checkClientLoginOnUnique(login string, db *sql.DB) (bool, error) {
-	return checkLoginOnUnique(login, getClientLoginByLogin, db)
+// normalizeLogin is applied to logins before they are stored or looked up,
+// so "Alice" and " alice" are the same login.
+func normalizeLogin(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
 }
-func checkLoginOnUnique(login, getLoginByLogin string, db *sql.DB) (bool, error) {
-	err := db.QueryRow(getLoginByLogin, login).Scan()
-	if err == sql.ErrNoRows {
-		return true, nil
-	}
+
+// IsLoginAvailable reports whether neither a client nor a manager uses the
+// login yet.
+func (receiver *Store) IsLoginAvailable(ctx context.Context, login string) (bool, error) {
+	taken, err := receiver.isLoginTaken(ctx, normalizeLogin(login))
 	if err != nil {
 		return false, err
 	}
-	return true, nil
+	return !taken, nil
+}
+func (receiver *Store) isLoginTaken(ctx context.Context, login string) (taken bool, err error) {
+	err = receiver.conn().QueryRowContext(ctx, isLoginTakenSQL,
+		sql.Named("login", login),
+	).Scan(&taken)
+	if err != nil {
+		return false, queryError(isLoginTakenSQL, err)
+	}
+	return taken, nil
 }
 
-func ReplenishBankAccount(clientId, accountNumber, amount int64,
-	db *sql.DB) error {
+// insertWithUniqueLogin runs insert in a transaction after checking that
+// login is not used by clients or managers.
+func (receiver *Store) insertWithUniqueLogin(ctx context.Context, login string,
+	insert func(tx *Store) error) error {
 
-	var balance int64
-	err := db.QueryRow(getBalanceByClientIdAndAccountNumberSQL,
-		sql.Named("id", clientId),
-		sql.Named("account_number", accountNumber),
-	).Scan(&balance)
-	if err != nil {
+	return receiver.InTx(ctx, func(tx *Store) error {
+		taken, err := tx.isLoginTaken(ctx, login)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return fmt.Errorf("%w: %s", ErrLoginTaken, login)
+		}
+		err = insert(tx)
+		if tx.dialect.isUniqueViolation(err) {
+			return fmt.Errorf("%w: %s", ErrLoginTaken, login)
+		}
 		return err
-	}
-	increasedBalance := balance + amount
-	_, err = db.Exec(updateBalanceByClientIdAndAccountNumberSQL,
-		sql.Named("balance", increasedBalance),
-		sql.Named("id", clientId),
-		sql.Named("account_number", accountNumber),
-	)
-	return err
+	})
 }
 
-func AddClient(client Client, db *sql.DB) (err error) {
-	//TODO: check login on unique
-	_, err = db.Exec(
-		insertClientWithoutIdSQL,
-		sql.Named("login", client.Login),
-		sql.Named("password", client.Password),
-		sql.Named("name", client.Name),
-		sql.Named("phone", client.Phone),
-	)
-	return err
+// ReplenishBankAccount increases the balance in place, so concurrent
+// replenishments of one account never overwrite each other.
+func (receiver *Store) ReplenishBankAccount(ctx context.Context,
+	managerId, clientId, accountNumber, amount int64) (replenishment Replenishment, err error) {
+
+	if amount < 1 {
+		return Replenishment{}, ErrInvalidAmount
+	}
+	err = receiver.Authorize(ctx, managerId, PermissionAccountsReplenish)
+	if err != nil {
+		return Replenishment{}, err
+	}
+
+	err = receiver.InTx(ctx, func(tx *Store) error {
+		result, err := tx.conn().ExecContext(ctx, increaseBalanceByClientIdAndAccountNumberSQL,
+			sql.Named("amount", amount),
+			sql.Named("id", clientId),
+			sql.Named("account_number", accountNumber),
+		)
+		if err != nil {
+			return queryError(increaseBalanceByClientIdAndAccountNumberSQL, err)
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return accountError(LedgerClient, clientId, accountNumber, ErrAccountNotFound)
+		}
+
+		var accountId, balance int64
+		err = tx.conn().QueryRowContext(ctx, clientAccounts.getIdAndBalance,
+			sql.Named("id", clientId),
+			sql.Named("account_number", accountNumber),
+		).Scan(&accountId, &balance)
+		if err != nil {
+			return queryError(clientAccounts.getIdAndBalance, err)
+		}
+
+		transactionId, err := tx.writeJournal(ctx, KindReplenishment, []Posting{
+			{Account: LedgerClient, AccountId: accountId, Amount: amount},
+			{Account: LedgerCash, Amount: -amount},
+		})
+		if err != nil {
+			return err
+		}
+
+		replenishment = Replenishment{
+			TransactionId: transactionId,
+			ClientId:      clientId,
+			AccountNumber: accountNumber,
+			Amount:        amount,
+			Balance:       balance,
+			CreatedAt:     now(),
+		}
+		return nil
+	})
+	if err != nil {
+		return Replenishment{}, err
+	}
+	return replenishment, nil
 }
-func AddService(service Service, db *sql.DB) (serviceNumber string, err error) {
-	result, err := db.Exec(insertServiceWithoutIdSQL, sql.Named("name", service.Name))
+
+func (receiver *Store) AddClient(ctx context.Context, managerId int64, client Client) (err error) {
+	err = receiver.Authorize(ctx, managerId, PermissionClientsWrite)
 	if err != nil {
-		return "", err
+		return err
 	}
-	id, err := result.LastInsertId()
+	password, err := hashPassword(client.Password)
 	if err != nil {
-		return "", err
+		return err
 	}
-	err = AddBankAccountToService(id, db)
+	login := normalizeLogin(client.Login)
+	return receiver.insertWithUniqueLogin(ctx, login, func(tx *Store) error {
+		_, err := tx.conn().ExecContext(ctx,
+			insertClientWithoutIdSQL,
+			sql.Named("login", login),
+			sql.Named("password", password),
+			sql.Named("name", client.Name),
+			sql.Named("phone", client.Phone),
+		)
+		if err != nil {
+			return queryError(insertClientWithoutIdSQL, err)
+		}
+		return nil
+	})
+}
+func (receiver *Store) AddService(ctx context.Context,
+	managerId int64, service Service) (serviceNumber string, err error) {
+
+	err = receiver.Authorize(ctx, managerId, PermissionServicesWrite)
 	if err != nil {
 		return "", err
 	}
-	var serviceId, accountNumber int64
-	err = db.QueryRow(getServiceIdAndAccountNumberById, id,
-	).Scan(&serviceId, &accountNumber)
+	err = receiver.InTx(ctx, func(tx *Store) error {
+		id, err := tx.dialect.insertReturningId(ctx, tx.conn(),
+			insertServiceWithoutIdSQL, sql.Named("name", service.Name))
+		if err != nil {
+			return err
+		}
+		err = tx.addBankAccount(ctx, id, DefaultCurrency, serviceAccounts)
+		if err != nil {
+			return err
+		}
+		var serviceId, accountNumber int64
+		err = tx.conn().QueryRowContext(ctx, getServiceIdAndAccountNumberById, id,
+		).Scan(&serviceId, &accountNumber)
+		if err != nil {
+			return queryError(getServiceIdAndAccountNumberById, err)
+		}
+		number, err := NewServiceNumber(serviceId, accountNumber)
+		if err != nil {
+			return err
+		}
+		serviceNumber = number.String()
+		return nil
+	})
 	if err != nil {
 		return "", err
 	}
-
-	const zerosForId = 1000_000_000
-	const zerosForAccount = 1_0000
-	serviceId += zerosForId
-	accountNumber += zerosForAccount
-	serviceIdStr := strconv.Itoa(int(serviceId))
-	accountNumberStr := strconv.Itoa(int(accountNumber))
-
-	serviceNumber = serviceIdStr[1:] + accountNumberStr[1:]
 	return serviceNumber, nil
 }
 
-func AddManager(manager Manager, db *sql.DB) (err error) {
-	_, err = db.Exec(
-		insertManagerWithoutIdSQL,
-		sql.Named("login", manager.Login),
-		sql.Named("password", manager.Password),
-	)
-	return err
+func (receiver *Store) AddManager(ctx context.Context, managerId int64, manager Manager) (err error) {
+	err = receiver.Authorize(ctx, managerId, PermissionManagersWrite)
+	if err != nil {
+		return err
+	}
+	password, err := hashPassword(manager.Password)
+	if err != nil {
+		return err
+	}
+	login := normalizeLogin(manager.Login)
+	return receiver.insertWithUniqueLogin(ctx, login, func(tx *Store) error {
+		_, err := tx.conn().ExecContext(ctx,
+			insertManagerWithoutIdSQL,
+			sql.Named("login", login),
+			sql.Named("password", password),
+		)
+		if err != nil {
+			return queryError(insertManagerWithoutIdSQL, err)
+		}
+		return nil
+	})
 }
-func addBankAccount(id int64, getBankAccountsCountByUserIdSQL,
-	insertBankAccountToSQL string, db *sql.DB) (err error) {
+func (receiver *Store) addBankAccount(ctx context.Context, id int64,
+	currency string, accounts accountQueries) error {
 
-	var bankAccountsCount int
-	err = db.QueryRow(
-		getBankAccountsCountByUserIdSQL,
-		id,
-	).Scan(&bankAccountsCount)
+	known, err := LookupCurrency(currency)
 	if err != nil {
 		return err
 	}
+	return receiver.InTx(ctx, func(tx *Store) error {
+		accountNumber, err := tx.nextAccountNumber(ctx, accounts.ledgerAccount, id)
+		if err != nil {
+			return err
+		}
 
-	const startBalance = 0
-	_, err = db.Exec(
-		insertBankAccountToSQL,
-		sql.Named("id", id),
-		sql.Named("account_number", bankAccountsCount),
-		sql.Named("balance", startBalance),
+		var ownerId int64
+		err = tx.conn().QueryRowContext(ctx, accounts.getOwnerById, id).Scan(&ownerId)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %d", accounts.ownerNotFound, id)
+		}
+		if err != nil {
+			return queryError(accounts.getOwnerById, err)
+		}
+
+		const startBalance = 0
+		accountId, err := tx.dialect.insertReturningId(ctx, tx.conn(),
+			accounts.insertToOwner,
+			sql.Named("id", id),
+			sql.Named("account_number", accountNumber),
+			sql.Named("balance", startBalance),
+		)
+		if err != nil {
+			return err
+		}
+		err = tx.setAccountCurrency(ctx, accounts.ledgerAccount, accountId, known.Code)
+		if err != nil {
+			return err
+		}
+		return tx.assignAccountID(ctx, accounts.ledgerAccount, accountId)
+	})
+}
+
+// nextAccountNumber allocates account numbers of an owner from zero. A
+// number is never given twice, even after its account is gone; the sequence
+// row stays locked until the transaction ends.
+func (receiver *Store) nextAccountNumber(ctx context.Context,
+	account LedgerAccount, ownerId int64) (int64, error) {
+
+	_, err := receiver.conn().ExecContext(ctx, incrementAccountSequenceSQL,
+		sql.Named("account_type", string(account)),
+		sql.Named("owner_id", ownerId),
 	)
 	if err != nil {
-		return err
+		return 0, queryError(incrementAccountSequenceSQL, err)
 	}
+	var next int64
+	err = receiver.conn().QueryRowContext(ctx, getAccountSequenceSQL,
+		sql.Named("account_type", string(account)),
+		sql.Named("owner_id", ownerId),
+	).Scan(&next)
+	if err != nil {
+		return 0, queryError(getAccountSequenceSQL, err)
+	}
+	return next - 1, nil
+}
+
+// reserveAccountNumber keeps the sequence past a number given from outside.
+func (receiver *Store) reserveAccountNumber(ctx context.Context,
+	account LedgerAccount, ownerId, accountNumber int64) error {
 
-	return
+	_, err := receiver.conn().ExecContext(ctx, reserveAccountSequenceSQL,
+		sql.Named("account_type", string(account)),
+		sql.Named("owner_id", ownerId),
+		sql.Named("next_number", accountNumber+1),
+	)
+	if err != nil {
+		return queryError(reserveAccountSequenceSQL, err)
+	}
+	return nil
 }
-func AddBankAccountToClient(id int64, db *sql.DB) error {
-	return addBankAccount(id, getBankAccountsCountByClientIdSQL, insertBankAccountToClientSQL, db)
+func (receiver *Store) AddBankAccountToClient(ctx context.Context, managerId, id int64) error {
+	return receiver.AddBankAccountToClientInCurrency(ctx, managerId, id, DefaultCurrency)
 }
-func AddBankAccountToService(id int64, db *sql.DB) error {
-	return addBankAccount(id, getBankAccountsServicesCountByServiceIdSQL, insertBankAccountToServiceSQL, db)
+func (receiver *Store) AddBankAccountToService(ctx context.Context, managerId, id int64) error {
+	return receiver.AddBankAccountToServiceInCurrency(ctx, managerId, id, DefaultCurrency)
 }
-func AddATM(address string, db *sql.DB) error {
-	_, err := db.Exec(insertAtmWithoutIdSQL, address)
+func (receiver *Store) AddBankAccountToClientInCurrency(ctx context.Context,
+	managerId, id int64, currency string) error {
+
+	err := receiver.Authorize(ctx, managerId, PermissionAccountsWrite)
+	if err != nil {
+		return err
+	}
+	return receiver.addBankAccount(ctx, id, currency, clientAccounts)
+}
+func (receiver *Store) AddBankAccountToServiceInCurrency(ctx context.Context,
+	managerId, id int64, currency string) error {
+
+	err := receiver.Authorize(ctx, managerId, PermissionAccountsWrite)
+	if err != nil {
+		return err
+	}
+	return receiver.addBankAccount(ctx, id, currency, serviceAccounts)
+}
+// AddATM is CreateATM of an ATM with the default details.
+func (receiver *Store) AddATM(ctx context.Context, managerId int64, address string) error {
+	_, err := receiver.CreateATM(ctx, managerId, Atm{Address: address})
 	return err
 }
-func GetClientIdByLogin(login string, db *sql.DB) (id int64, err error) {
-	err = db.QueryRow(
+func (receiver *Store) GetClientIdByLogin(ctx context.Context, login string) (id int64, err error) {
+	login = normalizeLogin(login)
+	err = receiver.conn().QueryRowContext(ctx,
 		getClientIdByLoginSQL,
 		login,
 	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: login %s", ErrClientNotFound, login)
+	}
 	if err != nil {
-		return 0, err
+		return 0, queryError(getClientIdByLoginSQL, err)
 	}
 	return id, nil
-	}
+}
 
-func TransferToClient(transfer MoneyTransfer, db *sql.DB) error {
-	return transferByReceiverAccountId(
-		transfer,
-		getBalanceByClientIdAndAccountNumberSQL,
-		updateBalanceByClientIdAndAccountNumberSQL,
-		db)
+func (receiver *Store) TransferToClient(ctx context.Context, transfer MoneyTransfer) error {
+	return receiver.transferByReceiverAccountId(ctx, transfer, KindTransfer, clientAccounts)
 }
 
-func PayForService(serviceNumber string,
-	amount, payerId, payerAccountNumber int64,
-	db *sql.DB) error {
+func (receiver *Store) PayForService(ctx context.Context, serviceNumber string,
+	amount, payerId, payerAccountNumber int64) error {
 
-	serviceId, accountNumber, err := ServiceNumberToIdAndAccountNumber(serviceNumber)
+	return receiver.MakeServicePayment(ctx, ServicePayment{
+		ServiceNumber:      serviceNumber,
+		Amount:             amount,
+		PayerId:            payerId,
+		PayerAccountNumber: payerAccountNumber,
+	})
+}
+func (receiver *Store) MakeServicePayment(ctx context.Context, payment ServicePayment) error {
+	serviceId, accountNumber, err := ServiceNumberToIdAndAccountNumber(payment.ServiceNumber)
 	if err != nil {
 		return err
 	}
 
 	transfer := MoneyTransfer{
-		Amount:                amount,
-		SenderId:              payerId,
-		SenderAccountNumber:   payerAccountNumber,
+		Amount:                payment.Amount,
+		SenderId:              payment.PayerId,
+		SenderAccountNumber:   payment.PayerAccountNumber,
 		ReceiverId:            serviceId,
 		ReceiverAccountNumber: accountNumber,
+		IdempotencyKey:        payment.IdempotencyKey,
 	}
-	return transferByReceiverAccountId(
-		transfer,
-		getBalanceByServiceIdAndAccountNumberSQL,
-		updateBalanceByServiceIdAndAccountNumberSQL,
-		db)
+	return receiver.transferByReceiverAccountId(ctx, transfer, KindServicePayment, serviceAccounts)
 }
 
-const digitLimitForAccount = 4
+type accountQueries struct {
+	ledgerAccount        LedgerAccount
+	ownerNotFound        error
+	accountNotFound      error
+	getOwnerById         string
+	insertToOwner        string
+	getIdAndBalance      string
+	increaseBalance      string
+	getBalanceMismatches string
+	getOrphans           string
+	getDuplicateNumbers  string
+	getNegativeBalances  string
+	getById              string
+	getIdentifier        string
+	getWithoutIdentifier string
+}
 
-func ServiceNumberToIdAndAccountNumber(serviceNumber string) (int64, int64, error) {
-	serviceIdStr := serviceNumber[:len(serviceNumber)-digitLimitForAccount]
-	accountNumberStr := serviceNumber[len(serviceNumber)-digitLimitForAccount:]
+var (
+	clientAccounts = accountQueries{
+		ledgerAccount:        LedgerClient,
+		ownerNotFound:        ErrClientNotFound,
+		accountNotFound:      ErrAccountNotFound,
+		getOwnerById:         getClientIdByIdSQL,
+		insertToOwner:        insertBankAccountToClientSQL,
+		getIdAndBalance:      getBankAccountIdAndBalanceByClientIdAndAccountNumberSQL,
+		increaseBalance:      increaseBalanceByClientIdAndAccountNumberSQL,
+		getBalanceMismatches: getClientBalanceMismatchesSQL,
+		getOrphans:           getOrphanBankAccountsSQL,
+		getDuplicateNumbers:  getDuplicateBankAccountNumbersSQL,
+		getNegativeBalances:  getNegativeBankAccountsSQL,
+		getById:              getBankAccountByIdSQL,
+		getIdentifier:        getBankAccountIdentifierSQL,
+		getWithoutIdentifier: getBankAccountsWithoutIdentifierSQL,
+	}
+	serviceAccounts = accountQueries{
+		ledgerAccount:        LedgerService,
+		ownerNotFound:        ErrServiceNotFound,
+		accountNotFound:      ErrServiceNotFound,
+		getOwnerById:         getServiceIdByIdSQL,
+		insertToOwner:        insertBankAccountToServiceSQL,
+		getIdAndBalance:      getBankAccountIdAndBalanceByServiceIdAndAccountNumberSQL,
+		increaseBalance:      increaseBalanceByServiceIdAndAccountNumberSQL,
+		getBalanceMismatches: getServiceBalanceMismatchesSQL,
+		getOrphans:           getOrphanBankAccountsServicesSQL,
+		getDuplicateNumbers:  getDuplicateBankAccountServiceNumbersSQL,
+		getNegativeBalances:  getNegativeBankAccountsServicesSQL,
+		getById:              getBankAccountServiceByIdSQL,
+		getIdentifier:        getBankAccountServiceIdentifierSQL,
+		getWithoutIdentifier: getBankAccountsServicesWithoutIdentifierSQL,
+	}
+)
 
-	serviceId, err := strconv.Atoi(serviceIdStr)
+func ServiceNumberToIdAndAccountNumber(serviceNumber string) (int64, int64, error) {
+	parsed, err := ParseServiceNumber(serviceNumber)
 	if err != nil {
 		return 0, 0, err
 	}
-	accountNumber, err := strconv.Atoi(accountNumberStr)
-	if err != nil {
-		return 0, 0, err
+	return parsed.ServiceId, parsed.AccountNumber, nil
+}
+
+func (receiver *Store) transferByReceiverAccountId(
+	ctx context.Context,
+	tfr MoneyTransfer,
+	kind TransactionKind,
+	accounts accountQueries) error {
+
+	err := receiver.InTx(ctx, func(tx *Store) error {
+		return tx.transfer(ctx, tfr, kind, accounts, nil)
+	})
+	if err == errIdempotencyKeyRace && receiver.tx == nil {
+		// a concurrent call with the same key committed first
+		return receiver.replayIdempotencyKey(ctx, tfr, kind)
 	}
-	return int64(serviceId), int64(accountNumber), nil
+	return err
 }
 
-func transferByReceiverAccountId(
+// transfer must run in a store bound to a transaction. Accounts in different
+// currencies need a conversion, which it fills at the current exchange rate.
+func (receiver *Store) transfer(
+	ctx context.Context,
 	tfr MoneyTransfer,
-	getBalanceByIdAndAccountNumber string,
-	updateBalanceByIdAndAccountNumber string,
-	db *sql.DB) error {
+	kind TransactionKind,
+	accounts accountQueries,
+	conversion *Conversion) (err error) {
 
-	tx, err := db.Begin()
-	if err != nil {
-		return err
+	if tfr.Amount < 1 {
+		return ErrInvalidAmount
 	}
-	defer func() {
+	if tfr.IdempotencyKey != "" {
+		replayed, err := receiver.findIdempotencyKey(ctx, tfr, kind)
 		if err != nil {
-			_ = tx.Rollback()
-			return
+			return err
+		}
+		if replayed && conversion != nil {
+			return receiver.conversionByIdempotencyKey(ctx, tfr.IdempotencyKey, conversion)
+		}
+		if replayed {
+			return nil
 		}
-		err = tx.Commit()
-	}()
-	if tfr.Amount < 1 {
-		return errors.New("zero ore less money to transfer")
 	}
 
-
-	var balance int64
-	err = tx.QueryRow(
-		getBalanceByClientIdAndAccountNumberSQL,
+	result, err := receiver.conn().ExecContext(ctx, withdrawFromBankAccountSQL,
+		sql.Named("amount", tfr.Amount),
 		sql.Named("id", tfr.SenderId),
 		sql.Named("account_number", tfr.SenderAccountNumber),
-	).Scan(&balance)
+	)
 	if err != nil {
-		return err
+		return queryError(withdrawFromBankAccountSQL, err)
 	}
-
-	if tfr.Amount > balance {
-		return errors.New("no enough money to transfer")
+	withdrawn, err := result.RowsAffected()
+	if err != nil {
+		return err
 	}
 
-	moneyRest := balance - tfr.Amount
-	_, err = tx.Exec(updateBalanceByClientIdAndAccountNumberSQL,
-		sql.Named("balance", moneyRest),
+	var senderAccountId, balance int64
+	err = receiver.conn().QueryRowContext(ctx,
+		clientAccounts.getIdAndBalance,
 		sql.Named("id", tfr.SenderId),
 		sql.Named("account_number", tfr.SenderAccountNumber),
-	)
+	).Scan(&senderAccountId, &balance)
+	if err == sql.ErrNoRows {
+		return accountError(LedgerClient, tfr.SenderId, tfr.SenderAccountNumber,
+			ErrAccountNotFound)
+	}
 	if err != nil {
-		return err
+		return queryError(clientAccounts.getIdAndBalance, err)
+	}
+	if withdrawn == 0 {
+		return accountError(LedgerClient, tfr.SenderId, tfr.SenderAccountNumber,
+			ErrInsufficientFunds)
 	}
 
-	var receiverBalance int64
-	err = tx.QueryRow(getBalanceByIdAndAccountNumber,
+	var receiverAccountId, receiverBalance int64
+	err = receiver.conn().QueryRowContext(ctx, accounts.getIdAndBalance,
 		sql.Named("id", tfr.ReceiverId),
 		sql.Named("account_number", tfr.ReceiverAccountNumber),
-	).Scan(&receiverBalance)
+	).Scan(&receiverAccountId, &receiverBalance)
+	if err == sql.ErrNoRows {
+		return accountError(accounts.ledgerAccount, tfr.ReceiverId, tfr.ReceiverAccountNumber,
+			accounts.accountNotFound)
+	}
+	if err != nil {
+		return queryError(accounts.getIdAndBalance, err)
+	}
+
+	senderCurrency, err := receiver.accountCurrency(ctx, LedgerClient, senderAccountId)
+	if err != nil {
+		return err
+	}
+	receiverCurrency, err := receiver.accountCurrency(ctx, accounts.ledgerAccount, receiverAccountId)
 	if err != nil {
 		return err
 	}
+	if conversion == nil && senderCurrency != receiverCurrency {
+		return accountError(accounts.ledgerAccount, tfr.ReceiverId, tfr.ReceiverAccountNumber,
+			fmt.Errorf("%w: %s to %s", ErrCurrencyMismatch, senderCurrency, receiverCurrency))
+	}
+	applied := Conversion{From: senderCurrency, To: receiverCurrency,
+		Amount: tfr.Amount, ConvertedAmount: tfr.Amount}
+	if conversion != nil {
+		applied, err = receiver.quote(ctx, tfr.Amount, senderCurrency, receiverCurrency)
+		if err != nil {
+			return err
+		}
+	}
+	postings := conversionPostings(applied,
+		Posting{Account: LedgerClient, AccountId: senderAccountId},
+		Posting{Account: accounts.ledgerAccount, AccountId: receiverAccountId},
+	)
 
-	increasedBalance := receiverBalance + tfr.Amount
-	_, err = tx.Exec(updateBalanceByIdAndAccountNumber,
-		sql.Named("balance", increasedBalance),
+	_, err = receiver.conn().ExecContext(ctx, accounts.increaseBalance,
+		sql.Named("amount", postings[len(postings)-1].Amount),
 		sql.Named("id", tfr.ReceiverId),
 		sql.Named("account_number", tfr.ReceiverAccountNumber),
 	)
+	if err != nil {
+		return queryError(accounts.increaseBalance, err)
+	}
+
+	transactionId, err := receiver.writeJournal(ctx, kind, postings)
 	if err != nil {
 		return err
 	}
+	if applied.From != applied.To {
+		applied.TransactionId = transactionId
+		err = receiver.saveConversion(ctx, applied)
+		if err != nil {
+			return err
+		}
+	}
+	if conversion != nil {
+		*conversion = applied
+	}
 
+	if tfr.IdempotencyKey != "" {
+		return receiver.saveIdempotencyKey(ctx, tfr, kind, transactionId)
+	}
 	return nil
 }
 
-func GetClientIdByPhoneNumber(phone string, db *sql.DB) (int64, error) {
+func (receiver *Store) GetClientIdByPhoneNumber(ctx context.Context, phone string) (int64, error) {
 	var clientId int64
-	err := db.QueryRow(getClientIdByPhoneSQL, phone).Scan(&clientId)
-	return clientId, err
+	err := receiver.conn().QueryRowContext(ctx, getClientIdByPhoneSQL, phone).Scan(&clientId)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: phone %s", ErrClientNotFound, phone)
+	}
+	if err != nil {
+		return 0, queryError(getClientIdByPhoneSQL, err)
+	}
+	return clientId, nil
 }
 
-func LoginForManager(login, password string, db *sql.DB) (bool, error) {
-	return checkPassword(login, password, getManagerPasswordByLoginSQL, db)
+// LoginForManager and LoginForClient also count failed attempts per source
+// (for example a remote address) when it's not empty.
+func (receiver *Store) LoginForManager(ctx context.Context,
+	login, password, source string) (bool, error) {
+
+	return receiver.checkPassword(ctx, login, password, source, managerCredentials)
 }
-func LoginForClient(login, password string, db *sql.DB) (bool, error) {
-	return checkPassword(login, password, getClientPasswordByLoginSQL, db)
+func (receiver *Store) LoginForClient(ctx context.Context,
+	login, password, source string) (bool, error) {
+
+	return receiver.checkPassword(ctx, login, password, source, clientCredentials)
 }
-func checkPassword(login, password, getPasswordByLogin string, db *sql.DB) (bool, error) {
-	var dbPassword string
 
-	err := db.QueryRow(
-		getPasswordByLogin,
+type credentials struct {
+	scope                 string
+	getPasswordByLogin    string
+	updatePasswordByLogin string
+	getIdByLogin          string
+}
+
+var (
+	clientCredentials = credentials{
+		scope:                 string(RoleClient),
+		getPasswordByLogin:    getClientPasswordByLoginSQL,
+		updatePasswordByLogin: updateClientPasswordByLoginSQL,
+		getIdByLogin:          getClientIdByLoginSQL,
+	}
+	managerCredentials = credentials{
+		scope:                 string(RoleManager),
+		getPasswordByLogin:    getManagerPasswordByLoginSQL,
+		updatePasswordByLogin: updateManagerPasswordByLoginSQL,
+		getIdByLogin:          getManagerIdByLoginSQL,
+	}
+)
+
+// checkPassword answers an unknown login and a wrong password the same way,
+// and spends the same hashing time on both.
+func (receiver *Store) checkPassword(ctx context.Context,
+	login, password, source string, creds credentials) (bool, error) {
+
+	login = normalizeLogin(login)
+	err := receiver.checkLoginThrottle(ctx, creds.scope, login, source)
+	if err != nil {
+		return false, err
+	}
+
+	var dbPassword string
+	err = receiver.conn().QueryRowContext(ctx,
+		creds.getPasswordByLogin,
 		login).Scan(&dbPassword)
 
+	found := true
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return false, nil
+		if err != sql.ErrNoRows {
+			return false, queryError(creds.getPasswordByLogin, err)
 		}
-
-		return false, queryError(getPasswordByLogin, err)
+		found = false
+		dbPassword = dummyPasswordHash()
 	}
 
-	if dbPassword != password {
+	ok, needsRehash, err := verifyPassword(dbPassword, password)
+	if err != nil {
+		return false, err
+	}
+	if !ok || !found {
+		err = receiver.recordLoginFailure(ctx, creds.scope, login, source)
+		if err != nil {
+			return false, err
+		}
 		return false, ErrInvalidPass
 	}
 
-	return true, nil
-}
+	err = receiver.resetLoginFailures(ctx, creds.scope, login)
+	if err != nil {
+		return false, err
+	}
 
-func (receiver *QueryError) Unwrap() error {
-	return receiver.Err
-}
-func (receiver *QueryError) Error() string {
-	return fmt.Sprintf("can't execute query %s: %s", receiver.Query, receiver.Err.Error())
-}
-func queryError(query string, err error) *QueryError {
-	return &QueryError{Query: query, Err: err}
-}
+	if needsRehash {
+		err = receiver.rehashPassword(ctx, login, password, creds.updatePasswordByLogin)
+		if err != nil {
+			return false, err
+		}
+	}
 
-var ErrInvalidPass = errors.New("invalid password")
+	return true, nil
+}
+func (receiver *Store) rehashPassword(ctx context.Context,
+	login, password, updatePasswordByLogin string) error {
 
-type QueryError struct { // alt + enter
-	Query string
-	Err   error
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = receiver.conn().ExecContext(ctx, updatePasswordByLogin,
+		sql.Named("password", hash),
+		sql.Named("login", login),
+	)
+	if err != nil {
+		return queryError(updatePasswordByLogin, err)
+	}
+	return nil
 }
 
 //----------------------------JSON && XML----------------
@@ -349,34 +726,34 @@ type QueryError struct { // alt + enter
 //Export
 //JSON
 
-func ExportClientsToJSON(db *sql.DB) error {
-	return exportToFile(db, getAllClientsDataSQL, "clients.json",
+func (receiver *Store) ExportClientsToJSON(ctx context.Context, managerId int64) error {
+	return receiver.exportToFile(ctx, managerId, getAllClientsDataSQL, "clients.json",
 		mapRowToClient, json.Marshal, mapInterfaceSliceToClients)
 }
-func ExportAtmsToJSON(db *sql.DB) error {
-	return exportToFile(db, getAllAtmDataSQL, "atms.json",
+func (receiver *Store) ExportAtmsToJSON(ctx context.Context, managerId int64) error {
+	return receiver.exportToFile(ctx, managerId, getAllAtmsExportSQL, "atms.json",
 		mapRowToAtm, json.Marshal,
 		mapInterfaceSliceToAtms)
 }
-func ExportBankAccountsToJSON(db *sql.DB) error {
-	return exportToFile(db, getAllBankAccountsDataSQL, "bank-accounts.json",
+func (receiver *Store) ExportBankAccountsToJSON(ctx context.Context, managerId int64) error {
+	return receiver.exportToFile(ctx, managerId, getAllBankAccountsExportSQL, "bank-accounts.json",
 		mapRowToBankAccount, json.Marshal,
 		mapInterfaceSliceToBankAccounts)
 }
 
 //XML
 
-func ExportClientsToXML(db *sql.DB) error {
-	return exportToFile(db, getAllClientsDataSQL, "clients.xml",
+func (receiver *Store) ExportClientsToXML(ctx context.Context, managerId int64) error {
+	return receiver.exportToFile(ctx, managerId, getAllClientsDataSQL, "clients.xml",
 		mapRowToClient, xml.Marshal, mapInterfaceSliceToClients)
 }
-func ExportAtmsToXML(db *sql.DB) error {
-	return exportToFile(db, getAllAtmDataSQL, "atms.xml",
+func (receiver *Store) ExportAtmsToXML(ctx context.Context, managerId int64) error {
+	return receiver.exportToFile(ctx, managerId, getAllAtmsExportSQL, "atms.xml",
 		mapRowToAtm, xml.Marshal,
 		mapInterfaceSliceToAtms)
 }
-func ExportBankAccountsToXML(db *sql.DB) error {
-	return exportToFile(db, getAllBankAccountsDataSQL, "bank-accounts.xml",
+func (receiver *Store) ExportBankAccountsToXML(ctx context.Context, managerId int64) error {
+	return receiver.exportToFile(ctx, managerId, getAllBankAccountsExportSQL, "bank-accounts.xml",
 		mapRowToBankAccount, xml.Marshal,
 		mapInterfaceSliceToBankAccounts)
 }
@@ -391,8 +768,9 @@ func mapRowToClient(rows *sql.Rows) (interface{}, error) {
 	return client, nil
 }
 func mapRowToAtm(rows *sql.Rows) (interface{}, error) {
-	atm := Atm{}
-	err := rows.Scan(&atm.Id, &atm.Address)
+	atm := ExportedAtm{}
+	var err error
+	atm.Atm, err = scanAtm(rows, &atm.Deleted)
 	if err != nil {
 		return nil, err
 	}
@@ -405,10 +783,12 @@ func mapRowToBankAccount(rows *sql.Rows) (interface{}, error) {
 		&bankAccount.UserId,
 		&bankAccount.AccountId,
 		&bankAccount.Balance,
+		&bankAccount.Currency,
 	)
 	if err != nil {
 		return nil, err
 	}
+	bankAccount.MinorUnits = minorUnitsOf(bankAccount.Currency)
 	return bankAccount, nil
 }
 
@@ -421,9 +801,9 @@ func mapInterfaceSliceToClients(ifaces []interface{}) interface{} {
 	return clientsExport
 }
 func mapInterfaceSliceToAtms(ifaces []interface{}) interface{} {
-	atms := make([]Atm, len(ifaces))
+	atms := make([]ExportedAtm, len(ifaces))
 	for i := range ifaces {
-		atms[i] = ifaces[i].(Atm)
+		atms[i] = ifaces[i].(ExportedAtm)
 	}
 	atmsExport := AtmsExport{Atms: atms}
 	return atmsExport
@@ -441,17 +821,19 @@ type mapperRowTo func(rows *sql.Rows) (interface{}, error)
 type mapperInterfaceSliceTo func([]interface{}) interface{}
 type marshaller func(interface{}) ([]byte, error)
 
-func exportToFile(db *sql.DB, querySQL string, filename string,
-	mapRow mapperRowTo, marshal marshaller,
+func (receiver *Store) exportToFile(ctx context.Context, managerId int64,
+	querySQL string, filename string, mapRow mapperRowTo, marshal marshaller,
 	mapDataSlice mapperInterfaceSliceTo) error {
 
-	rows, err := db.Query(querySQL)
+	err := receiver.Authorize(ctx, managerId, PermissionDataExport)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		err = rows.Close()
-	}()
+	rows, err := receiver.conn().QueryContext(ctx, querySQL)
+	if err != nil {
+		return queryError(querySQL, err)
+	}
+	defer rows.Close()
 	var dataSlice []interface{}
 	for rows.Next() {
 		dataElement, err := mapRow(rows)
@@ -460,8 +842,15 @@ func exportToFile(db *sql.DB, querySQL string, filename string,
 		}
 		dataSlice = append(dataSlice, dataElement)
 	}
+	err = rows.Err()
+	if err != nil {
+		return queryError(querySQL, err)
+	}
 	exportData := mapDataSlice(dataSlice)
 	data, err := marshal(exportData)
+	if err != nil {
+		return err
+	}
 	err = ioutil.WriteFile(filename, data, 0666)
 	if err != nil {
 		return err
@@ -529,9 +918,13 @@ func importAtmsFromJSON(db *sql.DB) error {
 //Import
 
 //JSON
-func ImportClientsFromJSON(db *sql.DB) error {
-	return importFromFile(
-		db,
+func (receiver *Store) ImportClientsFromJSON(ctx context.Context, managerId int64) error {
+	err := receiver.Authorize(ctx, managerId, PermissionDataImport)
+	if err != nil {
+		return err
+	}
+	return receiver.importFromFile(
+		ctx,
 		"clients.json",
 		func(data []byte) ([]interface{}, error) {
 			return mapBytesToClients(data, json.Unmarshal)
@@ -539,19 +932,29 @@ func ImportClientsFromJSON(db *sql.DB) error {
 		insertClientToDB,
 	)
 }
-func ImportAtmsFromJSON(db *sql.DB) error {
-	return importFromFile(
-		db,
-		"atms.json",
-		func(data []byte) ([]interface{}, error) {
-			return mapBytesToAtms(data, json.Unmarshal)
-		},
-		insertAtmToDB,
-	)
+func (receiver *Store) ImportAtmsFromJSON(ctx context.Context, managerId int64) error {
+	err := receiver.Authorize(ctx, managerId, PermissionDataImport)
+	if err != nil {
+		return err
+	}
+	return receiver.InTx(ctx, func(tx *Store) error {
+		return tx.importFromFile(
+			ctx,
+			"atms.json",
+			func(data []byte) ([]interface{}, error) {
+				return mapBytesToAtms(data, json.Unmarshal)
+			},
+			insertAtmToDB,
+		)
+	})
 }
-func ImportBankAccountsFromJSON(db *sql.DB) error {
-	return importFromFile(
-		db,
+func (receiver *Store) ImportBankAccountsFromJSON(ctx context.Context, managerId int64) error {
+	err := receiver.Authorize(ctx, managerId, PermissionDataImport)
+	if err != nil {
+		return err
+	}
+	return receiver.importFromFile(
+		ctx,
 		"banc-accounts.json",
 		func(data []byte) ([]interface{}, error) {
 			return mapBytesToBankAccounts(data, json.Unmarshal)
@@ -560,9 +963,13 @@ func ImportBankAccountsFromJSON(db *sql.DB) error {
 	)
 }
 
-func ImportClientsFromXML(db *sql.DB) error {
-	return importFromFile(
-		db,
+func (receiver *Store) ImportClientsFromXML(ctx context.Context, managerId int64) error {
+	err := receiver.Authorize(ctx, managerId, PermissionDataImport)
+	if err != nil {
+		return err
+	}
+	return receiver.importFromFile(
+		ctx,
 		"clients.xml",
 		func(data []byte) ([]interface{}, error) {
 			return mapBytesToClients(data, xml.Unmarshal)
@@ -570,19 +977,29 @@ func ImportClientsFromXML(db *sql.DB) error {
 		insertClientToDB,
 	)
 }
-func ImportAtmsFromXML(db *sql.DB) error {
-	return importFromFile(
-		db,
-		"atms.xml",
-		func(data []byte) ([]interface{}, error) {
-			return mapBytesToAtms(data, xml.Unmarshal)
-		},
-		insertAtmToDB,
-	)
+func (receiver *Store) ImportAtmsFromXML(ctx context.Context, managerId int64) error {
+	err := receiver.Authorize(ctx, managerId, PermissionDataImport)
+	if err != nil {
+		return err
+	}
+	return receiver.InTx(ctx, func(tx *Store) error {
+		return tx.importFromFile(
+			ctx,
+			"atms.xml",
+			func(data []byte) ([]interface{}, error) {
+				return mapBytesToAtms(data, xml.Unmarshal)
+			},
+			insertAtmToDB,
+		)
+	})
 }
-func ImportBankAccountsFromXML(db *sql.DB) error {
-	return importFromFile(
-		db,
+func (receiver *Store) ImportBankAccountsFromXML(ctx context.Context, managerId int64) error {
+	err := receiver.Authorize(ctx, managerId, PermissionDataImport)
+	if err != nil {
+		return err
+	}
+	return receiver.importFromFile(
+		ctx,
 		"banc-accounts.xml",
 		func(data []byte) ([]interface{}, error) {
 			return mapBytesToBankAccounts(data, xml.Unmarshal)
@@ -605,20 +1022,31 @@ func mapBytesToClients(data []byte,
 	}
 	return ifaces, nil
 }
-func insertClientToDB(iface interface{}, db *sql.DB) error {
+func insertClientToDB(ctx context.Context, iface interface{}, store *Store) error {
 	client := iface.(Client)
-	_, err := db.Exec(
-		insertClientSQL,
-		sql.Named("id", client.Id),
-		sql.Named("name", client.Name),
-		sql.Named("login", client.Login),
-		sql.Named("password", client.Password),
-		sql.Named("phone", client.Phone),
-	)
-	if err != nil {
-		return err
+	login := normalizeLogin(client.Login)
+	// files from older exports carry plaintext passwords
+	if findHasher(client.Password) == nil {
+		hash, err := hashPassword(client.Password)
+		if err != nil {
+			return err
+		}
+		client.Password = hash
 	}
-	return nil
+	return store.insertWithUniqueLogin(ctx, login, func(tx *Store) error {
+		_, err := tx.conn().ExecContext(ctx,
+			insertClientSQL,
+			sql.Named("id", client.Id),
+			sql.Named("name", client.Name),
+			sql.Named("login", login),
+			sql.Named("password", client.Password),
+			sql.Named("phone", client.Phone),
+		)
+		if err != nil {
+			return queryError(insertClientSQL, err)
+		}
+		return tx.dialect.syncIdSequence(ctx, tx.conn(), "clients")
+	})
 }
 
 func mapBytesToAtms(data []byte,
@@ -635,17 +1063,65 @@ func mapBytesToAtms(data []byte,
 	}
 	return ifaces, nil
 }
-func insertAtmToDB(iface interface{}, db *sql.DB) error {
-	atm := iface.(Atm)
-	_, err := db.Exec(
+// insertAtmToDB creates the ATMs that aren't there and updates the ones
+// that are. A deleted ATM stays deleted, the file may only agree with it.
+func insertAtmToDB(ctx context.Context, iface interface{}, store *Store) error {
+	exported := iface.(ExportedAtm)
+	atm, err := normalizeAtm(exported.Atm)
+	if err != nil {
+		return err
+	}
+	result, err := store.conn().ExecContext(ctx,
 		insertAtmSQL,
 		sql.Named("id", atm.Id),
-		sql.Named("name", atm.Address),
+		sql.Named("address", atm.Address),
 	)
+	if err != nil {
+		return queryError(insertAtmSQL, err)
+	}
+	inserted, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
-	return nil
+	action := AtmCreated
+	if inserted == 0 {
+		action = AtmUpdated
+		result, err = store.conn().ExecContext(ctx, updateAtmAddressSQL,
+			sql.Named("address", atm.Address),
+			sql.Named("id", atm.Id),
+		)
+		if err != nil {
+			return queryError(updateAtmAddressSQL, err)
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			if exported.Deleted {
+				return nil
+			}
+			return fmt.Errorf("%w: %d is deleted", ErrAtmNotFound, atm.Id)
+		}
+	}
+	err = store.setAtmDetails(ctx, atm)
+	if err == nil {
+		err = store.logAtmChange(ctx, action, 0, "import", atm)
+	}
+	if err == nil && exported.Deleted {
+		_, err = store.conn().ExecContext(ctx, deleteAtmSQL,
+			sql.Named("atm_id", atm.Id),
+			sql.Named("deleted_at", now().Unix()),
+		)
+		if err != nil {
+			return queryError(deleteAtmSQL, err)
+		}
+		err = store.logAtmChange(ctx, AtmDeleted, 0, "import", atm)
+	}
+	if err != nil {
+		return err
+	}
+	return store.dialect.syncIdSequence(ctx, store.conn(), "atms")
 }
 
 func mapBytesToBankAccounts(data []byte,
@@ -662,36 +1138,82 @@ func mapBytesToBankAccounts(data []byte,
 	}
 	return ifaces, nil
 }
-func insertBankAccountToDB(iface interface{}, db *sql.DB) error {
+func insertBankAccountToDB(ctx context.Context, iface interface{}, store *Store) error {
 	bankAccount := iface.(BankAccount)
-	_, err := db.Exec(
-		insertBankAccountSQL,
-		sql.Named("id", bankAccount.Id),
-		sql.Named("balance", bankAccount.Balance),
-		sql.Named("account_number", bankAccount.AccountId),
-		sql.Named("client_id", bankAccount.UserId),
-	)
+	if bankAccount.Balance < 0 {
+		return accountError(LedgerClient, bankAccount.UserId, bankAccount.AccountId,
+			ErrInvalidAmount)
+	}
+	// files exported before currencies existed have none
+	if bankAccount.Currency == "" {
+		bankAccount.Currency = DefaultCurrency
+	}
+	currency, err := LookupCurrency(bankAccount.Currency)
 	if err != nil {
-		return err
+		return accountError(LedgerClient, bankAccount.UserId, bankAccount.AccountId, err)
 	}
-	return nil
+	return store.InTx(ctx, func(tx *Store) error {
+		result, err := tx.conn().ExecContext(ctx,
+			insertBankAccountSQL,
+			sql.Named("id", bankAccount.Id),
+			sql.Named("balance", bankAccount.Balance),
+			sql.Named("account_number", bankAccount.AccountId),
+			sql.Named("client_id", bankAccount.UserId),
+		)
+		if err != nil {
+			return err
+		}
+		err = tx.dialect.syncIdSequence(ctx, tx.conn(), "bank_accounts")
+		if err != nil {
+			return err
+		}
+		err = tx.reserveAccountNumber(ctx, LedgerClient, bankAccount.UserId, bankAccount.AccountId)
+		if err != nil {
+			return err
+		}
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if inserted == 0 {
+			return nil
+		}
+		err = tx.setAccountCurrency(ctx, LedgerClient, bankAccount.Id, currency.Code)
+		if err != nil {
+			return err
+		}
+		err = tx.assignAccountID(ctx, LedgerClient, bankAccount.Id)
+		if err != nil || bankAccount.Balance == 0 {
+			return err
+		}
+
+		// the opening balance comes from outside the journal
+		_, err = tx.writeJournal(ctx, KindImport, []Posting{
+			{Account: LedgerClient, AccountId: bankAccount.Id, Amount: bankAccount.Balance},
+			{Account: LedgerCash, Amount: -bankAccount.Balance},
+		})
+		return err
+	})
 }
 
-func importFromFile(db *sql.DB, filename string,
+func (receiver *Store) importFromFile(ctx context.Context, filename string,
 	mapBytesToInterfaces func([]byte) ([]interface{}, error),
-	insertToDB func(interface{}, *sql.DB) error,
+	insertToDB func(context.Context, interface{}, *Store) error,
 ) error {
 	itemsData, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return err
+		return fmt.Errorf("can't import %s: %w", filename, err)
 	}
 
 	sliceData, err := mapBytesToInterfaces(itemsData)
+	if err != nil {
+		return fmt.Errorf("can't import %s: %w", filename, err)
+	}
 
-	for _, datum := range sliceData {
-		err = insertToDB(datum, db)
+	for index, datum := range sliceData {
+		err = insertToDB(ctx, datum, receiver)
 		if err != nil {
-			return err
+			return fmt.Errorf("can't import %s, item %d: %w", filename, index+1, err)
 		}
 	}
 
@@ -699,11 +1221,11 @@ func importFromFile(db *sql.DB, filename string,
 }
 
 //---------------Client
-func AtmsList(db *sql.DB) ([]string, error) {
+func (receiver *Store) AtmsList(ctx context.Context) ([]string, error) {
 	atms := make([]string, 0)
-	rows, err := db.Query(getAllAtmAddressesSQL)
+	rows, err := receiver.conn().QueryContext(ctx, getAllAtmAddressesSQL)
 	if err != nil {
-		return nil, err
+		return nil, queryError(getAllAtmAddressesSQL, err)
 	}
 	defer func() {
 		err = rows.Close()
@@ -715,21 +1237,21 @@ func AtmsList(db *sql.DB) ([]string, error) {
 	for rows.Next() {
 		err = rows.Scan(&address)
 		if err != nil {
-			return nil, err
+			return nil, queryError(getAllAtmAddressesSQL, err)
 		}
 		atms = append(atms, address)
 	}
 	err = rows.Err()
 	if err != nil {
-		return nil, err
+		return nil, queryError(getAllAtmAddressesSQL, err)
 	}
 
 	return atms, nil
 }
-func BankAccountsList(id int64, db *sql.DB) ([]BankAccount, error) {
-	rows, err := db.Query(getAllBankAccountsWithoutIdSQL, id)
+func (receiver *Store) BankAccountsList(ctx context.Context, id int64) ([]BankAccount, error) {
+	rows, err := receiver.conn().QueryContext(ctx, getAllBankAccountsWithoutIdSQL, id)
 	if err != nil {
-		return nil, err
+		return nil, queryError(getAllBankAccountsWithoutIdSQL, err)
 	}
 	bankAccounts := make([]BankAccount, 0)
 	defer func() {
@@ -739,28 +1261,31 @@ func BankAccountsList(id int64, db *sql.DB) ([]BankAccount, error) {
 		}
 	}()
 	var balance, accountId int64
+	var currency string
 	for rows.Next() {
-		err = rows.Scan(&balance, &accountId)
+		err = rows.Scan(&balance, &accountId, &currency)
 		if err != nil {
-			return nil, err
+			return nil, queryError(getAllBankAccountsWithoutIdSQL, err)
 		}
 		bankAccounts = append(bankAccounts, BankAccount{
-			UserId:    id,
-			AccountId: accountId,
-			Balance:   balance,
+			UserId:     id,
+			AccountId:  accountId,
+			Balance:    balance,
+			Currency:   currency,
+			MinorUnits: minorUnitsOf(currency),
 		})
 	}
 	err = rows.Err()
 	if err != nil {
-		return nil, err
+		return nil, queryError(getAllBankAccountsWithoutIdSQL, err)
 	}
 
 	return bankAccounts, nil
 }
-func GetAllAccountNumbersByClientId(id int64, db *sql.DB) ([]int64, error) {
-	rows, err := db.Query(getAccountNumbersByClientIdSQL, id)
+func (receiver *Store) GetAllAccountNumbersByClientId(ctx context.Context, id int64) ([]int64, error) {
+	rows, err := receiver.conn().QueryContext(ctx, getAccountNumbersByClientIdSQL, id)
 	if err != nil {
-		return nil, err
+		return nil, queryError(getAccountNumbersByClientIdSQL, err)
 	}
 	defer rows.Close()
 
@@ -769,10 +1294,14 @@ func GetAllAccountNumbersByClientId(id int64, db *sql.DB) ([]int64, error) {
 	for rows.Next() {
 		err := rows.Scan(&accountNumber)
 		if err != nil {
-			return nil, err
+			return nil, queryError(getAccountNumbersByClientIdSQL, err)
 		}
 		bankAccounts = append(bankAccounts, accountNumber)
 	}
+	err = rows.Err()
+	if err != nil {
+		return nil, queryError(getAccountNumbersByClientIdSQL, err)
+	}
 
 	return bankAccounts, nil
-}
\ No newline at end of file
+}
@@ -2,25 +2,90 @@ package core
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	_ "github.com/mattn/go-sqlite3"
 	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 )
 
 func createDBinMemory(t *testing.T) *sql.DB {
-	db, err := sql.Open("sqlite3", ":memory:")
+	db, err := sql.Open("sqlite3", ":memory:?_foreign_keys=1")
 	if err != nil {
 		t.Fatal(err)
 	}
 	return db
 }
 
+// chdirToTempDir moves a test to a directory of its own, exports and imports
+// use the current one. It returns the path of testData.
+func chdirToTempDir(t *testing.T) (testData string, cleanup func()) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "ib-core")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(wd, "testData"), func() {
+		_ = os.Chdir(wd)
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// copyTestData puts a file of testData to the current directory.
+func copyTestData(t *testing.T, testData, filename string) {
+	data, err := ioutil.ReadFile(filepath.Join(testData, filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filename, data, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// execAdminDDL gives a database made table by table the default admin and
+// the roles that the permission checks need.
+func execAdminDDL(t *testing.T, db *sql.DB) {
+	ctx := context.Background()
+	for _, ddl := range []string{managersDDL, rolesDDL, permissionsDDL, rolePermissionsDDL, managerRolesDDL} {
+		_, err := db.Exec(ddl)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := db.Exec(managersInitData, sql.Named("password", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(db)
+	err = store.initRoles(ctx)
+	if err == nil {
+		err = store.grantRole(ctx, defaultAdminId, RoleAdmin)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func Test_exportClientsToJSON(t *testing.T) {
+	testData, cleanup := chdirToTempDir(t)
+	defer cleanup()
 	db := createDBinMemory(t)
 	defer db.Close()
-	_, err := db.Exec(clientsDDL)
+	err := Init(db)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -33,12 +98,12 @@ VALUES ('loginOne', 'secret1', 'Alisher', '123'),
 		t.Fatal(err)
 	}
 
-	err = ExportClientsToJSON(db)
+	err = ExportClientsToJSON(defaultAdminId, db)
 	if err != nil {
 		t.Error(err)
 	}
 
-	bytesWant, err := ioutil.ReadFile("testData/clients.json")
+	bytesWant, err := ioutil.ReadFile(filepath.Join(testData, "clients.json"))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -52,7 +117,11 @@ VALUES ('loginOne', 'secret1', 'Alisher', '123'),
 	}
 }
 func Test_importClientsFromJSON(t *testing.T) {
-	db, err := sql.Open("sqlite3", ":memory:")
+	testData, cleanup := chdirToTempDir(t)
+	defer cleanup()
+	copyTestData(t, testData, "clients.json")
+
+	db, err := sql.Open("sqlite3", ":memory:?_foreign_keys=1")
 	if err != nil {
 		t.Fatalf("can't open db: %v", err)
 	}
@@ -61,14 +130,19 @@ func Test_importClientsFromJSON(t *testing.T) {
 			t.Fatalf("can't close db: %v", err)
 		}
 	}()
-	_, err = db.Exec(clientsDDL)
+	err = Init(db)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = ImportClientsFromJSON(db)
+	err = ImportClientsFromJSON(defaultAdminId, db)
 	if err != nil {
 		t.Error(err)
 	}
+	// logins are normalized and checked like the ones added one by one
+	err = ImportClientsFromJSON(defaultAdminId, db)
+	if !errors.Is(err, ErrLoginTaken) {
+		t.Errorf("want: %v, got: %v", ErrLoginTaken, err)
+	}
 
 	rows, err := db.Query(`SELECT *
 FROM clients;`)
@@ -81,14 +155,14 @@ FROM clients;`)
 	clientsWant := []Client{
 		{
 			1,
-			"loginOne",
+			"loginone",
 			"secret1",
 			"Alisher",
 			"123",
 		},
 		{
 				2,
-				"loginTwo",
+				"logintwo",
 				"secret2",
 				"Fozilov",
 				"456",
@@ -101,6 +175,11 @@ FROM clients;`)
 		if err != nil {
 			t.Error(err)
 		}
+		passwordWant := clientsWant[index].Password
+		if ok, _, _ := verifyPassword(clientGot.Password, passwordWant); !ok || clientGot.Password == passwordWant {
+			t.Errorf("want hash of: %v, got: %v", passwordWant, clientGot.Password)
+		}
+		clientGot.Password = passwordWant
 		if clientGot != clientsWant[index] {
 			t.Errorf("want: \n%v\n, got: \n%v\n", clientsWant, clientGot)
 		}
@@ -109,9 +188,11 @@ FROM clients;`)
 }
 
 func Test_exportClientsToXML(t *testing.T) {
+	testData, cleanup := chdirToTempDir(t)
+	defer cleanup()
 	db := createDBinMemory(t)
 	defer db.Close()
-	_, err := db.Exec(clientsDDL)
+	err := Init(db)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -124,12 +205,12 @@ VALUES ('loginOne', 'secret1', 'Alisher', '123'),
 		t.Fatal(err)
 	}
 
-	err = ExportClientsToXML(db)
+	err = ExportClientsToXML(defaultAdminId, db)
 	if err != nil {
 		t.Error(err)
 	}
 
-	bytesWant, err := ioutil.ReadFile("testData/clients.xml")
+	bytesWant, err := ioutil.ReadFile(filepath.Join(testData, "clients.xml"))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -144,7 +225,11 @@ VALUES ('loginOne', 'secret1', 'Alisher', '123'),
 	}
 }
 func Test_importClientsFromXML(t *testing.T) {
-	db, err := sql.Open("sqlite3", ":memory:")
+	testData, cleanup := chdirToTempDir(t)
+	defer cleanup()
+	copyTestData(t, testData, "clients.xml")
+
+	db, err := sql.Open("sqlite3", ":memory:?_foreign_keys=1")
 	if err != nil {
 		t.Fatalf("can't open db: %v", err)
 	}
@@ -153,11 +238,11 @@ func Test_importClientsFromXML(t *testing.T) {
 			t.Fatalf("can't close db: %v", err)
 		}
 	}()
-	_, err = db.Exec(clientsDDL)
+	err = Init(db)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = ImportClientsFromXML(db)
+	err = ImportClientsFromXML(defaultAdminId, db)
 	if err != nil {
 		t.Error(err)
 	}
@@ -172,14 +257,14 @@ FROM clients;`)
 	clientsWant := []Client{
 		{
 			1,
-			"loginOne",
+			"loginone",
 			"secret1",
 			"Alisher",
 			"123",
 		},
 		{
 			2,
-			"loginTwo",
+			"logintwo",
 			"secret2",
 			"Fozilov",
 			"456",
@@ -193,6 +278,11 @@ FROM clients;`)
 		if err != nil {
 			t.Error(err)
 		}
+		passwordWant := clientsWant[index].Password
+		if ok, _, _ := verifyPassword(clientGot.Password, passwordWant); !ok || clientGot.Password == passwordWant {
+			t.Errorf("want hash of: %v, got: %v", passwordWant, clientGot.Password)
+		}
+		clientGot.Password = passwordWant
 		if clientGot != clientsWant[index] {
 			t.Errorf("want: \n%v\n, got: \n%v\n", clientsWant, clientGot)
 		}
@@ -200,92 +290,60 @@ FROM clients;`)
 	}
 }
 
-func Test_checkManagerLoginOnUnique(t *testing.T) {
+func Test_uniqueLogins(t *testing.T) {
 	db := createDBinMemory(t)
-	ok, err := checkManagerLoginOnUnique("", db)
-
-	if ok {
-		t.Error("want false, got true")
-	}
-
-	ok, err = checkManagerLoginOnUnique("abc", db)
-
-	if ok {
-		t.Error("want false, got true")
-	}
+	defer db.Close()
 
-	_, err = db.Exec(managersDDL)
+	err := Init(db)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	_, err = db.Exec(`INSERT INTO managers (login, password) 
-VALUES ('test', 'test-password')`)
+	err = AddClient(defaultAdminId, Client{Login: " Alice ", Password: "secret"}, db)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	ok, _ = checkManagerLoginOnUnique("test", db)
-
-	if ok {
-		t.Error("want false, got true")
-	}
-
-	ok, err = checkManagerLoginOnUnique("coolLogin", db)
-	if err != nil {
-		if err != sql.ErrNoRows {
-			t.Errorf("want error type ErrNoRows, got: %v", err)
+	for _, add := range []func() error{
+		func() error { return AddClient(defaultAdminId, Client{Login: "alice"}, db) },
+		func() error { return AddManager(defaultAdminId, Manager{Login: "ALICE"}, db) },
+		func() error { return AddClient(defaultAdminId, Client{Login: "Admin"}, db) },
+	} {
+		err = add()
+		if !errors.Is(err, ErrLoginTaken) {
+			t.Errorf("want: %v, got: %v", ErrLoginTaken, err)
 		}
 	}
-	if !ok {
-		t.Error("want true, got false")
-	}
-}
-func Test_checkClientLoginOnUnique(t *testing.T) {
-	db := createDBinMemory(t)
-	ok, err := checkClientLoginOnUnique("", db)
-
-	if ok {
-		t.Error("want false, got true")
-	}
-
-	ok, err = checkClientLoginOnUnique("abc", db)
-
-	if ok {
-		t.Error("want false, got true")
-	}
 
-	_, err = db.Exec(clientsDDL)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	_, err = db.Exec(`INSERT INTO clients (login, password, name, phone) 
-VALUES ('test', '', '', '')`)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	ok, _ = checkClientLoginOnUnique("test", db)
-
-	if ok {
-		t.Error("want false, got true")
+	for login, want := range map[string]bool{
+		"alice":   false,
+		" ALICE":  false,
+		"admin":   false,
+		"bob":     true,
+		"alice.b": true,
+	} {
+		available, err := IsLoginAvailable(login, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if available != want {
+			t.Errorf("%q: want: %v, got: %v", login, want, available)
+		}
 	}
 
-	ok, err = checkClientLoginOnUnique("coolLogin", db)
-	if err != nil {
-		if err != sql.ErrNoRows {
-			t.Errorf("want error type ErrNoRows, got: %v", err)
-		}
+	id, err := GetClientIdByLogin("aLiCe", db)
+	if err != nil || id != 1 {
+		t.Errorf("want: 1, got: %v, %v", id, err)
 	}
-	if !ok {
-		t.Error("want true, got false")
+	ok, err := LoginForClient("ALICE ", "secret", db)
+	if err != nil || !ok {
+		t.Errorf("want true, got: %v, %v", ok, err)
 	}
 }
 
 func Test_addClient(t *testing.T) {
 	db := createDBinMemory(t)
 	defer db.Close()
+	execAdminDDL(t, db)
 
 	client := Client{
 		Login:    "a",
@@ -293,7 +351,7 @@ func Test_addClient(t *testing.T) {
 		Name:     "c",
 		Phone:    "d",
 	}
-	err := AddClient(client, db)
+	err := AddClient(defaultAdminId, client, db)
 	if err == nil {
 		t.Error("want not nil error")
 	}
@@ -302,8 +360,12 @@ func Test_addClient(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	_, err = db.Exec(managersDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	err = AddClient(client, db)
+	err = AddClient(defaultAdminId, client, db)
 	if err != nil {
 		t.Error("want nil error")
 	}
@@ -318,8 +380,8 @@ func Test_addClient(t *testing.T) {
 	if client.Login != "a" {
 		t.Errorf("want: %v, got: %v", client.Login, "a")
 	}
-	if client.Password != "b" {
-		t.Errorf("want: %v, got: %v", client.Password, "b")
+	if ok, _, _ := verifyPassword(client.Password, "b"); !ok || client.Password == "b" {
+		t.Errorf("want hash of: %v, got: %v", "b", client.Password)
 	}
 	if client.Name != "c" {
 		t.Errorf("want: %v, got: %v", client.Name, "c")
@@ -331,12 +393,13 @@ func Test_addClient(t *testing.T) {
 func Test_addManager(t *testing.T) {
 	db := createDBinMemory(t)
 	defer db.Close()
+	execAdminDDL(t, db)
 
 	manager := Manager{
 		Login:    "a",
 		Password: "b",
 	}
-	err := AddManager(manager, db)
+	err := AddManager(defaultAdminId, manager, db)
 	if err == nil {
 		t.Error("want not nil error")
 	}
@@ -345,32 +408,37 @@ func Test_addManager(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	_, err = db.Exec(clientsDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	err = AddManager(manager, db)
+	err = AddManager(defaultAdminId, manager, db)
 	if err != nil {
 		t.Error("want nil error, got: ", err)
 	}
 
-	err = db.QueryRow(`SELECT * FROM managers`).Scan(&manager.Id, &manager.Login, &manager.Password)
+	err = db.QueryRow(`SELECT * FROM managers WHERE login = 'a'`).Scan(&manager.Id, &manager.Login, &manager.Password)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if manager.Id != 1 {
-		t.Errorf("want: %v, got: %v", manager.Id, 1)
+	if manager.Id != 2 {
+		t.Errorf("want: %v, got: %v", 2, manager.Id)
 	}
 	if manager.Login != "a" {
 		t.Errorf("want: %v, got: %v", manager.Login, "a")
 	}
-	if manager.Password != "b" {
-		t.Errorf("want: %v, got: %v", manager.Password, "b")
+	if ok, _, _ := verifyPassword(manager.Password, "b"); !ok || manager.Password == "b" {
+		t.Errorf("want hash of: %v, got: %v", "b", manager.Password)
 	}
 }
 
 func Test_addBankAccountToClient(t *testing.T) {
 	db := createDBinMemory(t)
 	defer db.Close()
+	execAdminDDL(t, db)
 
-	err := AddBankAccountToClient(0, db)
+	err := AddBankAccountToClient(defaultAdminId, 0, db)
 	if err == nil {
 		t.Error("want not nil error")
 	}
@@ -380,7 +448,7 @@ func Test_addBankAccountToClient(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = AddBankAccountToClient(0, db)
+	err = AddBankAccountToClient(defaultAdminId, 0, db)
 	if err == nil {
 		t.Error("want not nil error")
 	}
@@ -403,7 +471,7 @@ func Test_addBankAccountToClient(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = AddBankAccountToClient(1, db)
+	err = AddBankAccountToClient(defaultAdminId, 1, db)
 	if err == nil {
 		t.Error("want not nil error")
 	}
@@ -412,8 +480,20 @@ func Test_addBankAccountToClient(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	_, err = db.Exec(accountSequencesDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(accountIdentifiersDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(accountCurrenciesDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	err = AddBankAccountToClient(1, db)
+	err = AddBankAccountToClient(defaultAdminId, 1, db)
 	if err != nil {
 		t.Error("want nil error, got: ", err)
 	}
@@ -434,7 +514,7 @@ func Test_addBankAccountToClient(t *testing.T) {
 		t.Errorf("want: \n%v\ngot: \n%v\n", bankAccountWant, bankAccountGot)
 	}
 
-	err = AddBankAccountToClient(1, db)
+	err = AddBankAccountToClient(defaultAdminId, 1, db)
 	if err != nil {
 		t.Error("want nil error, got: ", err)
 	}
@@ -466,8 +546,9 @@ ORDER BY 1 DESC LIMIT 1`,
 func Test_addBankAccountToService(t *testing.T) {
 	db := createDBinMemory(t)
 	defer db.Close()
+	execAdminDDL(t, db)
 
-	err := AddBankAccountToService(0, db)
+	err := AddBankAccountToService(defaultAdminId, 0, db)
 	if err == nil {
 		t.Error("want not nil error")
 	}
@@ -477,7 +558,7 @@ func Test_addBankAccountToService(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = AddBankAccountToService(0, db)
+	err = AddBankAccountToService(defaultAdminId, 0, db)
 	if err == nil {
 		t.Error("want not nil error")
 	}
@@ -493,7 +574,7 @@ func Test_addBankAccountToService(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = AddBankAccountToService(1, db)
+	err = AddBankAccountToService(defaultAdminId, 1, db)
 	if err == nil {
 		t.Error("want not nil error")
 	}
@@ -502,8 +583,20 @@ func Test_addBankAccountToService(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	_, err = db.Exec(accountSequencesDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(accountIdentifiersDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(accountCurrenciesDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	err = AddBankAccountToService(1, db)
+	err = AddBankAccountToService(defaultAdminId, 1, db)
 	if err != nil {
 		t.Error("want nil error, got: ", err)
 	}
@@ -524,7 +617,7 @@ func Test_addBankAccountToService(t *testing.T) {
 		t.Errorf("want: \n%v\ngot: \n%v\n", bankAccountWant, bankAccountGot)
 	}
 
-	err = AddBankAccountToService(1, db)
+	err = AddBankAccountToService(defaultAdminId, 1, db)
 	if err != nil {
 		t.Error("want nil error, got: ", err)
 	}
@@ -557,8 +650,9 @@ ORDER BY 1 DESC LIMIT 1`,
 func Test_addATM(t *testing.T) {
 	db := createDBinMemory(t)
 	defer db.Close()
+	execAdminDDL(t, db)
 
-	err := AddATM("atm-address", db)
+	err := AddATM(defaultAdminId, "atm-address", db)
 	if err == nil {
 		t.Error("want not nil error")
 	}
@@ -567,9 +661,17 @@ func Test_addATM(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	_, err = db.Exec(atmLifecycleDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(atmDetailsDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	addressWant := "Rogun"
-	err = AddATM(addressWant, db)
+	err = AddATM(defaultAdminId, addressWant, db)
 	if err != nil {
 		t.Error("want nil error, got: ", err)
 	}
@@ -589,7 +691,7 @@ func Test_addATM(t *testing.T) {
 	}
 
 	addressWant = "Dushanbe"
-	err = AddATM(addressWant, db)
+	err = AddATM(defaultAdminId, addressWant, db)
 	if err != nil {
 		t.Error("want nil error, got: ", err)
 	}
@@ -610,11 +712,12 @@ func Test_addATM(t *testing.T) {
 func Test_addService(t *testing.T) {
 	db := createDBinMemory(t)
 	defer db.Close()
+	execAdminDDL(t, db)
 
 	service := Service{
 		Name: "taxes",
 	}
-	_, err := AddService(service, db)
+	_, err := AddService(defaultAdminId, service, db)
 	if err == nil {
 		t.Error("want not nil error")
 	}
@@ -627,8 +730,20 @@ func Test_addService(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	_, err = db.Exec(accountSequencesDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(accountIdentifiersDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(accountCurrenciesDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	_, err = AddService(service, db)
+	_, err = AddService(defaultAdminId, service, db)
 	if err != nil {
 		t.Error("want nil error, got: ", err)
 	}
@@ -651,7 +766,7 @@ func Test_addService(t *testing.T) {
 	}
 
 	service.Name = "big-taxes"
-	_, err = AddService(service, db)
+	_, err = AddService(defaultAdminId, service, db)
 	if err != nil {
 		t.Error("want nil error, got: ", err)
 	}
@@ -835,10 +950,14 @@ func Test_loginForManager(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	_, err = db.Exec(loginAttemptsDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	ok, err = LoginForManager("hello", "golang", db)
-	if err != nil {
-		t.Error("want not nil error")
+	if err != ErrInvalidPass {
+		t.Errorf("want: %v, got: %v", ErrInvalidPass, err)
 	}
 	if ok == true {
 		t.Error("want: false, got true")
@@ -854,8 +973,8 @@ func Test_loginForManager(t *testing.T) {
 	)
 
 	ok, err = LoginForManager("hello", "golang", db)
-	if err != nil {
-		t.Error("want not nil error")
+	if err != ErrInvalidPass {
+		t.Errorf("want: %v, got: %v", ErrInvalidPass, err)
 	}
 	if ok == true {
 		t.Error("want: false, got true")
@@ -886,10 +1005,14 @@ func Test_loginForClient(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	_, err = db.Exec(loginAttemptsDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	ok, err = LoginForClient("hello", "golang", db)
-	if err != nil {
-		t.Error("want not nil error")
+	if err != ErrInvalidPass {
+		t.Errorf("want: %v, got: %v", ErrInvalidPass, err)
 	}
 	if ok == true {
 		t.Error("want: false, got true")
@@ -907,8 +1030,8 @@ func Test_loginForClient(t *testing.T) {
 	)
 
 	ok, err = LoginForClient("hello", "golang", db)
-	if err != nil {
-		t.Error("want not nil error")
+	if err != ErrInvalidPass {
+		t.Errorf("want: %v, got: %v", ErrInvalidPass, err)
 	}
 	if ok == true {
 		t.Error("want: false, got true")
@@ -927,6 +1050,7 @@ func Test_loginForClient(t *testing.T) {
 func Test_transferToClient(t *testing.T) {
 	db := createDBinMemory(t)
 	defer db.Close()
+	execAdminDDL(t, db)
 
 	transfer := MoneyTransfer{
 		Amount:                100,
@@ -949,6 +1073,30 @@ func Test_transferToClient(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	_, err = db.Exec(accountSequencesDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(accountIdentifiersDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(accountCurrenciesDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(journalTransactionsDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(journalPostingsDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(managersDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	err = TransferToClient(transfer, db)
 	if err == nil {
@@ -959,7 +1107,7 @@ func Test_transferToClient(t *testing.T) {
 		Login: "first",
 	}
 
-	err = AddClient(clientSender, db)
+	err = AddClient(defaultAdminId, clientSender, db)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -967,7 +1115,7 @@ func Test_transferToClient(t *testing.T) {
 	clientReceiver := Client{
 		Login: "second",
 	}
-	err = AddClient(clientReceiver, db)
+	err = AddClient(defaultAdminId, clientReceiver, db)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -976,7 +1124,7 @@ func Test_transferToClient(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = AddBankAccountToClient(id1, db)
+	err = AddBankAccountToClient(defaultAdminId, id1, db)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -984,7 +1132,7 @@ func Test_transferToClient(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = AddBankAccountToClient(id2, db)
+	err = AddBankAccountToClient(defaultAdminId, id2, db)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1000,7 +1148,7 @@ func Test_transferToClient(t *testing.T) {
 		t.Error("want not nil error")
 	}
 
-	err = ReplenishBankAccount(id1, 0, 100, db)
+	_, err = ReplenishBankAccount(defaultAdminId, id1, 0, 100, db)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1040,4 +1188,111 @@ func Test_transferToClient(t *testing.T) {
 
 	transfer.ReceiverId = 1
 	err = TransferToClient(transfer, db)
-}
\ No newline at end of file
+}
+
+func Test_accountNumbers(t *testing.T) {
+	db, cleanup := createDBinFile(t)
+	defer cleanup()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, login := range []string{"first", "second", "third"} {
+		err = AddClient(defaultAdminId, Client{Login: login}, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []int64{2, 1, 2, 1, 1} {
+		err = AddBankAccountToClient(defaultAdminId, id, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	numbers, err := GetAllAccountNumbersByClientId(1, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(numbers) != "[0 1 2]" {
+		t.Errorf("want: [0 1 2], got: %v", numbers)
+	}
+
+	_, err = db.Exec(`DELETE FROM bank_accounts WHERE client_id = 1 AND account_number = 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddBankAccountToClient(defaultAdminId, 1, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numbers, err = GetAllAccountNumbersByClientId(1, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(numbers) != "[0 1 3]" {
+		t.Errorf("want number 2 not reused, got: %v", numbers)
+	}
+
+	const parallel = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, parallel)
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- AddBankAccountToClient(defaultAdminId, 3, db)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	numbers, err = GetAllAccountNumbersByClientId(3, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[int64]bool)
+	for _, number := range numbers {
+		if seen[number] || number < 0 || number >= parallel {
+			t.Errorf("want numbers 0..%d once each, got: %v", parallel-1, numbers)
+			break
+		}
+		seen[number] = true
+	}
+}
+
+func Test_accountSequencesContinueLegacyNumbers(t *testing.T) {
+	db, cleanup := createDBinFile(t)
+	defer cleanup()
+
+	// numbers given by the old counting
+	err := Migrate(context.Background(), db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+INSERT INTO clients (login, password, name, phone) VALUES ('client', '', '', '');
+INSERT INTO bank_accounts (client_id, account_number, balance) VALUES (1, 0, 0), (1, 4, 0);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddBankAccountToClient(defaultAdminId, 1, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numbers, err := GetAllAccountNumbersByClientId(1, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(numbers) != "[0 4 5]" {
+		t.Errorf("want: [0 4 5], got: %v", numbers)
+	}
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency. Amounts and balances are integers in
+// its minor units: 1050 in TJS is 10.50 somoni.
+type Currency struct {
+	Code       string
+	MinorUnits int
+}
+
+// DefaultCurrency is the currency of accounts opened without one and of the
+// accounts made before currencies existed.
+const DefaultCurrency = "TJS"
+
+// Currencies are the ones accounts can be opened in. Add to it before the
+// stores are used.
+var Currencies = map[string]Currency{
+	"TJS": {Code: "TJS", MinorUnits: 2},
+	"USD": {Code: "USD", MinorUnits: 2},
+	"EUR": {Code: "EUR", MinorUnits: 2},
+	"RUB": {Code: "RUB", MinorUnits: 2},
+	"CNY": {Code: "CNY", MinorUnits: 2},
+	"KZT": {Code: "KZT", MinorUnits: 2},
+	"UZS": {Code: "UZS", MinorUnits: 2},
+	"JPY": {Code: "JPY", MinorUnits: 0},
+	"KWD": {Code: "KWD", MinorUnits: 3},
+}
+
+// LookupCurrency accepts the code in any case.
+func LookupCurrency(code string) (Currency, error) {
+	currency, ok := Currencies[strings.ToUpper(strings.TrimSpace(code))]
+	if !ok {
+		return Currency{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, code)
+	}
+	return currency, nil
+}
+
+// minorUnitsOf is only called with codes stored by the bank, which are known.
+func minorUnitsOf(code string) int {
+	return Currencies[code].MinorUnits
+}
+
+func (receiver *Store) setAccountCurrency(ctx context.Context,
+	account LedgerAccount, accountId int64, currency string) error {
+
+	_, err := receiver.conn().ExecContext(ctx, insertAccountCurrencySQL,
+		sql.Named("account_type", string(account)),
+		sql.Named("account_id", accountId),
+		sql.Named("currency", currency),
+	)
+	if err != nil {
+		return queryError(insertAccountCurrencySQL, err)
+	}
+	return nil
+}
+
+func (receiver *Store) accountCurrency(ctx context.Context,
+	account LedgerAccount, accountId int64) (string, error) {
+
+	var currency string
+	err := receiver.conn().QueryRowContext(ctx, getAccountCurrencySQL,
+		sql.Named("account_type", string(account)),
+		sql.Named("account_id", accountId),
+	).Scan(&currency)
+	if err == sql.ErrNoRows {
+		return DefaultCurrency, nil
+	}
+	if err != nil {
+		return "", queryError(getAccountCurrencySQL, err)
+	}
+	return currency, nil
+}
+
+// conversionPostings are the legs of moving the amount of a conversion out
+// of the sender and its converted amount into the receiver. Accounts in one
+// currency need no exchange legs.
+func conversionPostings(conversion Conversion, sender, recipient Posting) []Posting {
+	sender.Amount, recipient.Amount = -conversion.Amount, conversion.ConvertedAmount
+	if conversion.From == conversion.To {
+		return []Posting{sender, recipient}
+	}
+	return []Posting{
+		sender,
+		{Account: LedgerExchange, Amount: conversion.Amount},
+		{Account: LedgerExchange, Amount: -conversion.ConvertedAmount},
+		recipient,
+	}
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_currencyAccounts(t *testing.T) {
+	runOnEveryBank(t, func(t *testing.T, bank Bank) {
+		ctx := context.Background()
+		for _, login := range []string{"sender", "receiver"} {
+			err := bank.AddClient(ctx, defaultAdminId, Client{Login: login})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := bank.AddBankAccountToClient(ctx, defaultAdminId, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = bank.AddBankAccountToClientInCurrency(ctx, defaultAdminId, 2, "usd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = bank.AddBankAccountToClientInCurrency(ctx, defaultAdminId, 2, "XXX")
+		if !errors.Is(err, ErrUnknownCurrency) {
+			t.Errorf("want: %v, got: %v", ErrUnknownCurrency, err)
+		}
+		_, err = bank.ReplenishBankAccount(ctx, defaultAdminId, 1, 0, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = bank.TransferToClient(ctx, MoneyTransfer{Amount: 550, SenderId: 1, ReceiverId: 2})
+		var accountErr *AccountError
+		if !errors.As(err, &accountErr) || !errors.Is(err, ErrCurrencyMismatch) || accountErr.OwnerId != 2 {
+			t.Errorf("want: %v of the receiver, got: %v", ErrCurrencyMismatch, err)
+		}
+
+		accounts, err := bank.BankAccountsList(ctx, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []BankAccount{{UserId: 2, Balance: 0, Currency: "USD", MinorUnits: 2}}
+		if !reflect.DeepEqual(accounts, want) {
+			t.Errorf("want: %v, got: %v", want, accounts)
+		}
+		accounts, err = bank.BankAccountsList(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = []BankAccount{{UserId: 1, Balance: 1000, Currency: "TJS", MinorUnits: 2}}
+		if !reflect.DeepEqual(accounts, want) {
+			t.Errorf("want: %v, got: %v", want, accounts)
+		}
+
+		serviceNumber, err := bank.AddService(ctx, defaultAdminId, Service{Name: "roaming"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = bank.AddBankAccountToServiceInCurrency(ctx, defaultAdminId, 1, "EUR")
+		if err != nil {
+			t.Fatal(err)
+		}
+		eurNumber := ServiceNumber{ServiceId: 1, AccountNumber: 1}.String()
+		err = bank.PayForService(ctx, eurNumber, 100, 1, 0)
+		if !errors.Is(err, ErrCurrencyMismatch) {
+			t.Errorf("want: %v, got: %v", ErrCurrencyMismatch, err)
+		}
+		err = bank.PayForService(ctx, serviceNumber, 100, 1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func Test_currencyConversionLedger(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := createDBinFile(t)
+	defer cleanup()
+	store := NewStore(db)
+	err := store.Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, login := range []string{"sender", "receiver"} {
+		err = store.AddClient(ctx, defaultAdminId, Client{Login: login})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.AddBankAccountToClient(ctx, defaultAdminId, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddBankAccountToClientInCurrency(ctx, defaultAdminId, 2, "JPY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.ReplenishBankAccount(ctx, defaultAdminId, 1, 0, 1000)
+	if err == nil {
+		err = store.UploadExchangeRates(ctx, defaultAdminId, []ExchangeRate{{Base: "TJS", Quote: "JPY", Rate: 14_000_000}})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.TransferWithExchange(ctx, MoneyTransfer{Amount: 1000, SenderId: 1, ReceiverId: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := store.VerifyLedger(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Ok() {
+		t.Errorf("want consistent ledger, got: %+v", report)
+	}
+	page, err := store.AccountHistory(ctx, 2, 0, HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCounterparty := Counterparty{Account: LedgerClient, OwnerId: 1}
+	if len(page.Operations) != 1 || page.Operations[0].Amount != 140 ||
+		page.Operations[0].Counterparty != wantCounterparty {
+
+		t.Errorf("want one operation of 140 from the sender, got: %+v", page.Operations)
+	}
+}
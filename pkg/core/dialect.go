@@ -0,0 +1,278 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Dialect is what differs between the databases a Store can run on. The
+// queries in sql.go are written for SQLite and rewritten by the dialect
+// where the other database disagrees.
+type Dialect interface {
+	Name() string
+
+	conn(conn dbtx) dbtx
+	ddl(statement string) string
+	insertReturningId(ctx context.Context, conn dbtx, query string, args ...interface{}) (int64, error)
+	// syncIdSequence must follow inserts that set ids explicitly.
+	syncIdSequence(ctx context.Context, conn dbtx, table string) error
+	// isUniqueViolation and isForeignKeyViolation read the error codes of the
+	// driver anywhere in the chain of err.
+	isUniqueViolation(err error) bool
+	isForeignKeyViolation(err error) bool
+}
+
+var (
+	SQLite   Dialect = sqliteDialect{}
+	Postgres Dialect = postgresDialect{}
+)
+
+// detectDialect tells the database by its driver, so callers of NewStore
+// need no changes.
+func detectDialect(db *sql.DB) Dialect {
+	driver := reflect.Indirect(reflect.ValueOf(db.Driver())).Type().PkgPath()
+	if strings.HasSuffix(driver, "/pq") || strings.Contains(driver, "/pgx") {
+		return Postgres
+	}
+	return SQLite
+}
+
+//---------------SQLite
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string {
+	return "sqlite"
+}
+
+func (sqliteDialect) conn(conn dbtx) dbtx {
+	return conn
+}
+
+func (sqliteDialect) ddl(statement string) string {
+	return statement
+}
+
+func (sqliteDialect) insertReturningId(ctx context.Context, conn dbtx,
+	query string, args ...interface{}) (int64, error) {
+
+	result, err := conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, queryError(query, err)
+	}
+	return result.LastInsertId()
+}
+
+func (sqliteDialect) syncIdSequence(context.Context, dbtx, string) error {
+	return nil
+}
+
+// The extended result codes of SQLite constraint errors.
+const (
+	sqliteConstraintForeignKey = 787
+	sqliteConstraintPrimaryKey = 1555
+	sqliteConstraintUnique     = 2067
+)
+
+func (sqliteDialect) isUniqueViolation(err error) bool {
+	code := sqliteExtendedCode(err)
+	return code == sqliteConstraintUnique || code == sqliteConstraintPrimaryKey
+}
+
+func (sqliteDialect) isForeignKeyViolation(err error) bool {
+	return sqliteExtendedCode(err) == sqliteConstraintForeignKey
+}
+
+// sqliteExtendedCode finds the ExtendedCode of a go-sqlite3 Error by
+// reflection, core doesn't import the driver.
+func sqliteExtendedCode(err error) int64 {
+	for ; err != nil; err = errors.Unwrap(err) {
+		value := reflect.Indirect(reflect.ValueOf(err))
+		if value.Kind() != reflect.Struct || !strings.HasSuffix(value.Type().PkgPath(), "/go-sqlite3") {
+			continue
+		}
+		code := value.FieldByName("ExtendedCode")
+		if code.IsValid() && code.Kind() == reflect.Int {
+			return code.Int()
+		}
+	}
+	return 0
+}
+
+//---------------Postgres
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string {
+	return "postgres"
+}
+
+func (postgresDialect) conn(conn dbtx) dbtx {
+	return postgresConn{conn: conn}
+}
+
+var (
+	autoincrementPattern = regexp.MustCompile(`INTEGER\s+PRIMARY\s+KEY\s+AUTOINCREMENT`)
+	integerPattern       = regexp.MustCompile(`\bINTEGER\b`)
+)
+
+// postgresDDL holds the statements that can't be rewritten word by word.
+var postgresDDL = map[string]string{
+	journalImmutableDDL:      postgresJournalImmutableDDL,
+	dropInitialSchemaSQL:     postgresDropInitialSchemaSQL,
+	accountsIntegrityDDL:     postgresAccountsIntegrityDDL,
+	dropAccountsIntegritySQL: postgresDropAccountsIntegritySQL,
+}
+
+// ddl widens integers to 64 bits, as they are in SQLite, and turns
+// autoincrement keys into serial ones.
+func (postgresDialect) ddl(statement string) string {
+	if translated, ok := postgresDDL[statement]; ok {
+		return translated
+	}
+	statement = autoincrementPattern.ReplaceAllString(statement, "BIGSERIAL PRIMARY KEY")
+	return integerPattern.ReplaceAllString(statement, "BIGINT")
+}
+
+func (postgresDialect) insertReturningId(ctx context.Context, conn dbtx,
+	query string, args ...interface{}) (id int64, err error) {
+
+	returning := strings.TrimSuffix(strings.TrimSpace(query), ";") + "\nRETURNING id;"
+	err = conn.QueryRowContext(ctx, returning, args...).Scan(&id)
+	if err != nil {
+		return 0, queryError(returning, err)
+	}
+	return id, nil
+}
+
+func (postgresDialect) syncIdSequence(ctx context.Context, conn dbtx, table string) error {
+	query := fmt.Sprintf(syncIdSequencePostgresSQL, table)
+	_, err := conn.ExecContext(ctx, query)
+	if err != nil {
+		return queryError(query, err)
+	}
+	return nil
+}
+
+// The SQLSTATE codes of Postgres constraint errors.
+const (
+	postgresForeignKeyViolation = "23503"
+	postgresUniqueViolation     = "23505"
+)
+
+func (postgresDialect) isUniqueViolation(err error) bool {
+	return postgresSQLState(err) == postgresUniqueViolation
+}
+
+func (postgresDialect) isForeignKeyViolation(err error) bool {
+	return postgresSQLState(err) == postgresForeignKeyViolation
+}
+
+// postgresSQLState works with the errors of lib/pq and pgx, both tell their
+// SQLSTATE.
+func postgresSQLState(err error) string {
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState()
+	}
+	return ""
+}
+
+// postgresConn passes queries on with their parameters rebound to $n.
+type postgresConn struct {
+	conn dbtx
+}
+
+func (receiver postgresConn) ExecContext(ctx context.Context,
+	query string, args ...interface{}) (sql.Result, error) {
+
+	query, args = rebindPostgres(query, args)
+	return receiver.conn.ExecContext(ctx, query, args...)
+}
+
+func (receiver postgresConn) QueryContext(ctx context.Context,
+	query string, args ...interface{}) (*sql.Rows, error) {
+
+	query, args = rebindPostgres(query, args)
+	return receiver.conn.QueryContext(ctx, query, args...)
+}
+
+func (receiver postgresConn) QueryRowContext(ctx context.Context,
+	query string, args ...interface{}) *sql.Row {
+
+	query, args = rebindPostgres(query, args)
+	return receiver.conn.QueryRowContext(ctx, query, args...)
+}
+
+// rebindPostgres turns ? and :name parameters into $n ones, outside string
+// literals, numbered in the order they appear. A ? takes the next argument
+// that isn't named. A name used twice gets one number; a name without an
+// argument is bound to NULL, as SQLite does.
+func rebindPostgres(query string, args []interface{}) (string, []interface{}) {
+	named := make(map[string]interface{})
+	var unnamed []interface{}
+	for _, arg := range args {
+		if namedArg, ok := arg.(sql.NamedArg); ok {
+			named[namedArg.Name] = namedArg.Value
+		} else {
+			unnamed = append(unnamed, arg)
+		}
+	}
+
+	var rebound strings.Builder
+	var positional []interface{}
+	numbers := make(map[string]int)
+	inString := false
+	for i := 0; i < len(query); i++ {
+		char := query[i]
+		switch {
+		case char == '\'':
+			inString = !inString
+		case inString:
+		case char == '?':
+			var arg interface{}
+			if len(unnamed) != 0 {
+				arg, unnamed = unnamed[0], unnamed[1:]
+			}
+			positional = append(positional, arg)
+			rebound.WriteString("$" + strconv.Itoa(len(positional)))
+			continue
+		case char == ':' && i+1 < len(query) && isParameterStart(query[i+1]) &&
+			(i == 0 || query[i-1] != ':'):
+			end := i + 1
+			for end < len(query) && isParameterPart(query[end]) {
+				end++
+			}
+			name := query[i+1 : end]
+			number, ok := numbers[name]
+			if !ok {
+				number = len(positional) + 1
+				numbers[name] = number
+				positional = append(positional, named[name])
+			}
+			rebound.WriteString("$" + strconv.Itoa(number))
+			i = end - 1
+			continue
+		}
+		rebound.WriteByte(char)
+	}
+
+	if len(numbers) == 0 {
+		return rebound.String(), args
+	}
+	return rebound.String(), positional
+}
+
+func isParameterStart(char byte) bool {
+	return char == '_' || 'a' <= char && char <= 'z' || 'A' <= char && char <= 'Z'
+}
+
+func isParameterPart(char byte) bool {
+	return isParameterStart(char) || '0' <= char && char <= '9'
+}
@@ -0,0 +1,488 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// postgresDSNEnv points the tests to a Postgres server. Without it they
+// start their own instance when initdb and pg_ctl are on PATH.
+const postgresDSNEnv = "IB_CORE_POSTGRES_DSN"
+
+var localPostgres struct {
+	once    sync.Once
+	dsn     string
+	dir     string
+	skipped string
+}
+
+func startLocalPostgres() (dsn, dir string, err error) {
+	if dsn = os.Getenv(postgresDSNEnv); dsn != "" {
+		return dsn, "", nil
+	}
+	for _, binary := range []string{"initdb", "pg_ctl"} {
+		if _, err = exec.LookPath(binary); err != nil {
+			return "", "", fmt.Errorf("set %s or put %s on PATH", postgresDSNEnv, binary)
+		}
+	}
+	if current, err := user.Current(); err == nil && current.Uid == "0" {
+		return "", "", fmt.Errorf("postgres doesn't run as root, set %s", postgresDSNEnv)
+	}
+
+	dir, err = ioutil.TempDir("", "ib-core-postgres")
+	if err != nil {
+		return "", "", err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", dir, err
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	data := filepath.Join(dir, "data")
+	output, err := exec.Command("initdb", "-D", data, "-U", "postgres", "--auth=trust").CombinedOutput()
+	if err != nil {
+		return "", dir, fmt.Errorf("initdb: %v: %s", err, output)
+	}
+	options := fmt.Sprintf("-p %d -k %s -c listen_addresses=''", port, dir)
+	output, err = exec.Command("pg_ctl", "-D", data, "-o", options, "-w", "start").CombinedOutput()
+	if err != nil {
+		return "", dir, fmt.Errorf("pg_ctl: %v: %s", err, output)
+	}
+	dsn = fmt.Sprintf("host=%s port=%d user=postgres dbname=postgres sslmode=disable", dir, port)
+	return dsn, dir, nil
+}
+
+func stopLocalPostgres() {
+	if localPostgres.dir == "" {
+		return
+	}
+	data := filepath.Join(localPostgres.dir, "data")
+	_ = exec.Command("pg_ctl", "-D", data, "-m", "fast", "-w", "stop").Run()
+	_ = os.RemoveAll(localPostgres.dir)
+}
+
+// createPostgresDB gives the test an empty database of its own.
+func createPostgresDB(t *testing.T) (*sql.DB, func()) {
+	localPostgres.once.Do(func() {
+		dsn, dir, err := startLocalPostgres()
+		localPostgres.dsn, localPostgres.dir = dsn, dir
+		if err != nil {
+			localPostgres.skipped = err.Error()
+		}
+	})
+	if localPostgres.skipped != "" {
+		t.Skip(localPostgres.skipped)
+	}
+
+	server, err := sql.Open("postgres", localPostgres.dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := strings.ToLower(strings.NewReplacer("/", "_", "#", "_").Replace("ib_core_" + t.Name()))
+	_, _ = server.Exec("DROP DATABASE IF EXISTS " + name)
+	_, err = server.Exec("CREATE DATABASE " + name)
+	if err != nil {
+		_ = server.Close()
+		t.Fatal(err)
+	}
+
+	dsn := localPostgres.dsn + " dbname=" + name
+	if strings.Contains(localPostgres.dsn, "://") {
+		dsn = postgresURLWithDatabase(t, localPostgres.dsn, name)
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, func() {
+		_ = db.Close()
+		_, _ = server.Exec("DROP DATABASE IF EXISTS " + name)
+		_ = server.Close()
+	}
+}
+
+func postgresURLWithDatabase(t *testing.T, dsn, name string) string {
+	query := ""
+	if index := strings.Index(dsn, "?"); index >= 0 {
+		dsn, query = dsn[:index], dsn[index:]
+	}
+	slash := strings.LastIndex(dsn, "/")
+	if slash < strings.Index(dsn, "://")+3 {
+		t.Fatalf("can't put a database name into %s", postgresDSNEnv)
+	}
+	return dsn[:slash+1] + name + query
+}
+
+// runOnEveryDialect runs the test once on SQLite and once on Postgres.
+func runOnEveryDialect(t *testing.T, test func(t *testing.T, store *Store)) {
+	t.Run("sqlite", func(t *testing.T) {
+		db, cleanup := createDBinFile(t)
+		defer cleanup()
+		test(t, NewStore(db))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		db, cleanup := createPostgresDB(t)
+		defer cleanup()
+		test(t, NewStore(db))
+	})
+}
+
+func Test_rebindPostgres(t *testing.T) {
+	tests := []struct {
+		query     string
+		args      []interface{}
+		wantQuery string
+		wantArgs  []interface{}
+	}{
+		{
+			query:     "SELECT id FROM clients WHERE login = ? AND phone = ?;",
+			args:      []interface{}{"login", "123"},
+			wantQuery: "SELECT id FROM clients WHERE login = $1 AND phone = $2;",
+			wantArgs:  []interface{}{"login", "123"},
+		},
+		{
+			query:     "SELECT :b, :a, :b, ':a', x::TEXT",
+			args:      []interface{}{sql.Named("a", 1), sql.Named("b", 2)},
+			wantQuery: "SELECT $1, $2, $1, ':a', x::TEXT",
+			wantArgs:  []interface{}{2, 1},
+		},
+		{
+			query:     "INSERT INTO atms VALUES (:id, :address)",
+			args:      []interface{}{sql.Named("id", 1), sql.Named("name", "street")},
+			wantQuery: "INSERT INTO atms VALUES ($1, $2)",
+			wantArgs:  []interface{}{1, nil},
+		},
+		{
+			query:     "SELECT 'it''s ?' WHERE a = ?",
+			args:      []interface{}{1},
+			wantQuery: "SELECT 'it''s ?' WHERE a = $1",
+			wantArgs:  []interface{}{1},
+		},
+		{
+			query:     "UPDATE cards SET status = :status WHERE id = ? AND status = :status AND pan = ?",
+			args:      []interface{}{7, sql.Named("status", "blocked"), "4150"},
+			wantQuery: "UPDATE cards SET status = $1 WHERE id = $2 AND status = $1 AND pan = $3",
+			wantArgs:  []interface{}{"blocked", 7, "4150"},
+		},
+	}
+	for _, test := range tests {
+		query, args := rebindPostgres(test.query, test.args)
+		if query != test.wantQuery {
+			t.Errorf("want: %s, got: %s", test.wantQuery, query)
+		}
+		if !reflect.DeepEqual(args, test.wantArgs) {
+			t.Errorf("%s: want args: %v, got: %v", test.query, test.wantArgs, args)
+		}
+	}
+}
+
+func Test_postgresDDL(t *testing.T) {
+	for _, migration := range migrations {
+		for _, statement := range append(migration.Up, migration.Down...) {
+			translated := Postgres.ddl(statement)
+			for _, sqliteOnly := range []string{"AUTOINCREMENT", "RAISE(ABORT", "INTEGER"} {
+				if strings.Contains(translated, sqliteOnly) {
+					t.Errorf("%04d: %s is left in %s", migration.Version, sqliteOnly, translated)
+				}
+			}
+		}
+	}
+	if SQLite.ddl(bankAccountsDDL) != bankAccountsDDL {
+		t.Error("want SQLite DDL unchanged")
+	}
+}
+
+func Test_detectDialect(t *testing.T) {
+	sqlite := createDBinMemory(t)
+	defer sqlite.Close()
+	if dialect := NewStore(sqlite).Dialect(); dialect != SQLite {
+		t.Errorf("want: %s, got: %s", SQLite.Name(), dialect.Name())
+	}
+
+	// opening doesn't connect, no server is needed
+	postgres, err := sql.Open("postgres", "host=localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer postgres.Close()
+	if dialect := NewStore(postgres).Dialect(); dialect != Postgres {
+		t.Errorf("want: %s, got: %s", Postgres.Name(), dialect.Name())
+	}
+}
+
+func Test_initWithoutForeignKeys(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	err = Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO bank_accounts (client_id, account_number, balance) VALUES (7, 0, 0)`)
+	if err == nil {
+		t.Error("want an error for an account of a missing client")
+	}
+}
+
+func Test_constraintViolations(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+	for _, statement := range []string{
+		`CREATE TABLE owners (id INTEGER PRIMARY KEY, login TEXT UNIQUE)`,
+		`CREATE TABLE things (owner_id INTEGER REFERENCES owners)`,
+		`INSERT INTO owners VALUES (1, 'login')`,
+	} {
+		_, err := db.Exec(statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, unique := db.Exec(`INSERT INTO owners VALUES (2, 'login')`)
+	_, primaryKey := db.Exec(`INSERT INTO owners VALUES (1, 'other')`)
+	_, foreignKey := db.Exec(`INSERT INTO things VALUES (2)`)
+	for _, test := range []struct {
+		dialect    Dialect
+		err        error
+		unique     bool
+		foreignKey bool
+	}{
+		{dialect: SQLite, err: queryError("INSERT", unique), unique: true},
+		{dialect: SQLite, err: primaryKey, unique: true},
+		{dialect: SQLite, err: foreignKey, foreignKey: true},
+		{dialect: SQLite, err: errors.New("UNIQUE constraint failed")},
+		{dialect: Postgres, err: queryError("INSERT", &pq.Error{Code: "23505"}), unique: true},
+		{dialect: Postgres, err: &pq.Error{Code: "23503"}, foreignKey: true},
+		{dialect: Postgres, err: unique},
+	} {
+		if test.dialect.isUniqueViolation(test.err) != test.unique ||
+			test.dialect.isForeignKeyViolation(test.err) != test.foreignKey {
+
+			t.Errorf("%s %v: want unique %v, foreign key %v",
+				test.dialect.Name(), test.err, test.unique, test.foreignKey)
+		}
+	}
+}
+
+func Test_storeOnEveryDialect(t *testing.T) {
+	runOnEveryDialect(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		err := store.Init(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a second Init finds the schema and the seed in place
+		err = store.Init(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.AddManager(ctx, defaultAdminId, Manager{Login: "manager", Password: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, login := range []string{"sender", "receiver"} {
+			err = store.AddClient(ctx, defaultAdminId, Client{Login: login, Password: "secret", Phone: login})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = store.AddClient(ctx, defaultAdminId, Client{Login: " Sender "})
+		if !errors.Is(err, ErrLoginTaken) {
+			t.Errorf("want: %v, got: %v", ErrLoginTaken, err)
+		}
+		senderId, err := store.GetClientIdByLogin(ctx, "SENDER")
+		if err != nil {
+			t.Fatal(err)
+		}
+		receiverId, err := store.GetClientIdByPhoneNumber(ctx, "receiver")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []int64{senderId, receiverId} {
+			err = store.AddBankAccountToClient(ctx, defaultAdminId, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		serviceNumber, err := store.AddService(ctx, defaultAdminId, Service{Name: "internet"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = store.ReplenishBankAccount(ctx, defaultAdminId, senderId, 0, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.TransferToClient(ctx, MoneyTransfer{
+			Amount:         300,
+			SenderId:       senderId,
+			ReceiverId:     receiverId,
+			IdempotencyKey: "transfer-1",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.PayForService(ctx, serviceNumber, 200, senderId, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.TransferToClient(ctx, MoneyTransfer{Amount: 1000, SenderId: senderId, ReceiverId: receiverId})
+		if !errors.Is(err, ErrInsufficientFunds) {
+			t.Errorf("want: %v, got: %v", ErrInsufficientFunds, err)
+		}
+
+		accounts, err := store.BankAccountsList(ctx, senderId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(accounts) != 1 || accounts[0].Balance != 500 {
+			t.Errorf("want balance 500, got: %v", accounts)
+		}
+		page, err := store.AccountHistory(ctx, senderId, 0, HistoryFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Operations) != 3 {
+			t.Errorf("want 3 operations, got: %v", page.Operations)
+		}
+		report, err := store.VerifyLedger(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Ok() {
+			t.Errorf("want consistent ledger, got: %v", report)
+		}
+
+		ok, err := store.LoginForManager(ctx, "admin", defaultAdminPassword, "")
+		if err != nil || !ok {
+			t.Fatalf("want admin to log in, got: %v, %v", ok, err)
+		}
+		session, err := store.LoginForClientWithSession(ctx, "receiver", "secret", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		principal, err := store.ValidateSession(ctx, session.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if principal.Id != receiverId {
+			t.Errorf("want: %v, got: %v", receiverId, principal.Id)
+		}
+		_, err = store.LoginForClient(ctx, "receiver", "wrong", "")
+		if !errors.Is(err, ErrInvalidPass) {
+			t.Errorf("want: %v, got: %v", ErrInvalidPass, err)
+		}
+
+		// the seeded admin must not leave the id sequence behind
+		var managerId int64
+		err = store.conn().QueryRowContext(ctx, getManagerIdByLoginSQL, "manager").Scan(&managerId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if managerId != defaultAdminId+1 {
+			t.Errorf("want: %d, got: %d", defaultAdminId+1, managerId)
+		}
+		err = store.GrantRole(ctx, defaultAdminId, managerId, RoleOperator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.Authorize(ctx, managerId, PermissionAccountsWrite)
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = store.Migrate(ctx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		version, err := store.SchemaVersion(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != 0 {
+			t.Errorf("want schema rolled back, got version %d", version)
+		}
+	})
+}
+
+func Test_transfersConcurrently(t *testing.T) {
+	runOnEveryDialect(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		err := store.Init(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, login := range []string{"first", "second"} {
+			err = store.AddClient(ctx, defaultAdminId, Client{Login: login})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, id := range []int64{1, 2} {
+			err = store.AddBankAccountToClient(ctx, defaultAdminId, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err = store.ReplenishBankAccount(ctx, defaultAdminId, 1, 0, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// every transfer that finds the money takes it, the rest find none
+		const transfers = 150
+		var wg sync.WaitGroup
+		errs := make(chan error, transfers)
+		for i := 0; i < transfers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- store.TransferToClient(ctx, MoneyTransfer{Amount: 1, SenderId: 1, ReceiverId: 2})
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		failed := 0
+		for err := range errs {
+			if errors.Is(err, ErrInsufficientFunds) {
+				failed++
+				continue
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		if failed != transfers-100 {
+			t.Errorf("want %d transfers without money, got: %d", transfers-100, failed)
+		}
+		assertBalance(t, 1, 0, 0, store.db)
+		assertBalance(t, 2, 0, 100, store.db)
+
+		report, err := store.VerifyLedger(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Ok() {
+			t.Errorf("want consistent ledger, got: %+v", report)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Every exported function returns these wrapped with context, check them
+// with errors.Is and the error types below with errors.As.
+var (
+	ErrInvalidPass     = errors.New("invalid login or password")
+	ErrTooManyAttempts = errors.New("too many failed login attempts")
+	ErrLoginTaken      = errors.New("login is already taken")
+
+	ErrInvalidSession = errors.New("invalid session")
+	ErrSessionExpired = errors.New("session expired")
+
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrUnknownRole      = errors.New("unknown role")
+
+	ErrClientNotFound  = errors.New("client not found")
+	ErrManagerNotFound = errors.New("manager not found")
+	ErrServiceNotFound = errors.New("service not found")
+	ErrAccountNotFound = errors.New("bank account not found")
+	ErrAtmNotFound     = errors.New("ATM not found")
+	ErrCardNotFound    = errors.New("card not found")
+
+	ErrInvalidAmount        = errors.New("amount must be positive")
+	ErrInsufficientFunds    = errors.New("insufficient funds")
+	ErrAtmLimitExceeded     = errors.New("ATM operation limit exceeded")
+	ErrNotEnoughCash        = errors.New("not enough cash in the ATM")
+	ErrCannotDispense       = errors.New("ATM can't make up the amount from its banknotes")
+	ErrInvalidServiceNumber = errors.New("invalid service number")
+	ErrInvalidAccountID     = errors.New("invalid account identifier")
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused for another request")
+	ErrUnknownCurrency      = errors.New("unknown currency")
+	ErrCurrencyMismatch     = errors.New("accounts are in different currencies")
+	ErrInvalidExchangeRate  = errors.New("invalid exchange rate")
+	ErrExchangeRateNotFound = errors.New("no exchange rate between the currencies")
+	ErrInvalidLocation      = errors.New("invalid location")
+	ErrInvalidAtm           = errors.New("invalid ATM details")
+	ErrAtmUnavailable       = errors.New("ATM is out of service")
+	ErrInvalidAtmTransition = errors.New("ATM status can't change that way")
+	ErrInvalidPin           = errors.New("invalid PIN")
+	ErrCardNotActive        = errors.New("card is not active")
+	ErrCardNotBlocked       = errors.New("card can't be unblocked")
+
+	ErrUnbalancedTransaction = errors.New("journal transaction legs don't sum to zero")
+	ErrMalformedHash         = errors.New("malformed password hash")
+
+	ErrMigrationChecksum = errors.New("applied migration was changed")
+	ErrUnknownMigration  = errors.New("database has a migration this build doesn't know")
+)
+
+type QueryError struct { // alt + enter
+	Query string
+	Err   error
+}
+
+func (receiver *QueryError) Unwrap() error {
+	return receiver.Err
+}
+func (receiver *QueryError) Error() string {
+	return fmt.Sprintf("can't execute query %s: %s", receiver.Query, receiver.Err.Error())
+}
+func queryError(query string, err error) *QueryError {
+	return &QueryError{Query: query, Err: err}
+}
+
+// AccountError tells which account an operation failed on.
+type AccountError struct {
+	Account       LedgerAccount
+	OwnerId       int64
+	AccountNumber int64
+	Err           error
+}
+
+func (receiver *AccountError) Unwrap() error {
+	return receiver.Err
+}
+func (receiver *AccountError) Error() string {
+	return fmt.Sprintf("%s %d account %d: %s",
+		receiver.Account, receiver.OwnerId, receiver.AccountNumber, receiver.Err.Error())
+}
+func accountError(account LedgerAccount, ownerId, accountNumber int64, err error) *AccountError {
+	return &AccountError{
+		Account:       account,
+		OwnerId:       ownerId,
+		AccountNumber: accountNumber,
+		Err:           err,
+	}
+}
+
+// ThrottleError is returned the same way for known and unknown logins.
+type ThrottleError struct {
+	Until time.Time
+}
+
+func (receiver *ThrottleError) Error() string {
+	return fmt.Sprintf("%s, retry after %s",
+		ErrTooManyAttempts.Error(), receiver.Until.Format(time.RFC3339))
+}
+func (receiver *ThrottleError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
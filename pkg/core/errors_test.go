@@ -0,0 +1,142 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_domainErrors(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, login := range []string{"first", "second"} {
+		err = AddClient(defaultAdminId, Client{Login: login, Phone: login}, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = AddBankAccountToClient(defaultAdminId, 1, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serviceNumber, err := AddService(defaultAdminId, Service{Name: "taxes"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReplenishBankAccount(defaultAdminId, 1, 0, 100, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = AddClient(defaultAdminId, Client{Login: "first"}, db)
+	if !errors.Is(err, ErrLoginTaken) {
+		t.Errorf("want: %v, got: %v", ErrLoginTaken, err)
+	}
+	err = AddManager(defaultAdminId, Manager{Login: "admin"}, db)
+	if !errors.Is(err, ErrLoginTaken) {
+		t.Errorf("want: %v, got: %v", ErrLoginTaken, err)
+	}
+
+	_, err = GetClientIdByLogin("nobody", db)
+	if !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("want: %v, got: %v", ErrClientNotFound, err)
+	}
+	_, err = GetClientIdByPhoneNumber("nothing", db)
+	if !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("want: %v, got: %v", ErrClientNotFound, err)
+	}
+	err = AddBankAccountToClient(defaultAdminId, 100, db)
+	if !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("want: %v, got: %v", ErrClientNotFound, err)
+	}
+	err = AddBankAccountToService(defaultAdminId, 100, db)
+	if !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("want: %v, got: %v", ErrServiceNotFound, err)
+	}
+
+	err = TransferToClient(MoneyTransfer{Amount: 0, SenderId: 1, ReceiverId: 2}, db)
+	if !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("want: %v, got: %v", ErrInvalidAmount, err)
+	}
+
+	err = TransferToClient(MoneyTransfer{Amount: 1000, SenderId: 1, ReceiverId: 2}, db)
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("want: %v, got: %v", ErrInsufficientFunds, err)
+	}
+	var accountErr *AccountError
+	if !errors.As(err, &accountErr) {
+		t.Fatalf("want *AccountError, got: %T", err)
+	}
+	accountWant := AccountError{Account: LedgerClient, OwnerId: 1, AccountNumber: 0,
+		Err: ErrInsufficientFunds}
+	if *accountErr != accountWant {
+		t.Errorf("want: %+v, got: %+v", accountWant, *accountErr)
+	}
+
+	err = TransferToClient(MoneyTransfer{Amount: 10, SenderId: 1, ReceiverId: 2}, db)
+	if !errors.As(err, &accountErr) || !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("want: %v, got: %v", ErrAccountNotFound, err)
+	} else if accountErr.OwnerId != 2 {
+		t.Errorf("want receiver account in error, got: %+v", *accountErr)
+	}
+	err = TransferToClient(MoneyTransfer{Amount: 10, SenderId: 2, ReceiverId: 1}, db)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("want: %v, got: %v", ErrAccountNotFound, err)
+	}
+	_, err = ReplenishBankAccount(defaultAdminId, 2, 0, 10, db)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("want: %v, got: %v", ErrAccountNotFound, err)
+	}
+	_, err = AccountHistory(2, 0, HistoryFilter{}, db)
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("want: %v, got: %v", ErrAccountNotFound, err)
+	}
+
+	missing, err := ParseServiceNumber(serviceNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing.ServiceId++
+	err = PayForService(missing.String(), 10, 1, 0, db)
+	if !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("want: %v, got: %v", ErrServiceNotFound, err)
+	}
+	for _, number := range []string{"", "12", "abc0000", "1abcd", serviceNumber[:len(serviceNumber)-1] + "9"} {
+		err = PayForService(number, 10, 1, 0, db)
+		if !errors.Is(err, ErrInvalidServiceNumber) {
+			t.Errorf("%q: want: %v, got: %v", number, ErrInvalidServiceNumber, err)
+		}
+	}
+
+	err = AddATM(defaultAdminId, "address", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`DROP TABLE atm_history`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddATM(defaultAdminId, "address", db)
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) {
+		t.Errorf("want *QueryError, got: %T", err)
+	}
+
+	_, err = db.Exec(`ALTER TABLE clients RENAME TO clients_gone;
+ALTER TABLE bank_accounts RENAME TO bank_accounts_gone;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = GetAllAccountNumbersByClientId(1, db)
+	if !errors.As(err, &queryErr) {
+		t.Errorf("want *QueryError, got: %T", err)
+	}
+	err = ExportClientsToJSON(defaultAdminId, db)
+	if !errors.As(err, &queryErr) {
+		t.Errorf("want *QueryError, got: %T", err)
+	}
+}
@@ -0,0 +1,395 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateScale is the fixed point of rates: a Rate of 10_950_000 is 10.95.
+const RateScale = 1_000_000
+
+// SpreadScale is the fixed point of spreads, which are in basis points.
+const SpreadScale = 10_000
+
+// ExchangeRate is the middle rate of Quote for one major unit of Base from
+// EffectiveFrom until the next rate of the pair. The bank buys Base for
+// Rate less BuySpread and sells it for Rate plus SellSpread.
+type ExchangeRate struct {
+	Id            int64
+	Base          string
+	Quote         string
+	Rate          int64
+	BuySpread     int64
+	SellSpread    int64
+	EffectiveFrom time.Time
+}
+
+type ExchangeRatesExport struct {
+	ExchangeRates []ExchangeRate
+}
+
+// Conversion is what an exchange transfer applied. Rate and Spread are the
+// ones of the table row, AppliedRate is Rate with the spread, all in Quote
+// for one Base of that row.
+type Conversion struct {
+	TransactionId   int64
+	RateId          int64
+	From            string
+	To              string
+	Amount          int64
+	ConvertedAmount int64
+	Rate            int64
+	Spread          int64
+	AppliedRate     int64
+}
+
+func (receiver ExchangeRate) validate() (ExchangeRate, error) {
+	base, err := LookupCurrency(receiver.Base)
+	if err != nil {
+		return receiver, fmt.Errorf("%w: %v", ErrInvalidExchangeRate, err)
+	}
+	quote, err := LookupCurrency(receiver.Quote)
+	if err != nil {
+		return receiver, fmt.Errorf("%w: %v", ErrInvalidExchangeRate, err)
+	}
+	receiver.Base, receiver.Quote = base.Code, quote.Code
+	if receiver.Base == receiver.Quote {
+		return receiver, fmt.Errorf("%w: %s to itself", ErrInvalidExchangeRate, receiver.Base)
+	}
+	if receiver.Rate < 1 ||
+		receiver.BuySpread < 0 || receiver.BuySpread >= SpreadScale ||
+		receiver.SellSpread < 0 || receiver.SellSpread >= SpreadScale {
+
+		return receiver, fmt.Errorf("%w: %s/%s rate %d spreads %d %d", ErrInvalidExchangeRate,
+			receiver.Base, receiver.Quote, receiver.Rate, receiver.BuySpread, receiver.SellSpread)
+	}
+	if receiver.EffectiveFrom.IsZero() {
+		receiver.EffectiveFrom = now()
+	}
+	return receiver, nil
+}
+
+// UploadExchangeRates adds a rate table: either every rate of it is added or
+// none. Rates without EffectiveFrom take effect at once.
+func (receiver *Store) UploadExchangeRates(ctx context.Context,
+	managerId int64, rates []ExchangeRate) error {
+
+	err := receiver.Authorize(ctx, managerId, PermissionRatesWrite)
+	if err != nil {
+		return err
+	}
+	return receiver.InTx(ctx, func(tx *Store) error {
+		for _, rate := range rates {
+			err := tx.insertExchangeRate(ctx, rate)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+func (receiver *Store) insertExchangeRate(ctx context.Context, rate ExchangeRate) error {
+	rate, err := rate.validate()
+	if err != nil {
+		return err
+	}
+	_, err = receiver.conn().ExecContext(ctx, insertExchangeRateSQL,
+		sql.Named("base_currency", rate.Base),
+		sql.Named("quote_currency", rate.Quote),
+		sql.Named("rate", rate.Rate),
+		sql.Named("buy_spread", rate.BuySpread),
+		sql.Named("sell_spread", rate.SellSpread),
+		sql.Named("effective_from", rate.EffectiveFrom.Unix()),
+		sql.Named("created_at", now().Unix()),
+	)
+	if err != nil {
+		return queryError(insertExchangeRateSQL, err)
+	}
+	return nil
+}
+
+// ExchangeRates returns the rate of every pair in effect at the time.
+func (receiver *Store) ExchangeRates(ctx context.Context, at time.Time) ([]ExchangeRate, error) {
+	rows, err := receiver.conn().QueryContext(ctx, getExchangeRatesSQL, sql.Named("at", at.Unix()))
+	if err != nil {
+		return nil, queryError(getExchangeRatesSQL, err)
+	}
+	defer rows.Close()
+
+	rates := make([]ExchangeRate, 0)
+	for rows.Next() {
+		rate, err := scanExchangeRate(rows)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
+
+func scanExchangeRate(row interface{ Scan(...interface{}) error }) (ExchangeRate, error) {
+	var rate ExchangeRate
+	var effectiveFrom int64
+	err := row.Scan(&rate.Id, &rate.Base, &rate.Quote, &rate.Rate,
+		&rate.BuySpread, &rate.SellSpread, &effectiveFrom)
+	rate.EffectiveFrom = time.Unix(effectiveFrom, 0)
+	return rate, err
+}
+
+func (receiver *Store) exchangeRate(ctx context.Context,
+	base, quote string, at time.Time) (ExchangeRate, error) {
+
+	rate, err := scanExchangeRate(receiver.conn().QueryRowContext(ctx, getExchangeRateSQL,
+		sql.Named("base_currency", base),
+		sql.Named("quote_currency", quote),
+		sql.Named("at", at.Unix()),
+	))
+	if err != nil && err != sql.ErrNoRows {
+		return rate, queryError(getExchangeRateSQL, err)
+	}
+	return rate, err
+}
+
+// quote converts at the rate in effect now. A rate of the pair as given is
+// a purchase of from by the bank, one of the reverse pair a sale of to; the
+// first is preferred. Converted amounts are rounded down.
+func (receiver *Store) quote(ctx context.Context,
+	amount int64, from, to string) (Conversion, error) {
+
+	conversion := Conversion{From: from, To: to, Amount: amount}
+	fromUnits := pow10(minorUnitsOf(from))
+	toUnits := pow10(minorUnitsOf(to))
+
+	rate, err := receiver.exchangeRate(ctx, from, to, now())
+	if err == nil {
+		// amount * rate * (1 - spread) in to
+		conversion.Spread = rate.BuySpread
+		conversion.AppliedRate = rate.Rate * (SpreadScale - rate.BuySpread) / SpreadScale
+		conversion.ConvertedAmount = mulDiv(amount,
+			[]int64{conversion.AppliedRate, toUnits}, []int64{RateScale, fromUnits})
+	}
+	if err == sql.ErrNoRows {
+		rate, err = receiver.exchangeRate(ctx, to, from, now())
+		if err == sql.ErrNoRows {
+			return conversion, fmt.Errorf("%w: %s to %s", ErrExchangeRateNotFound, from, to)
+		}
+		if err != nil {
+			return conversion, err
+		}
+		// amount / (rate * (1 + spread)) in to
+		conversion.Spread = rate.SellSpread
+		conversion.AppliedRate = rate.Rate * (SpreadScale + rate.SellSpread) / SpreadScale
+		conversion.ConvertedAmount = mulDiv(amount,
+			[]int64{RateScale, toUnits}, []int64{conversion.AppliedRate, fromUnits})
+	}
+	if err != nil {
+		return conversion, err
+	}
+	conversion.RateId = rate.Id
+	conversion.Rate = rate.Rate
+	if conversion.ConvertedAmount < 1 {
+		return conversion, fmt.Errorf("%w: %d %s is less than a minor unit of %s",
+			ErrInvalidAmount, amount, from, to)
+	}
+	return conversion, nil
+}
+
+// mulDiv doesn't overflow in between, the result must fit.
+func mulDiv(value int64, multipliers, divisors []int64) int64 {
+	result := big.NewInt(value)
+	for _, multiplier := range multipliers {
+		result.Mul(result, big.NewInt(multiplier))
+	}
+	for _, divisor := range divisors {
+		result.Quo(result, big.NewInt(divisor))
+	}
+	return result.Int64()
+}
+func pow10(exponent int) int64 {
+	result := int64(1)
+	for i := 0; i < exponent; i++ {
+		result *= 10
+	}
+	return result
+}
+
+// TransferWithExchange is a transfer between client accounts in different
+// currencies at the rate in effect, which it returns.
+func (receiver *Store) TransferWithExchange(ctx context.Context,
+	transfer MoneyTransfer) (conversion Conversion, err error) {
+
+	err = receiver.InTx(ctx, func(tx *Store) error {
+		return tx.transfer(ctx, transfer, KindExchange, clientAccounts, &conversion)
+	})
+	if err == errIdempotencyKeyRace && receiver.tx == nil {
+		// a concurrent call with the same key committed first
+		err = receiver.replayIdempotencyKey(ctx, transfer, KindExchange)
+		if err != nil {
+			return Conversion{}, err
+		}
+		err = receiver.conversionByIdempotencyKey(ctx, transfer.IdempotencyKey, &conversion)
+	}
+	if err != nil {
+		return Conversion{}, err
+	}
+	return conversion, nil
+}
+
+func (receiver *Store) saveConversion(ctx context.Context, conversion Conversion) error {
+	_, err := receiver.conn().ExecContext(ctx, insertFxConversionSQL,
+		sql.Named("transaction_id", conversion.TransactionId),
+		sql.Named("rate_id", conversion.RateId),
+		sql.Named("from_currency", conversion.From),
+		sql.Named("to_currency", conversion.To),
+		sql.Named("amount", conversion.Amount),
+		sql.Named("converted_amount", conversion.ConvertedAmount),
+		sql.Named("rate", conversion.Rate),
+		sql.Named("spread", conversion.Spread),
+		sql.Named("applied_rate", conversion.AppliedRate),
+	)
+	if err != nil {
+		return queryError(insertFxConversionSQL, err)
+	}
+	return nil
+}
+
+// ConversionOf returns the conversion of an exchange transaction.
+func (receiver *Store) ConversionOf(ctx context.Context,
+	transactionId int64) (conversion Conversion, err error) {
+
+	err = receiver.scanConversion(ctx, getFxConversionSQL, transactionId, &conversion)
+	return conversion, err
+}
+func (receiver *Store) conversionByIdempotencyKey(ctx context.Context,
+	key string, conversion *Conversion) error {
+
+	return receiver.scanConversion(ctx, getFxConversionByIdempotencyKeySQL, key, conversion)
+}
+func (receiver *Store) scanConversion(ctx context.Context,
+	query string, arg interface{}, conversion *Conversion) error {
+
+	err := receiver.conn().QueryRowContext(ctx, query, arg).Scan(
+		&conversion.TransactionId, &conversion.RateId, &conversion.From, &conversion.To,
+		&conversion.Amount, &conversion.ConvertedAmount, &conversion.Rate,
+		&conversion.Spread, &conversion.AppliedRate)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: no conversion for %v", ErrExchangeRateNotFound, arg)
+	}
+	if err != nil {
+		return queryError(query, err)
+	}
+	return nil
+}
+
+//Import
+
+// ImportExchangeRatesFromJSON reads an ExchangeRatesExport,
+// ImportExchangeRatesFromCSV rows of
+// base,quote,rate,buy_spread,sell_spread,effective_from after a header, with
+// a decimal rate and an RFC 3339 time. Both add the file as one rate table.
+func (receiver *Store) ImportExchangeRatesFromJSON(ctx context.Context, managerId int64) error {
+	err := receiver.Authorize(ctx, managerId, PermissionRatesWrite)
+	if err != nil {
+		return err
+	}
+	return receiver.InTx(ctx, func(tx *Store) error {
+		return tx.importFromFile(
+			ctx,
+			"exchange-rates.json",
+			func(data []byte) ([]interface{}, error) {
+				return mapBytesToExchangeRates(data, json.Unmarshal)
+			},
+			insertExchangeRateToDB,
+		)
+	})
+}
+func (receiver *Store) ImportExchangeRatesFromCSV(ctx context.Context, managerId int64) error {
+	err := receiver.Authorize(ctx, managerId, PermissionRatesWrite)
+	if err != nil {
+		return err
+	}
+	return receiver.InTx(ctx, func(tx *Store) error {
+		return tx.importFromFile(
+			ctx,
+			"exchange-rates.csv",
+			mapCSVToExchangeRates,
+			insertExchangeRateToDB,
+		)
+	})
+}
+
+func mapBytesToExchangeRates(data []byte,
+	unmarshal func([]byte, interface{}) error,
+) ([]interface{}, error) {
+	ratesExport := ExchangeRatesExport{}
+	err := unmarshal(data, &ratesExport)
+	if err != nil {
+		return nil, err
+	}
+	ifaces := make([]interface{}, len(ratesExport.ExchangeRates))
+	for index := range ifaces {
+		ifaces[index] = ratesExport.ExchangeRates[index]
+	}
+	return ifaces, nil
+}
+func mapCSVToExchangeRates(data []byte) ([]interface{}, error) {
+	reader := csv.NewReader(strings.NewReader(string(data)))
+	reader.FieldsPerRecord = 6
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	ifaces := make([]interface{}, 0, len(records))
+	for line, record := range records {
+		if line == 0 {
+			continue
+		}
+		rate := ExchangeRate{Base: record[0], Quote: record[1]}
+		rate.Rate, err = parseRate(record[2])
+		if err == nil {
+			rate.BuySpread, err = strconv.ParseInt(record[3], 10, 64)
+		}
+		if err == nil {
+			rate.SellSpread, err = strconv.ParseInt(record[4], 10, 64)
+		}
+		if err == nil && record[5] != "" {
+			rate.EffectiveFrom, err = time.Parse(time.RFC3339, record[5])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidExchangeRate, line+1, err)
+		}
+		ifaces = append(ifaces, rate)
+	}
+	return ifaces, nil
+}
+func insertExchangeRateToDB(ctx context.Context, iface interface{}, store *Store) error {
+	return store.insertExchangeRate(ctx, iface.(ExchangeRate))
+}
+
+// parseRate reads a decimal like 10.95 into RateScale.
+func parseRate(rate string) (int64, error) {
+	whole, fraction := rate, ""
+	point := strings.IndexByte(rate, '.')
+	if point >= 0 {
+		whole, fraction = rate[:point], rate[point+1:]
+	}
+	digits := len(strconv.Itoa(RateScale)) - 1
+	if whole == "" || len(fraction) > digits || !isDigits(whole) || !isDigits(fraction) {
+		return 0, fmt.Errorf("%w: rate %q", ErrInvalidExchangeRate, rate)
+	}
+	fraction += strings.Repeat("0", digits-len(fraction))
+	scaled, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: rate %q", ErrInvalidExchangeRate, rate)
+	}
+	return scaled, nil
+}
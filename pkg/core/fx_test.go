@@ -0,0 +1,272 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func createExchangeStore(t *testing.T) (*Store, func()) {
+	db, cleanup := createDBinFile(t)
+	store := NewStore(db)
+	ctx := context.Background()
+	err := store.Init(ctx)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	for _, login := range []string{"somoni", "dollar"} {
+		err = store.AddClient(ctx, defaultAdminId, Client{Login: login})
+		if err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+	err = store.AddBankAccountToClient(ctx, defaultAdminId, 1)
+	if err == nil {
+		err = store.AddBankAccountToClientInCurrency(ctx, defaultAdminId, 2, "USD")
+	}
+	if err == nil {
+		_, err = store.ReplenishBankAccount(ctx, defaultAdminId, 1, 0, 20000)
+	}
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return store, cleanup
+}
+
+func Test_transferWithExchange(t *testing.T) {
+	ctx := context.Background()
+	store, cleanup := createExchangeStore(t)
+	defer cleanup()
+	current := time.Now().Truncate(time.Second)
+	defer func() { now = time.Now }()
+	now = func() time.Time { return current }
+
+	toDollars := MoneyTransfer{Amount: 10950, SenderId: 1, ReceiverId: 2}
+	_, err := store.TransferWithExchange(ctx, toDollars)
+	if !errors.Is(err, ErrExchangeRateNotFound) {
+		t.Errorf("want: %v, got: %v", ErrExchangeRateNotFound, err)
+	}
+
+	err = store.UploadExchangeRates(ctx, defaultAdminId, []ExchangeRate{
+		{Base: "usd", Quote: "TJS", Rate: 10_950_000, BuySpread: 100, SellSpread: 200,
+			EffectiveFrom: current.Add(-time.Hour)},
+		{Base: "USD", Quote: "TJS", Rate: 20_000_000, EffectiveFrom: current.Add(time.Hour)},
+		{Base: "TJS", Quote: "TJS", Rate: 1_000_000},
+	})
+	if !errors.Is(err, ErrInvalidExchangeRate) {
+		t.Errorf("want: %v, got: %v", ErrInvalidExchangeRate, err)
+	}
+	rates, err := store.ExchangeRates(ctx, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 0 {
+		t.Errorf("want no rates of a rejected table, got: %v", rates)
+	}
+	err = store.UploadExchangeRates(ctx, defaultAdminId, []ExchangeRate{
+		{Base: "usd", Quote: "TJS", Rate: 10_950_000, BuySpread: 100, SellSpread: 200,
+			EffectiveFrom: current.Add(-time.Hour)},
+		{Base: "USD", Quote: "TJS", Rate: 20_000_000, EffectiveFrom: current.Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the bank sells dollars at 10.95 + 2% = 11.169
+	conversion, err := store.TransferWithExchange(ctx, toDollars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Conversion{
+		TransactionId:   conversion.TransactionId,
+		RateId:          1,
+		From:            "TJS",
+		To:              "USD",
+		Amount:          10950,
+		ConvertedAmount: 980,
+		Rate:            10_950_000,
+		Spread:          200,
+		AppliedRate:     11_169_000,
+	}
+	if conversion != want {
+		t.Errorf("want: %+v, got: %+v", want, conversion)
+	}
+	recorded, err := store.ConversionOf(ctx, conversion.TransactionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded != want {
+		t.Errorf("want recorded: %+v, got: %+v", want, recorded)
+	}
+
+	// and buys them at 10.95 - 1% = 10.8405
+	toSomoni := MoneyTransfer{Amount: 500, SenderId: 2, ReceiverId: 1, IdempotencyKey: "exchange-1"}
+	conversion, err = store.TransferWithExchange(ctx, toSomoni)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conversion.ConvertedAmount != 5420 || conversion.AppliedRate != 10_840_500 {
+		t.Errorf("want 5420 at 10840500, got: %+v", conversion)
+	}
+	current = current.Add(2 * time.Hour)
+	replayed, err := store.TransferWithExchange(ctx, toSomoni)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != conversion {
+		t.Errorf("want the first conversion: %+v, got: %+v", conversion, replayed)
+	}
+
+	for id, balance := range map[int64]int64{1: 20000 - 10950 + 5420, 2: 980 - 500} {
+		accounts, err := store.BankAccountsList(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accounts[0].Balance != balance {
+			t.Errorf("client %d: want: %d, got: %d", id, balance, accounts[0].Balance)
+		}
+	}
+	report, err := store.VerifyLedger(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Ok() {
+		t.Errorf("want consistent ledger, got: %+v", report)
+	}
+}
+
+func Test_transferAcrossCurrencies(t *testing.T) {
+	ctx := context.Background()
+	store, cleanup := createExchangeStore(t)
+	defer cleanup()
+	_, err := store.AddService(ctx, defaultAdminId, Service{Name: "roaming"})
+	if err == nil {
+		err = store.AddBankAccountToServiceInCurrency(ctx, defaultAdminId, 1, "USD")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	senderID, err := store.AccountIDOf(ctx, LedgerClient, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiverID, err := store.AccountIDOf(ctx, LedgerClient, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serviceNumber := ServiceNumber{ServiceId: 1, AccountNumber: 1}.String()
+
+	// only TransferWithExchange converts, even with a rate the others don't
+	transfers := map[string]func() error{
+		"client": func() error {
+			return store.TransferToClient(ctx, MoneyTransfer{Amount: 5475, SenderId: 1, ReceiverId: 2})
+		},
+		"service": func() error {
+			return store.PayForService(ctx, serviceNumber, 5475, 1, 0)
+		},
+		"account id": func() error {
+			return store.TransferByAccountID(ctx, AccountTransfer{
+				Amount: 5475, SenderAccountID: senderID, ReceiverAccountID: receiverID,
+			})
+		},
+	}
+	err = store.UploadExchangeRates(ctx, defaultAdminId, []ExchangeRate{
+		{Base: "USD", Quote: "TJS", Rate: 10_950_000, SellSpread: 200},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, transfer := range transfers {
+		err = transfer()
+		if !errors.Is(err, ErrCurrencyMismatch) {
+			t.Errorf("%s: want: %v, got: %v", name, ErrCurrencyMismatch, err)
+		}
+	}
+	_, err = store.TransferWithExchange(ctx, MoneyTransfer{Amount: 5475, SenderId: 1, ReceiverId: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		account LedgerAccount
+		ownerId int64
+		number  int64
+		want    int64
+	}{
+		{account: LedgerClient, ownerId: 1, want: 20000 - 5475},
+		{account: LedgerClient, ownerId: 2, want: 490},
+		{account: LedgerService, ownerId: 1, number: 1, want: 0},
+	} {
+		identifier, err := store.AccountIDOf(ctx, test.account, test.ownerId, test.number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record, err := store.FindAccountByID(ctx, identifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.Balance != test.want {
+			t.Errorf("%+v: want: %d, got: %d", test, test.want, record.Balance)
+		}
+	}
+	report, err := store.VerifyLedger(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Ok() {
+		t.Errorf("want consistent ledger, got: %+v", report)
+	}
+}
+
+func Test_importExchangeRatesFromCSV(t *testing.T) {
+	ctx := context.Background()
+	store, cleanup := createExchangeStore(t)
+	defer cleanup()
+	defer os.Remove("exchange-rates.csv")
+
+	err := ioutil.WriteFile("exchange-rates.csv", []byte(`base,quote,rate,buy_spread,sell_spread,effective_from
+USD,TJS,10.95,100,200,2020-01-01T00:00:00Z
+EUR,TJS,12.1,50,50,
+`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.ImportExchangeRatesFromCSV(ctx, defaultAdminId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates, err := store.ExchangeRates(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 2 || rates[0].Base != "EUR" || rates[0].Rate != 12_100_000 ||
+		rates[1].Base != "USD" || rates[1].SellSpread != 200 {
+
+		t.Errorf("want EUR and USD rates, got: %+v", rates)
+	}
+
+	err = ioutil.WriteFile("exchange-rates.csv", []byte(`base,quote,rate,buy_spread,sell_spread,effective_from
+USD,TJS,11,100,200,
+USD,TJS,11.1234567,100,200,
+`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.ImportExchangeRatesFromCSV(ctx, defaultAdminId)
+	if !errors.Is(err, ErrInvalidExchangeRate) {
+		t.Errorf("want: %v, got: %v", ErrInvalidExchangeRate, err)
+	}
+	rates, err = store.ExchangeRates(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 2 || rates[1].Rate != 10_950_000 {
+		t.Errorf("want the file rejected as a whole, got: %+v", rates)
+	}
+}
@@ -0,0 +1,253 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Counterparty is the other side of an operation. For cash operations only
+// Account is set.
+type Counterparty struct {
+	Account       LedgerAccount
+	OwnerId       int64
+	AccountNumber int64
+}
+
+// AccountOperation is one movement on an account, Amount is negative when
+// money left the account.
+type AccountOperation struct {
+	TransactionId int64
+	Kind          TransactionKind
+	CreatedAt     time.Time
+	Amount        int64
+	Counterparty  Counterparty
+}
+
+// HistoryFilter zero values mean "no restriction". The period is [From, To),
+// amount bounds apply to the absolute amount.
+type HistoryFilter struct {
+	From         time.Time
+	To           time.Time
+	Kinds        []TransactionKind
+	Counterparty *Counterparty
+	MinAmount    int64
+	MaxAmount    int64
+	Limit        int
+	Offset       int
+}
+
+type HistoryPage struct {
+	Operations []AccountOperation
+	HasMore    bool
+}
+
+type Statement struct {
+	ClientId       int64
+	AccountNumber  int64
+	From           time.Time
+	To             time.Time
+	OpeningBalance int64
+	TotalCredits   int64
+	TotalDebits    int64
+	ClosingBalance int64
+	Movements      []AccountOperation
+}
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// AccountHistory returns operations on a client account, newest first.
+func (receiver *Store) AccountHistory(ctx context.Context,
+	clientId, accountNumber int64, filter HistoryFilter) (HistoryPage, error) {
+
+	accountId, err := receiver.clientBankAccountId(ctx, clientId, accountNumber)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+	offset := filter.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	query, args := accountOperationsQuery(LedgerClient, accountId, filter)
+	query += `
+ORDER BY jt.created_at DESC, jt.id DESC
+LIMIT :limit OFFSET :offset;`
+	args = append(args,
+		sql.Named("limit", limit+1),
+		sql.Named("offset", offset),
+	)
+	operations, err := receiver.queryAccountOperations(ctx, query, args)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+
+	page := HistoryPage{Operations: operations}
+	if len(operations) > limit {
+		page.Operations = operations[:limit]
+		page.HasMore = true
+	}
+	return page, nil
+}
+
+// AccountStatement returns the balance at from, every movement in
+// [from, to) in order and the balance at to.
+func (receiver *Store) AccountStatement(ctx context.Context,
+	clientId, accountNumber int64, from, to time.Time) (Statement, error) {
+
+	accountId, err := receiver.clientBankAccountId(ctx, clientId, accountNumber)
+	if err != nil {
+		return Statement{}, err
+	}
+
+	statement := Statement{
+		ClientId:      clientId,
+		AccountNumber: accountNumber,
+		From:          from,
+		To:            to,
+	}
+	err = receiver.conn().QueryRowContext(ctx, getBalanceBeforeSQL,
+		sql.Named("account_type", string(LedgerClient)),
+		sql.Named("account_id", accountId),
+		sql.Named("before", from.Unix()),
+	).Scan(&statement.OpeningBalance)
+	if err != nil {
+		return Statement{}, queryError(getBalanceBeforeSQL, err)
+	}
+
+	query, args := accountOperationsQuery(LedgerClient, accountId,
+		HistoryFilter{From: from, To: to})
+	query += `
+ORDER BY jt.created_at, jt.id;`
+	statement.Movements, err = receiver.queryAccountOperations(ctx, query, args)
+	if err != nil {
+		return Statement{}, err
+	}
+
+	statement.ClosingBalance = statement.OpeningBalance
+	for _, movement := range statement.Movements {
+		if movement.Amount > 0 {
+			statement.TotalCredits += movement.Amount
+		} else {
+			statement.TotalDebits -= movement.Amount
+		}
+		statement.ClosingBalance += movement.Amount
+	}
+	return statement, nil
+}
+
+func (receiver *Store) clientBankAccountId(ctx context.Context,
+	clientId, accountNumber int64) (int64, error) {
+
+	var accountId, balance int64
+	err := receiver.conn().QueryRowContext(ctx, clientAccounts.getIdAndBalance,
+		sql.Named("id", clientId),
+		sql.Named("account_number", accountNumber),
+	).Scan(&accountId, &balance)
+	if err == sql.ErrNoRows {
+		return 0, accountError(LedgerClient, clientId, accountNumber, ErrAccountNotFound)
+	}
+	if err != nil {
+		return 0, queryError(clientAccounts.getIdAndBalance, err)
+	}
+	return accountId, nil
+}
+
+func accountOperationsQuery(account LedgerAccount, accountId int64,
+	filter HistoryFilter) (string, []interface{}) {
+
+	var query strings.Builder
+	query.WriteString(getAccountOperationsSQL)
+	args := []interface{}{
+		sql.Named("account_type", string(account)),
+		sql.Named("account_id", accountId),
+	}
+
+	if !filter.From.IsZero() {
+		query.WriteString("\n  AND jt.created_at >= :from")
+		args = append(args, sql.Named("from", filter.From.Unix()))
+	}
+	if !filter.To.IsZero() {
+		query.WriteString("\n  AND jt.created_at < :to")
+		args = append(args, sql.Named("to", filter.To.Unix()))
+	}
+	if len(filter.Kinds) > 0 {
+		names := make([]string, len(filter.Kinds))
+		for i, kind := range filter.Kinds {
+			names[i] = fmt.Sprintf(":kind%d", i)
+			args = append(args, sql.Named(fmt.Sprintf("kind%d", i), string(kind)))
+		}
+		query.WriteString("\n  AND jt.kind IN (" + strings.Join(names, ", ") + ")")
+	}
+	if filter.MinAmount > 0 {
+		query.WriteString("\n  AND abs(jp.amount) >= :min_amount")
+		args = append(args, sql.Named("min_amount", filter.MinAmount))
+	}
+	if filter.MaxAmount > 0 {
+		query.WriteString("\n  AND abs(jp.amount) <= :max_amount")
+		args = append(args, sql.Named("max_amount", filter.MaxAmount))
+	}
+	if filter.Counterparty != nil {
+		query.WriteString(`
+  AND cp.account_type = :counterparty_type
+  AND coalesce(cba.client_id, cbas.service_id, 0) = :counterparty_owner
+  AND coalesce(cba.account_number, cbas.account_number, 0) = :counterparty_number`)
+		args = append(args,
+			sql.Named("counterparty_type", string(filter.Counterparty.Account)),
+			sql.Named("counterparty_owner", filter.Counterparty.OwnerId),
+			sql.Named("counterparty_number", filter.Counterparty.AccountNumber),
+		)
+	}
+	return query.String(), args
+}
+
+func (receiver *Store) queryAccountOperations(ctx context.Context,
+	query string, args []interface{}) ([]AccountOperation, error) {
+
+	rows, err := receiver.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, queryError(query, err)
+	}
+	defer rows.Close()
+
+	operations := make([]AccountOperation, 0)
+	for rows.Next() {
+		var operation AccountOperation
+		var kind, counterpartyAccount string
+		var createdAt int64
+		err = rows.Scan(
+			&operation.TransactionId,
+			&kind,
+			&createdAt,
+			&operation.Amount,
+			&counterpartyAccount,
+			&operation.Counterparty.OwnerId,
+			&operation.Counterparty.AccountNumber,
+		)
+		if err != nil {
+			return nil, err
+		}
+		operation.Kind = TransactionKind(kind)
+		operation.CreatedAt = time.Unix(createdAt, 0)
+		operation.Counterparty.Account = LedgerAccount(counterpartyAccount)
+		operations = append(operations, operation)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return operations, nil
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_accountHistoryAndStatement(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, login := range []string{"first", "second"} {
+		err = AddClient(defaultAdminId, Client{Login: login}, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []int64{1, 2} {
+		err = AddBankAccountToClient(defaultAdminId, id, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	serviceNumber, err := AddService(defaultAdminId, Service{Name: "taxes"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	current := start
+	defer func() { now = time.Now }()
+	now = func() time.Time { return current }
+
+	_, err = ReplenishBankAccount(defaultAdminId, 1, 0, 100, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current = current.Add(time.Hour)
+	err = TransferToClient(MoneyTransfer{Amount: 30, SenderId: 1, ReceiverId: 2}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current = current.Add(time.Hour)
+	err = PayForService(serviceNumber, 20, 1, 0, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current = current.Add(time.Hour)
+	_, err = ReplenishBankAccount(defaultAdminId, 1, 0, 50, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := AccountHistory(1, 0, HistoryFilter{}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	amountsWant := []int64{50, -20, -30, 100}
+	if len(page.Operations) != len(amountsWant) || page.HasMore {
+		t.Fatalf("want %v operations, got: %+v", len(amountsWant), page)
+	}
+	for i, operation := range page.Operations {
+		if operation.Amount != amountsWant[i] {
+			t.Errorf("want: %v, got: %v", amountsWant[i], operation.Amount)
+		}
+	}
+	counterpartyWant := Counterparty{Account: LedgerService, OwnerId: 1, AccountNumber: 0}
+	if page.Operations[1].Counterparty != counterpartyWant {
+		t.Errorf("want: %v, got: %v", counterpartyWant, page.Operations[1].Counterparty)
+	}
+	if page.Operations[3].Kind != KindReplenishment || !page.Operations[3].CreatedAt.Equal(start) {
+		t.Errorf("want replenishment at %v, got: %+v", start, page.Operations[3])
+	}
+
+	page, err = AccountHistory(1, 0, HistoryFilter{Limit: 3}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Operations) != 3 || !page.HasMore {
+		t.Errorf("want 3 operations and more, got: %+v", page)
+	}
+	page, err = AccountHistory(1, 0, HistoryFilter{Limit: 3, Offset: 3}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Operations) != 1 || page.HasMore {
+		t.Errorf("want 1 last operation, got: %+v", page)
+	}
+
+	for _, filtered := range []struct {
+		filter HistoryFilter
+		want   []int64
+	}{
+		{HistoryFilter{Kinds: []TransactionKind{KindTransfer, KindServicePayment}}, []int64{-20, -30}},
+		{HistoryFilter{Counterparty: &Counterparty{Account: LedgerClient, OwnerId: 2}}, []int64{-30}},
+		{HistoryFilter{MinAmount: 30, MaxAmount: 50}, []int64{50, -30}},
+		{HistoryFilter{From: start.Add(time.Hour), To: start.Add(3 * time.Hour)}, []int64{-20, -30}},
+	} {
+		page, err = AccountHistory(1, 0, filtered.filter, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Operations) != len(filtered.want) {
+			t.Errorf("%+v: want: %v, got: %+v", filtered.filter, filtered.want, page.Operations)
+			continue
+		}
+		for i, operation := range page.Operations {
+			if operation.Amount != filtered.want[i] {
+				t.Errorf("%+v: want: %v, got: %v", filtered.filter, filtered.want[i], operation.Amount)
+			}
+		}
+	}
+
+	statement, err := AccountStatement(1, 0,
+		start.Add(time.Hour), start.Add(3*time.Hour), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statement.OpeningBalance != 100 || statement.ClosingBalance != 50 ||
+		statement.TotalDebits != 50 || statement.TotalCredits != 0 ||
+		len(statement.Movements) != 2 || statement.Movements[0].Amount != -30 {
+		t.Errorf("unexpected statement: %+v", statement)
+	}
+
+	_, err = AccountHistory(1, 42, HistoryFilter{}, db)
+	if err == nil {
+		t.Error("want not nil error")
+	}
+}
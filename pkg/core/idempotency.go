@@ -0,0 +1,99 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// IdempotencyRetention is how long a key protects against repeated calls.
+// Only successful operations are remembered: a failed one moved no money and
+// can be retried with the same key.
+var IdempotencyRetention = 24 * time.Hour
+
+var errIdempotencyKeyRace = errors.New("idempotency key saved concurrently")
+
+func idempotencyRequestHash(tfr MoneyTransfer) string {
+	request := fmt.Sprintf("%d|%d|%d|%d|%d",
+		tfr.Amount,
+		tfr.SenderId,
+		tfr.SenderAccountNumber,
+		tfr.ReceiverId,
+		tfr.ReceiverAccountNumber,
+	)
+	sum := sha256.Sum256([]byte(request))
+	return hex.EncodeToString(sum[:])
+}
+
+// findIdempotencyKey reports whether the request was already executed.
+func (receiver *Store) findIdempotencyKey(ctx context.Context,
+	tfr MoneyTransfer, kind TransactionKind) (bool, error) {
+
+	var operation, requestHash string
+	var transactionId int64
+	err := receiver.conn().QueryRowContext(ctx, getIdempotencyKeySQL,
+		sql.Named("key", tfr.IdempotencyKey),
+		sql.Named("expired_before", now().Add(-IdempotencyRetention).Unix()),
+	).Scan(&operation, &requestHash, &transactionId)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, queryError(getIdempotencyKeySQL, err)
+	}
+	if operation != string(kind) || requestHash != idempotencyRequestHash(tfr) {
+		return false, fmt.Errorf("%w: %s", ErrIdempotencyKeyReused, tfr.IdempotencyKey)
+	}
+	return true, nil
+}
+
+func (receiver *Store) saveIdempotencyKey(ctx context.Context,
+	tfr MoneyTransfer, kind TransactionKind, transactionId int64) error {
+
+	current := now()
+	result, err := receiver.conn().ExecContext(ctx, insertIdempotencyKeySQL,
+		sql.Named("key", tfr.IdempotencyKey),
+		sql.Named("operation", string(kind)),
+		sql.Named("request_hash", idempotencyRequestHash(tfr)),
+		sql.Named("transaction_id", transactionId),
+		sql.Named("created_at", current.Unix()),
+		sql.Named("expired_before", current.Add(-IdempotencyRetention).Unix()),
+	)
+	if err != nil {
+		return queryError(insertIdempotencyKeySQL, err)
+	}
+	saved, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if saved == 0 {
+		return errIdempotencyKeyRace
+	}
+	return nil
+}
+
+func (receiver *Store) replayIdempotencyKey(ctx context.Context,
+	tfr MoneyTransfer, kind TransactionKind) error {
+
+	replayed, err := receiver.findIdempotencyKey(ctx, tfr, kind)
+	if err != nil {
+		return err
+	}
+	if !replayed {
+		return errIdempotencyKeyRace
+	}
+	return nil
+}
+
+func (receiver *Store) PurgeExpiredIdempotencyKeys(ctx context.Context) error {
+	_, err := receiver.conn().ExecContext(ctx, deleteExpiredIdempotencyKeysSQL,
+		now().Add(-IdempotencyRetention).Unix())
+	if err != nil {
+		return queryError(deleteExpiredIdempotencyKeysSQL, err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_idempotentTransfers(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, login := range []string{"first", "second"} {
+		err = AddClient(defaultAdminId, Client{Login: login}, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []int64{1, 2} {
+		err = AddBankAccountToClient(defaultAdminId, id, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	serviceNumber, err := AddService(defaultAdminId, Service{Name: "taxes"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReplenishBankAccount(defaultAdminId, 1, 0, 100, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfer := MoneyTransfer{
+		Amount:         10,
+		SenderId:       1,
+		ReceiverId:     2,
+		IdempotencyKey: "transfer-1",
+	}
+	for i := 0; i < 3; i++ {
+		err = TransferToClient(transfer, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertBalance(t, 1, 0, 90, db)
+	assertBalance(t, 2, 0, 10, db)
+
+	transfer.Amount = 20
+	err = TransferToClient(transfer, db)
+	if !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("want: %v, got: %v", ErrIdempotencyKeyReused, err)
+	}
+
+	payment := ServicePayment{
+		ServiceNumber:  serviceNumber,
+		Amount:         5,
+		PayerId:        1,
+		IdempotencyKey: "payment-1",
+	}
+	for i := 0; i < 2; i++ {
+		err = MakeServicePayment(payment, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertBalance(t, 1, 0, 85, db)
+
+	err = MakeServicePayment(ServicePayment{
+		ServiceNumber:  serviceNumber,
+		Amount:         1000,
+		PayerId:        1,
+		IdempotencyKey: "payment-2",
+	}, db)
+	if err == nil {
+		t.Error("want not nil error")
+	}
+	payment.IdempotencyKey = "payment-2"
+	err = MakeServicePayment(payment, db)
+	if err != nil {
+		t.Errorf("want failed request key to be reusable, got: %v", err)
+	}
+	assertBalance(t, 1, 0, 80, db)
+
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Now().Add(IdempotencyRetention + time.Hour) }
+	transfer.Amount = 10
+	err = TransferToClient(transfer, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, 1, 0, 70, db)
+
+	err = PurgeExpiredIdempotencyKeys(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys int
+	err = db.QueryRow(`SELECT count(*) FROM idempotency_keys`).Scan(&keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys != 1 {
+		t.Errorf("want: 1, got: %v", keys)
+	}
+}
+
+func assertBalance(t *testing.T, clientId, accountNumber, want int64, db *sql.DB) {
+	t.Helper()
+	var balance int64
+	err := NewStore(db).conn().QueryRowContext(context.Background(),
+		getBalanceByClientIdAndAccountNumberSQL,
+		sql.Named("id", clientId),
+		sql.Named("account_number", accountNumber),
+	).Scan(&balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != want {
+		t.Errorf("client %v account %v: want: %v, got: %v",
+			clientId, accountNumber, want, balance)
+	}
+}
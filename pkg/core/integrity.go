@@ -0,0 +1,69 @@
+package core
+
+import "context"
+
+type AccountRecord struct {
+	Account       LedgerAccount
+	Id            int64
+	OwnerId       int64
+	AccountNumber int64
+	Balance       int64
+}
+
+type IntegrityReport struct {
+	// OrphanAccounts belong to clients or services that don't exist.
+	OrphanAccounts []AccountRecord
+	// DuplicateAccountNumbers lists every account sharing its number with
+	// another account of the same owner.
+	DuplicateAccountNumbers []AccountRecord
+	NegativeBalances        []AccountRecord
+}
+
+func (receiver IntegrityReport) Ok() bool {
+	return len(receiver.OrphanAccounts) == 0 &&
+		len(receiver.DuplicateAccountNumbers) == 0 &&
+		len(receiver.NegativeBalances) == 0
+}
+
+// IntegrityCheck scans the accounts for data the constraints would reject,
+// left by databases or imports from before they were enforced.
+func (receiver *Store) IntegrityCheck(ctx context.Context) (report IntegrityReport, err error) {
+	for _, accounts := range []accountQueries{clientAccounts, serviceAccounts} {
+		checks := []struct {
+			query  string
+			report *[]AccountRecord
+		}{
+			{accounts.getOrphans, &report.OrphanAccounts},
+			{accounts.getDuplicateNumbers, &report.DuplicateAccountNumbers},
+			{accounts.getNegativeBalances, &report.NegativeBalances},
+		}
+		for _, check := range checks {
+			records, err := receiver.accountRecords(ctx, accounts.ledgerAccount, check.query)
+			if err != nil {
+				return report, err
+			}
+			*check.report = append(*check.report, records...)
+		}
+	}
+	return report, nil
+}
+func (receiver *Store) accountRecords(ctx context.Context,
+	account LedgerAccount, query string) ([]AccountRecord, error) {
+
+	rows, err := receiver.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, queryError(query, err)
+	}
+	defer rows.Close()
+
+	var records []AccountRecord
+	for rows.Next() {
+		record := AccountRecord{Account: account}
+		err = rows.Scan(&record.Id, &record.OwnerId, &record.AccountNumber, &record.Balance)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
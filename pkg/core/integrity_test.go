@@ -0,0 +1,111 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_integrityConstraints(t *testing.T) {
+	db, cleanup := createDBinFile(t)
+	defer cleanup()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddClient(defaultAdminId, Client{Login: "client"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddBankAccountToClient(defaultAdminId, 1, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range []string{
+		`INSERT INTO bank_accounts (client_id, account_number, balance) VALUES (2, 0, 0)`,
+		`INSERT INTO bank_accounts (client_id, account_number, balance) VALUES (1, 0, 0)`,
+		`INSERT INTO bank_accounts (client_id, account_number, balance) VALUES (1, 1, -1)`,
+		`UPDATE bank_accounts SET balance = -1 WHERE client_id = 1`,
+		`UPDATE bank_accounts SET client_id = 2 WHERE client_id = 1`,
+		`DELETE FROM clients WHERE id = 1`,
+		`INSERT INTO bank_accounts_services (service_id, account_number, balance) VALUES (1, 0, 0)`,
+	} {
+		_, err = db.Exec(query)
+		if err == nil {
+			t.Errorf("want %s to be rejected", query)
+		}
+	}
+
+	err = insertBankAccountToDB(context.Background(),
+		BankAccount{Id: 5, UserId: 1, AccountId: 1, Balance: -10}, NewStore(db))
+	if !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("want: %v, got: %v", ErrInvalidAmount, err)
+	}
+}
+
+func Test_integrityCheck(t *testing.T) {
+	db, cleanup := createDBinFile(t)
+	defer cleanup()
+
+	// data from before the constraints
+	ctx := context.Background()
+	err := Migrate(ctx, db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+PRAGMA foreign_keys = OFF;
+INSERT INTO clients (login, password, name, phone) VALUES ('client', '', '', '');
+INSERT INTO bank_accounts (client_id, account_number, balance)
+VALUES (1, 0, 10), (1, 0, 20), (1, 1, -5), (7, 0, 0);
+PRAGMA foreign_keys = ON;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := IntegrityCheck(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := IntegrityReport{
+		OrphanAccounts: []AccountRecord{
+			{Account: LedgerClient, Id: 4, OwnerId: 7, AccountNumber: 0, Balance: 0},
+		},
+		DuplicateAccountNumbers: []AccountRecord{
+			{Account: LedgerClient, Id: 1, OwnerId: 1, AccountNumber: 0, Balance: 10},
+			{Account: LedgerClient, Id: 2, OwnerId: 1, AccountNumber: 0, Balance: 20},
+		},
+		NegativeBalances: []AccountRecord{
+			{Account: LedgerClient, Id: 3, OwnerId: 1, AccountNumber: 1, Balance: -5},
+		},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("want: %+v, got: %+v", want, report)
+	}
+	if report.Ok() {
+		t.Error("want report not ok")
+	}
+	err = Init(db)
+	if err == nil {
+		t.Error("want constraints to fail on duplicate account numbers")
+	}
+
+	_, err = db.Exec(`DELETE FROM bank_accounts WHERE id IN (2, 3, 4)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = IntegrityCheck(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Ok() {
+		t.Errorf("want report ok, got: %+v", report)
+	}
+}
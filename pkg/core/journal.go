@@ -0,0 +1,163 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// LedgerAccount tells which table a posting's account id points to.
+type LedgerAccount string
+
+const (
+	LedgerClient  LedgerAccount = "client"  // bank_accounts
+	LedgerService LedgerAccount = "service" // bank_accounts_services
+	// LedgerCash is the bank's side of money coming from or going outside.
+	LedgerCash LedgerAccount = "cash"
+	// LedgerExchange is the bank's side of a conversion between currencies:
+	// it takes the sent amount in one currency and gives the converted one.
+	LedgerExchange LedgerAccount = "exchange"
+	// LedgerAtm is cash going out of and into an ATM, its account id is the
+	// ATM id.
+	LedgerAtm LedgerAccount = "atm"
+)
+
+type TransactionKind string
+
+const (
+	KindReplenishment  TransactionKind = "replenishment"
+	KindTransfer       TransactionKind = "transfer"
+	KindServicePayment TransactionKind = "service_payment"
+	KindImport         TransactionKind = "import"
+	KindExchange       TransactionKind = "exchange"
+	KindAtmWithdrawal  TransactionKind = "atm_withdrawal"
+	KindAtmDeposit     TransactionKind = "atm_deposit"
+)
+
+// Posting is one leg of a journal transaction. Positive amounts increase
+// the account balance, negative ones decrease it; the legs of a transaction
+// always sum to zero.
+type Posting struct {
+	Account   LedgerAccount
+	AccountId int64
+	Amount    int64
+}
+
+type BalanceMismatch struct {
+	Account   LedgerAccount
+	AccountId int64
+	Stored    int64
+	Derived   int64
+}
+
+type LedgerReport struct {
+	UnbalancedTransactions []int64
+	BalanceMismatches      []BalanceMismatch
+}
+
+func (receiver LedgerReport) Ok() bool {
+	return len(receiver.UnbalancedTransactions) == 0 &&
+		len(receiver.BalanceMismatches) == 0
+}
+
+// writeJournal must run in the same transaction as the balance updates it
+// describes.
+func (receiver *Store) writeJournal(ctx context.Context,
+	kind TransactionKind, postings []Posting) (int64, error) {
+
+	var sum int64
+	for _, posting := range postings {
+		sum += posting.Amount
+	}
+	if sum != 0 || len(postings) < 2 {
+		return 0, fmt.Errorf("%w: %s %v", ErrUnbalancedTransaction, kind, postings)
+	}
+
+	transactionId, err := receiver.dialect.insertReturningId(ctx, receiver.conn(),
+		insertJournalTransactionSQL,
+		sql.Named("kind", string(kind)),
+		sql.Named("created_at", now().Unix()),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, posting := range postings {
+		_, err = receiver.conn().ExecContext(ctx, insertJournalPostingSQL,
+			sql.Named("transaction_id", transactionId),
+			sql.Named("account_type", string(posting.Account)),
+			sql.Named("account_id", posting.AccountId),
+			sql.Named("amount", posting.Amount),
+		)
+		if err != nil {
+			return 0, queryError(insertJournalPostingSQL, err)
+		}
+	}
+	return transactionId, nil
+}
+
+func (receiver *Store) DerivedBalance(ctx context.Context,
+	account LedgerAccount, accountId int64) (int64, error) {
+
+	var balance int64
+	err := receiver.conn().QueryRowContext(ctx, getDerivedBalanceSQL,
+		sql.Named("account_type", string(account)),
+		sql.Named("account_id", accountId),
+	).Scan(&balance)
+	if err != nil {
+		return 0, queryError(getDerivedBalanceSQL, err)
+	}
+	return balance, nil
+}
+
+// VerifyLedger reports transactions whose legs don't sum to zero and
+// accounts whose stored balance differs from the one derived from the journal.
+func (receiver *Store) VerifyLedger(ctx context.Context) (report LedgerReport, err error) {
+	rows, err := receiver.conn().QueryContext(ctx, getUnbalancedJournalTransactionsSQL)
+	if err != nil {
+		return report, queryError(getUnbalancedJournalTransactionsSQL, err)
+	}
+	defer rows.Close()
+	var transactionId, sum int64
+	for rows.Next() {
+		err = rows.Scan(&transactionId, &sum)
+		if err != nil {
+			return report, err
+		}
+		report.UnbalancedTransactions = append(report.UnbalancedTransactions, transactionId)
+	}
+	err = rows.Err()
+	if err != nil {
+		return report, err
+	}
+
+	for _, accounts := range []accountQueries{clientAccounts, serviceAccounts} {
+		mismatches, err := receiver.balanceMismatches(ctx, accounts.ledgerAccount,
+			accounts.getBalanceMismatches)
+		if err != nil {
+			return report, err
+		}
+		report.BalanceMismatches = append(report.BalanceMismatches, mismatches...)
+	}
+	return report, nil
+}
+func (receiver *Store) balanceMismatches(ctx context.Context,
+	account LedgerAccount, query string) ([]BalanceMismatch, error) {
+
+	rows, err := receiver.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, queryError(query, err)
+	}
+	defer rows.Close()
+
+	var mismatches []BalanceMismatch
+	for rows.Next() {
+		mismatch := BalanceMismatch{Account: account}
+		err = rows.Scan(&mismatch.AccountId, &mismatch.Stored, &mismatch.Derived)
+		if err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+	return mismatches, rows.Err()
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_journalFollowsBalances(t *testing.T) {
+	runOnEveryDialect(t, func(t *testing.T, store *Store) {
+		db := store.db
+
+		err := Init(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, login := range []string{"first", "second"} {
+			err = AddClient(defaultAdminId, Client{Login: login}, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, id := range []int64{1, 2} {
+			err = AddBankAccountToClient(defaultAdminId, id, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		serviceNumber, err := AddService(defaultAdminId, Service{Name: "taxes"}, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = ReplenishBankAccount(defaultAdminId, 1, 0, 100, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = TransferToClient(MoneyTransfer{
+			Amount:     30,
+			SenderId:   1,
+			ReceiverId: 2,
+		}, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = PayForService(serviceNumber, 20, 1, 0, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = TransferToClient(MoneyTransfer{
+			Amount:     1000,
+			SenderId:   1,
+			ReceiverId: 2,
+		}, db)
+		if err == nil {
+			t.Error("want not nil error")
+		}
+
+		report, err := VerifyLedger(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Ok() {
+			t.Errorf("want ok ledger, got: %+v", report)
+		}
+
+		for _, balance := range []struct {
+			account   LedgerAccount
+			accountId int64
+			want      int64
+		}{
+			{LedgerClient, 1, 50},
+			{LedgerClient, 2, 30},
+			{LedgerService, 1, 20},
+			{LedgerCash, 0, -100},
+		} {
+			got, err := DerivedBalance(balance.account, balance.accountId, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != balance.want {
+				t.Errorf("%v %v: want: %v, got: %v",
+					balance.account, balance.accountId, balance.want, got)
+			}
+		}
+
+		_, err = db.Exec(`UPDATE journal_postings SET amount = 0`)
+		if err == nil {
+			t.Error("want journal to be append-only")
+		}
+		_, err = db.Exec(`DELETE FROM journal_transactions`)
+		if err == nil {
+			t.Error("want journal to be append-only")
+		}
+
+		_, err = db.Exec(`UPDATE bank_accounts SET balance = 1000 WHERE id = 2`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err = VerifyLedger(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mismatchWant := BalanceMismatch{Account: LedgerClient, AccountId: 2, Stored: 1000, Derived: 30}
+		if len(report.BalanceMismatches) != 1 || report.BalanceMismatches[0] != mismatchWant {
+			t.Errorf("want: %v, got: %v", mismatchWant, report.BalanceMismatches)
+		}
+	})
+}
+
+func Test_writeJournalRejectsUnbalanced(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	_, err = NewStore(db).WithTx(tx).writeJournal(context.Background(), KindTransfer, []Posting{
+		{Account: LedgerClient, AccountId: 1, Amount: 10},
+		{Account: LedgerClient, AccountId: 2, Amount: -9},
+	})
+	if !errors.Is(err, ErrUnbalancedTransaction) {
+		t.Errorf("want: %v, got: %v", ErrUnbalancedTransaction, err)
+	}
+}
@@ -0,0 +1,566 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Bank is what both the database Store and the MemoryStore can do. The
+// operations that take a managerId fail with ErrPermissionDenied unless that
+// manager may do them.
+type Bank interface {
+	// Atomically runs fn so that either all of its operations take effect or
+	// none, like Store.InTx.
+	Atomically(ctx context.Context, fn func(tx Bank) error) error
+
+	IsLoginAvailable(ctx context.Context, login string) (bool, error)
+	AddClient(ctx context.Context, managerId int64, client Client) error
+	AddManager(ctx context.Context, managerId int64, manager Manager) error
+	GetClientIdByLogin(ctx context.Context, login string) (int64, error)
+	GetClientIdByPhoneNumber(ctx context.Context, phone string) (int64, error)
+
+	AddBankAccountToClient(ctx context.Context, managerId, id int64) error
+	AddBankAccountToService(ctx context.Context, managerId, id int64) error
+	AddBankAccountToClientInCurrency(ctx context.Context, managerId, id int64, currency string) error
+	AddBankAccountToServiceInCurrency(ctx context.Context, managerId, id int64, currency string) error
+	BankAccountsList(ctx context.Context, id int64) ([]BankAccount, error)
+	GetAllAccountNumbersByClientId(ctx context.Context, id int64) ([]int64, error)
+
+	AddService(ctx context.Context, managerId int64, service Service) (string, error)
+	AddATM(ctx context.Context, managerId int64, address string) error
+	AtmsList(ctx context.Context) ([]string, error)
+
+	ReplenishBankAccount(ctx context.Context,
+		managerId, clientId, accountNumber, amount int64) (Replenishment, error)
+	TransferToClient(ctx context.Context, transfer MoneyTransfer) error
+	PayForService(ctx context.Context, serviceNumber string,
+		amount, payerId, payerAccountNumber int64) error
+	MakeServicePayment(ctx context.Context, payment ServicePayment) error
+}
+
+var (
+	_ Bank = (*Store)(nil)
+	_ Bank = (*MemoryStore)(nil)
+)
+
+func (receiver *Store) Atomically(ctx context.Context, fn func(tx Bank) error) error {
+	return receiver.InTx(ctx, func(tx *Store) error {
+		return fn(tx)
+	})
+}
+
+// MemoryStore keeps the bank in memory and needs no database, for tests and
+// simulations. Its operations fail with the same errors as the Store ones.
+// It has no roles: the default admin, like on a Store after Init, may do
+// everything and other managers nothing.
+type MemoryStore struct {
+	mutex *sync.Mutex
+	state *memoryState
+	// inTx is set on the store Atomically passes to fn, which already holds
+	// the lock.
+	inTx bool
+}
+
+type memoryState struct {
+	clients         []Client
+	managers        []Manager
+	services        []Service
+	atms            []Atm
+	clientAccounts  []memoryAccount
+	serviceAccounts []memoryAccount
+	lastTransaction int64
+	idempotencyKeys map[string]memoryIdempotencyKey
+}
+
+type memoryAccount struct {
+	ownerId       int64
+	accountNumber int64
+	balance       int64
+	currency      string
+}
+
+type memoryIdempotencyKey struct {
+	kind        TransactionKind
+	requestHash string
+	createdAt   time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mutex: &sync.Mutex{},
+		state: &memoryState{
+			// the memory store has no logins, the admin needs no password
+			managers:        []Manager{{Id: defaultAdminId, Login: "admin"}},
+			idempotencyKeys: make(map[string]memoryIdempotencyKey),
+		},
+	}
+}
+
+func (receiver *MemoryStore) authorize(managerId int64, permission Permission) error {
+	if managerId != defaultAdminId {
+		return fmt.Errorf("%w: manager %d has no %s", ErrPermissionDenied, managerId, permission)
+	}
+	return nil
+}
+
+func (receiver *memoryState) clone() memoryState {
+	clone := memoryState{
+		clients:         append([]Client(nil), receiver.clients...),
+		managers:        append([]Manager(nil), receiver.managers...),
+		services:        append([]Service(nil), receiver.services...),
+		atms:            append([]Atm(nil), receiver.atms...),
+		clientAccounts:  append([]memoryAccount(nil), receiver.clientAccounts...),
+		serviceAccounts: append([]memoryAccount(nil), receiver.serviceAccounts...),
+		lastTransaction: receiver.lastTransaction,
+		idempotencyKeys: make(map[string]memoryIdempotencyKey, len(receiver.idempotencyKeys)),
+	}
+	for key, value := range receiver.idempotencyKeys {
+		clone.idempotencyKeys[key] = value
+	}
+	return clone
+}
+
+// lock must be deferred-unlocked by every operation.
+func (receiver *MemoryStore) lock(ctx context.Context) (unlock func(), err error) {
+	err = ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+	if receiver.inTx {
+		return func() {}, nil
+	}
+	receiver.mutex.Lock()
+	return receiver.mutex.Unlock, nil
+}
+
+// Atomically restores the state from before fn when fn fails, a nested call
+// only what its own fn did. Operations of other goroutines wait until fn
+// returns.
+func (receiver *MemoryStore) Atomically(ctx context.Context, fn func(tx Bank) error) error {
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	snapshot := receiver.state.clone()
+	err = fn(&MemoryStore{mutex: receiver.mutex, state: receiver.state, inTx: true})
+	if err != nil {
+		*receiver.state = snapshot
+	}
+	return err
+}
+
+//---------------Clients and managers
+
+func (receiver *MemoryStore) IsLoginAvailable(ctx context.Context, login string) (bool, error) {
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	return !receiver.state.isLoginTaken(normalizeLogin(login)), nil
+}
+func (receiver *memoryState) isLoginTaken(login string) bool {
+	for _, client := range receiver.clients {
+		if client.Login == login {
+			return true
+		}
+	}
+	for _, manager := range receiver.managers {
+		if manager.Login == login {
+			return true
+		}
+	}
+	return false
+}
+
+func (receiver *MemoryStore) AddClient(ctx context.Context, managerId int64, client Client) error {
+	err := receiver.authorize(managerId, PermissionClientsWrite)
+	if err != nil {
+		return err
+	}
+	password, err := hashPassword(client.Password)
+	if err != nil {
+		return err
+	}
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	client.Login = normalizeLogin(client.Login)
+	if receiver.state.isLoginTaken(client.Login) {
+		return fmt.Errorf("%w: %s", ErrLoginTaken, client.Login)
+	}
+	client.Id = int64(len(receiver.state.clients)) + 1
+	client.Password = password
+	receiver.state.clients = append(receiver.state.clients, client)
+	return nil
+}
+func (receiver *MemoryStore) AddManager(ctx context.Context, managerId int64, manager Manager) error {
+	err := receiver.authorize(managerId, PermissionManagersWrite)
+	if err != nil {
+		return err
+	}
+	password, err := hashPassword(manager.Password)
+	if err != nil {
+		return err
+	}
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	manager.Login = normalizeLogin(manager.Login)
+	if receiver.state.isLoginTaken(manager.Login) {
+		return fmt.Errorf("%w: %s", ErrLoginTaken, manager.Login)
+	}
+	manager.Id = int64(len(receiver.state.managers)) + 1
+	manager.Password = password
+	receiver.state.managers = append(receiver.state.managers, manager)
+	return nil
+}
+
+func (receiver *MemoryStore) GetClientIdByLogin(ctx context.Context, login string) (int64, error) {
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	login = normalizeLogin(login)
+	for _, client := range receiver.state.clients {
+		if client.Login == login {
+			return client.Id, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: login %s", ErrClientNotFound, login)
+}
+func (receiver *MemoryStore) GetClientIdByPhoneNumber(ctx context.Context, phone string) (int64, error) {
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	for _, client := range receiver.state.clients {
+		if client.Phone == phone {
+			return client.Id, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: phone %s", ErrClientNotFound, phone)
+}
+
+//---------------Accounts
+
+func (receiver *MemoryStore) AddBankAccountToClient(ctx context.Context, managerId, id int64) error {
+	return receiver.AddBankAccountToClientInCurrency(ctx, managerId, id, DefaultCurrency)
+}
+func (receiver *MemoryStore) AddBankAccountToService(ctx context.Context, managerId, id int64) error {
+	return receiver.AddBankAccountToServiceInCurrency(ctx, managerId, id, DefaultCurrency)
+}
+func (receiver *MemoryStore) AddBankAccountToClientInCurrency(ctx context.Context,
+	managerId, id int64, currency string) error {
+
+	err := receiver.authorize(managerId, PermissionAccountsWrite)
+	if err != nil {
+		return err
+	}
+	known, err := LookupCurrency(currency)
+	if err != nil {
+		return err
+	}
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if id < 1 || id > int64(len(receiver.state.clients)) {
+		return fmt.Errorf("%w: %d", ErrClientNotFound, id)
+	}
+	receiver.state.clientAccounts = addMemoryAccount(receiver.state.clientAccounts, id, known.Code)
+	return nil
+}
+func (receiver *MemoryStore) AddBankAccountToServiceInCurrency(ctx context.Context,
+	managerId, id int64, currency string) error {
+
+	err := receiver.authorize(managerId, PermissionAccountsWrite)
+	if err != nil {
+		return err
+	}
+	known, err := LookupCurrency(currency)
+	if err != nil {
+		return err
+	}
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if id < 1 || id > int64(len(receiver.state.services)) {
+		return fmt.Errorf("%w: %d", ErrServiceNotFound, id)
+	}
+	receiver.state.serviceAccounts = addMemoryAccount(receiver.state.serviceAccounts, id, known.Code)
+	return nil
+}
+
+// addMemoryAccount numbers the accounts of every owner from zero.
+func addMemoryAccount(accounts []memoryAccount, ownerId int64, currency string) []memoryAccount {
+	var accountNumber int64
+	for _, account := range accounts {
+		if account.ownerId == ownerId {
+			accountNumber++
+		}
+	}
+	return append(accounts, memoryAccount{
+		ownerId:       ownerId,
+		accountNumber: accountNumber,
+		currency:      currency,
+	})
+}
+
+func findMemoryAccount(accounts []memoryAccount, ownerId, accountNumber int64) *memoryAccount {
+	for i := range accounts {
+		if accounts[i].ownerId == ownerId && accounts[i].accountNumber == accountNumber {
+			return &accounts[i]
+		}
+	}
+	return nil
+}
+
+func (receiver *MemoryStore) BankAccountsList(ctx context.Context, id int64) ([]BankAccount, error) {
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	bankAccounts := make([]BankAccount, 0)
+	for _, account := range receiver.state.clientAccounts {
+		if account.ownerId == id {
+			bankAccounts = append(bankAccounts, BankAccount{
+				UserId:     id,
+				AccountId:  account.accountNumber,
+				Balance:    account.balance,
+				Currency:   account.currency,
+				MinorUnits: minorUnitsOf(account.currency),
+			})
+		}
+	}
+	return bankAccounts, nil
+}
+func (receiver *MemoryStore) GetAllAccountNumbersByClientId(ctx context.Context, id int64) ([]int64, error) {
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var accountNumbers []int64
+	for _, account := range receiver.state.clientAccounts {
+		if account.ownerId == id {
+			accountNumbers = append(accountNumbers, account.accountNumber)
+		}
+	}
+	return accountNumbers, nil
+}
+
+//---------------Services and ATMs
+
+func (receiver *MemoryStore) AddService(ctx context.Context,
+	managerId int64, service Service) (string, error) {
+
+	err := receiver.authorize(managerId, PermissionServicesWrite)
+	if err != nil {
+		return "", err
+	}
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	service.Id = int64(len(receiver.state.services)) + 1
+	number, err := NewServiceNumber(service.Id, 0)
+	if err != nil {
+		return "", err
+	}
+	receiver.state.services = append(receiver.state.services, service)
+	receiver.state.serviceAccounts = addMemoryAccount(receiver.state.serviceAccounts, service.Id,
+		DefaultCurrency)
+	return number.String(), nil
+}
+
+func (receiver *MemoryStore) AddATM(ctx context.Context, managerId int64, address string) error {
+	err := receiver.authorize(managerId, PermissionAtmsWrite)
+	if err != nil {
+		return err
+	}
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	atm, err := normalizeAtm(Atm{
+		Id:      int64(len(receiver.state.atms)) + 1,
+		Address: address,
+	})
+	if err != nil {
+		return err
+	}
+	receiver.state.atms = append(receiver.state.atms, atm)
+	return nil
+}
+func (receiver *MemoryStore) AtmsList(ctx context.Context) ([]string, error) {
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	atms := make([]string, 0, len(receiver.state.atms))
+	for _, atm := range receiver.state.atms {
+		atms = append(atms, atm.Address)
+	}
+	return atms, nil
+}
+
+//---------------Money
+
+func (receiver *MemoryStore) ReplenishBankAccount(ctx context.Context,
+	managerId, clientId, accountNumber, amount int64) (Replenishment, error) {
+
+	if amount < 1 {
+		return Replenishment{}, ErrInvalidAmount
+	}
+	err := receiver.authorize(managerId, PermissionAccountsReplenish)
+	if err != nil {
+		return Replenishment{}, err
+	}
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return Replenishment{}, err
+	}
+	defer unlock()
+
+	account := findMemoryAccount(receiver.state.clientAccounts, clientId, accountNumber)
+	if account == nil {
+		return Replenishment{}, accountError(LedgerClient, clientId, accountNumber, ErrAccountNotFound)
+	}
+	account.balance += amount
+	receiver.state.lastTransaction++
+	return Replenishment{
+		TransactionId: receiver.state.lastTransaction,
+		ClientId:      clientId,
+		AccountNumber: accountNumber,
+		Amount:        amount,
+		Balance:       account.balance,
+		CreatedAt:     now(),
+	}, nil
+}
+
+func (receiver *MemoryStore) TransferToClient(ctx context.Context, transfer MoneyTransfer) error {
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return receiver.state.transfer(transfer, KindTransfer, LedgerClient,
+		receiver.state.clientAccounts, ErrAccountNotFound)
+}
+
+func (receiver *MemoryStore) PayForService(ctx context.Context, serviceNumber string,
+	amount, payerId, payerAccountNumber int64) error {
+
+	return receiver.MakeServicePayment(ctx, ServicePayment{
+		ServiceNumber:      serviceNumber,
+		Amount:             amount,
+		PayerId:            payerId,
+		PayerAccountNumber: payerAccountNumber,
+	})
+}
+func (receiver *MemoryStore) MakeServicePayment(ctx context.Context, payment ServicePayment) error {
+	serviceId, accountNumber, err := ServiceNumberToIdAndAccountNumber(payment.ServiceNumber)
+	if err != nil {
+		return err
+	}
+	unlock, err := receiver.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	transfer := MoneyTransfer{
+		Amount:                payment.Amount,
+		SenderId:              payment.PayerId,
+		SenderAccountNumber:   payment.PayerAccountNumber,
+		ReceiverId:            serviceId,
+		ReceiverAccountNumber: accountNumber,
+		IdempotencyKey:        payment.IdempotencyKey,
+	}
+	return receiver.state.transfer(transfer, KindServicePayment, LedgerService,
+		receiver.state.serviceAccounts, ErrServiceNotFound)
+}
+
+// transfer checks everything before it changes a balance, so a failed
+// transfer leaves the state as it was.
+func (receiver *memoryState) transfer(tfr MoneyTransfer, kind TransactionKind,
+	receiverLedger LedgerAccount, receiverAccounts []memoryAccount,
+	receiverNotFound error) error {
+
+	if tfr.Amount < 1 {
+		return ErrInvalidAmount
+	}
+
+	if tfr.IdempotencyKey != "" {
+		key, ok := receiver.idempotencyKeys[tfr.IdempotencyKey]
+		if ok && !key.createdAt.Before(now().Add(-IdempotencyRetention)) {
+			if key.kind != kind || key.requestHash != idempotencyRequestHash(tfr) {
+				return fmt.Errorf("%w: %s", ErrIdempotencyKeyReused, tfr.IdempotencyKey)
+			}
+			return nil
+		}
+	}
+
+	sender := findMemoryAccount(receiver.clientAccounts, tfr.SenderId, tfr.SenderAccountNumber)
+	if sender == nil {
+		return accountError(LedgerClient, tfr.SenderId, tfr.SenderAccountNumber,
+			ErrAccountNotFound)
+	}
+	if tfr.Amount > sender.balance {
+		return accountError(LedgerClient, tfr.SenderId, tfr.SenderAccountNumber,
+			ErrInsufficientFunds)
+	}
+	recipient := findMemoryAccount(receiverAccounts, tfr.ReceiverId, tfr.ReceiverAccountNumber)
+	if recipient == nil {
+		return accountError(receiverLedger, tfr.ReceiverId, tfr.ReceiverAccountNumber,
+			receiverNotFound)
+	}
+	if sender.currency != recipient.currency {
+		return accountError(receiverLedger, tfr.ReceiverId, tfr.ReceiverAccountNumber,
+			fmt.Errorf("%w: %s to %s", ErrCurrencyMismatch, sender.currency, recipient.currency))
+	}
+
+	sender.balance -= tfr.Amount
+	recipient.balance += tfr.Amount
+	receiver.lastTransaction++
+
+	if tfr.IdempotencyKey != "" {
+		receiver.idempotencyKeys[tfr.IdempotencyKey] = memoryIdempotencyKey{
+			kind:        kind,
+			requestHash: idempotencyRequestHash(tfr),
+			createdAt:   now(),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// runOnEveryBank runs the test on a SQLite Store and on a MemoryStore, which
+// must behave the same.
+func runOnEveryBank(t *testing.T, test func(t *testing.T, bank Bank)) {
+	t.Run("store", func(t *testing.T) {
+		db, cleanup := createDBinFile(t)
+		defer cleanup()
+		store := NewStore(db)
+		err := store.Init(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, store)
+	})
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+}
+
+func Test_bankOperations(t *testing.T) {
+	runOnEveryBank(t, func(t *testing.T, bank Bank) {
+		ctx := context.Background()
+		for _, client := range []Client{
+			{Login: "Sender", Password: "secret", Phone: "100"},
+			{Login: "receiver", Password: "secret", Phone: "200"},
+		} {
+			err := bank.AddClient(ctx, defaultAdminId, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := bank.AddManager(ctx, defaultAdminId, Manager{Login: " sender", Password: "secret"})
+		if !errors.Is(err, ErrLoginTaken) {
+			t.Errorf("want: %v, got: %v", ErrLoginTaken, err)
+		}
+		// a manager without a role may change nothing
+		err = bank.AddManager(ctx, defaultAdminId, Manager{Login: "cashier", Password: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		const cashierId = 2
+		err = bank.AddClient(ctx, cashierId, Client{Login: "third"})
+		if !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("want: %v, got: %v", ErrPermissionDenied, err)
+		}
+		available, err := bank.IsLoginAvailable(ctx, "RECEIVER")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if available {
+			t.Error("want login taken")
+		}
+
+		senderId, err := bank.GetClientIdByLogin(ctx, "sender")
+		if err != nil {
+			t.Fatal(err)
+		}
+		receiverId, err := bank.GetClientIdByPhoneNumber(ctx, "200")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = bank.GetClientIdByPhoneNumber(ctx, "300")
+		if !errors.Is(err, ErrClientNotFound) {
+			t.Errorf("want: %v, got: %v", ErrClientNotFound, err)
+		}
+		for _, id := range []int64{senderId, receiverId} {
+			err = bank.AddBankAccountToClient(ctx, defaultAdminId, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = bank.AddBankAccountToClient(ctx, defaultAdminId, 100)
+		if !errors.Is(err, ErrClientNotFound) {
+			t.Errorf("want: %v, got: %v", ErrClientNotFound, err)
+		}
+		numbers, err := bank.GetAllAccountNumbersByClientId(ctx, senderId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(numbers, []int64{0}) {
+			t.Errorf("want: [0], got: %v", numbers)
+		}
+
+		serviceNumber, err := bank.AddService(ctx, defaultAdminId, Service{Name: "internet"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serviceNumber != "0000000010000008" {
+			t.Errorf("want: 0000000010000008, got: %v", serviceNumber)
+		}
+
+		replenishment, err := bank.ReplenishBankAccount(ctx, defaultAdminId, senderId, 0, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replenishment.Balance != 1000 {
+			t.Errorf("want: 1000, got: %v", replenishment.Balance)
+		}
+		_, err = bank.ReplenishBankAccount(ctx, defaultAdminId, senderId, 1, 1000)
+		if !errors.Is(err, ErrAccountNotFound) {
+			t.Errorf("want: %v, got: %v", ErrAccountNotFound, err)
+		}
+		_, err = bank.ReplenishBankAccount(ctx, cashierId, senderId, 0, 1000)
+		if !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("want: %v, got: %v", ErrPermissionDenied, err)
+		}
+
+		transfer := MoneyTransfer{
+			Amount:         300,
+			SenderId:       senderId,
+			ReceiverId:     receiverId,
+			IdempotencyKey: "transfer-1",
+		}
+		for i := 0; i < 2; i++ {
+			err = bank.TransferToClient(ctx, transfer)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		transfer.Amount = 301
+		err = bank.TransferToClient(ctx, transfer)
+		if !errors.Is(err, ErrIdempotencyKeyReused) {
+			t.Errorf("want: %v, got: %v", ErrIdempotencyKeyReused, err)
+		}
+		err = bank.TransferToClient(ctx, MoneyTransfer{Amount: 1000, SenderId: senderId, ReceiverId: receiverId})
+		var accountErr *AccountError
+		if !errors.As(err, &accountErr) || !errors.Is(err, ErrInsufficientFunds) || accountErr.OwnerId != senderId {
+			t.Errorf("want: %v of the sender, got: %v", ErrInsufficientFunds, err)
+		}
+		err = bank.TransferToClient(ctx, MoneyTransfer{Amount: 1, SenderId: senderId, ReceiverId: receiverId, ReceiverAccountNumber: 5})
+		if !errors.Is(err, ErrAccountNotFound) {
+			t.Errorf("want: %v, got: %v", ErrAccountNotFound, err)
+		}
+		err = bank.PayForService(ctx, serviceNumber, 200, senderId, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = bank.PayForService(ctx, ServiceNumber{ServiceId: 2}.String(), 200, senderId, 0)
+		if !errors.Is(err, ErrServiceNotFound) {
+			t.Errorf("want: %v, got: %v", ErrServiceNotFound, err)
+		}
+		err = bank.PayForService(ctx, "12", 200, senderId, 0)
+		if !errors.Is(err, ErrInvalidServiceNumber) {
+			t.Errorf("want: %v, got: %v", ErrInvalidServiceNumber, err)
+		}
+
+		accounts, err := bank.BankAccountsList(ctx, senderId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []BankAccount{{UserId: senderId, AccountId: 0, Balance: 500, Currency: "TJS", MinorUnits: 2}}
+		if !reflect.DeepEqual(accounts, want) {
+			t.Errorf("want: %v, got: %v", want, accounts)
+		}
+
+		for _, address := range []string{"first street", "second street"} {
+			err = bank.AddATM(ctx, defaultAdminId, address)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		atms, err := bank.AtmsList(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(atms, []string{"first street", "second street"}) {
+			t.Errorf("want both ATMs, got: %v", atms)
+		}
+	})
+}
+
+func Test_bankAtomically(t *testing.T) {
+	runOnEveryBank(t, func(t *testing.T, bank Bank) {
+		ctx := context.Background()
+		err := bank.AddClient(ctx, defaultAdminId, Client{Login: "client"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = bank.AddBankAccountToClient(ctx, defaultAdminId, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		errStop := errors.New("stop")
+		err = bank.Atomically(ctx, func(tx Bank) error {
+			_, err := tx.ReplenishBankAccount(ctx, defaultAdminId, 1, 0, 100)
+			if err != nil {
+				return err
+			}
+			err = tx.AddClient(ctx, defaultAdminId, Client{Login: "second"})
+			if err != nil {
+				return err
+			}
+			return errStop
+		})
+		if err != errStop {
+			t.Fatalf("want: %v, got: %v", errStop, err)
+		}
+		accounts, err := bank.BankAccountsList(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(accounts) != 1 || accounts[0].Balance != 0 {
+			t.Errorf("want replenishment rolled back, got: %v", accounts)
+		}
+		available, err := bank.IsLoginAvailable(ctx, "second")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !available {
+			t.Error("want client rolled back")
+		}
+
+		// a failed nested block is undone, what surrounds it stays
+		err = bank.Atomically(ctx, func(tx Bank) error {
+			_, err := tx.ReplenishBankAccount(ctx, defaultAdminId, 1, 0, 100)
+			if err != nil {
+				return err
+			}
+			err = tx.Atomically(ctx, func(tx Bank) error {
+				_, err := tx.ReplenishBankAccount(ctx, defaultAdminId, 1, 0, 50)
+				if err != nil {
+					return err
+				}
+				return errStop
+			})
+			if err != errStop {
+				t.Errorf("want: %v, got: %v", errStop, err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		accounts, err = bank.BankAccountsList(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(accounts) != 1 || accounts[0].Balance != 100 {
+			t.Errorf("want only the nested replenishment rolled back, got: %v", accounts)
+		}
+	})
+}
+
+func Test_memoryStoreConcurrentTransfers(t *testing.T) {
+	ctx := context.Background()
+	bank := NewMemoryStore()
+	for _, login := range []string{"first", "second"} {
+		err := bank.AddClient(ctx, defaultAdminId, Client{Login: login})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []int64{1, 2} {
+		err := bank.AddBankAccountToClient(ctx, defaultAdminId, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := bank.ReplenishBankAccount(ctx, defaultAdminId, 1, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 150; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = bank.TransferToClient(ctx, MoneyTransfer{Amount: 1, SenderId: 1, ReceiverId: 2})
+		}()
+	}
+	wg.Wait()
+
+	for id, want := range map[int64]int64{1: 0, 2: 100} {
+		accounts, err := bank.BankAccountsList(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accounts[0].Balance != want {
+			t.Errorf("client %d: want: %d, got: %d", id, want, accounts[0].Balance)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+)
+
+// Migration moves the schema from Version-1 to Version and back. The
+// checksum of every applied migration is stored, so a migration must never
+// be edited once released: a schema change is always a new migration.
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// LatestVersion as a Migrate target applies every known migration.
+const LatestVersion = -1
+
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		// IF NOT EXISTS lets databases made before migrations adopt it
+		Up: []string{
+			managersDDL,
+			clientsDDL,
+			bankAccountsDDL,
+			bankAccountsServicesDDL,
+			servicesDDL,
+			atmsDDL,
+			sessionsDDL,
+			loginAttemptsDDL,
+			rolesDDL,
+			permissionsDDL,
+			rolePermissionsDDL,
+			managerRolesDDL,
+			journalTransactionsDDL,
+			journalPostingsDDL,
+			journalImmutableDDL,
+			idempotencyKeysDDL,
+		},
+		Down: []string{dropInitialSchemaSQL},
+	},
+	{
+		Version: 2,
+		Name:    "account integrity",
+		// fails on duplicate account numbers, IntegrityCheck finds them
+		Up:   []string{accountNumbersUniqueDDL, accountsIntegrityDDL},
+		Down: []string{dropAccountsIntegritySQL, dropAccountNumbersUniqueSQL},
+	},
+	{
+		Version: 3,
+		Name:    "account sequences",
+		Up:      []string{accountSequencesDDL, fillAccountSequencesSQL},
+		Down:    []string{dropAccountSequencesSQL},
+	},
+	{
+		Version: 4,
+		Name:    "account identifiers",
+		// Init gives identifiers to the accounts that exist
+		Up:   []string{accountIdentifiersDDL},
+		Down: []string{dropAccountIdentifiersSQL},
+	},
+	{
+		Version: 5,
+		Name:    "account currencies",
+		// the accounts that exist are in DefaultCurrency
+		Up:   []string{accountCurrenciesDDL, fillAccountCurrenciesSQL},
+		Down: []string{dropAccountCurrenciesSQL},
+	},
+	{
+		Version: 6,
+		Name:    "exchange rates",
+		Up:      []string{exchangeRatesDDL, fxConversionsDDL},
+		Down:    []string{dropExchangeRatesSQL},
+	},
+	{
+		Version: 7,
+		Name:    "atm operations",
+		Up:      []string{atmOperationsDDL},
+		Down:    []string{dropAtmOperationsSQL},
+	},
+	{
+		Version: 8,
+		Name:    "atm cassettes",
+		Up:      []string{atmCassettesDDL},
+		Down:    []string{dropAtmCassettesSQL},
+	},
+	{
+		Version: 9,
+		Name:    "atm details",
+		// ATMs without a row have the defaults of Atm
+		Up:   []string{atmDetailsDDL},
+		Down: []string{dropAtmDetailsSQL},
+	},
+	{
+		Version: 10,
+		Name:    "atm lifecycle",
+		Up:      []string{atmLifecycleDDL},
+		Down:    []string{dropAtmLifecycleSQL},
+	},
+	{
+		Version: 11,
+		Name:    "cards",
+		Up:      []string{cardsDDL},
+		Down:    []string{dropCardsSQL},
+	},
+}
+
+func (receiver Migration) checksum() string {
+	hash := sha256.New()
+	for _, statement := range receiver.Up {
+		hash.Write([]byte(statement))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func Migrate(ctx context.Context, db *sql.DB, target int) error {
+	return NewStore(db).Migrate(ctx, target)
+}
+
+// Migrate applies or rolls back migrations until the schema is at target,
+// each migration in its own transaction. Before anything runs it checks
+// that the applied migrations are the ones this build knows.
+func (receiver *Store) Migrate(ctx context.Context, target int) error {
+	latest := migrations[len(migrations)-1].Version
+	if target == LatestVersion {
+		target = latest
+	}
+	if target < 0 || target > latest {
+		return fmt.Errorf("%w: target %d", ErrUnknownMigration, target)
+	}
+
+	err := receiver.createSchemaMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	applied, err := receiver.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if migration.Version > target || applied[migration.Version] {
+			continue
+		}
+		err = receiver.runMigration(ctx, migration, true)
+		if err != nil {
+			return err
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if migration.Version <= target || !applied[migration.Version] {
+			continue
+		}
+		err = receiver.runMigration(ctx, migration, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the last applied migration.
+func (receiver *Store) SchemaVersion(ctx context.Context) (int, error) {
+	err := receiver.createSchemaMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	applied, err := receiver.appliedMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for appliedVersion := range applied {
+		if appliedVersion > version {
+			version = appliedVersion
+		}
+	}
+	return version, nil
+}
+
+func (receiver *Store) createSchemaMigrations(ctx context.Context) error {
+	statement := receiver.dialect.ddl(schemaMigrationsDDL)
+	_, err := receiver.conn().ExecContext(ctx, statement)
+	if err != nil {
+		return queryError(statement, err)
+	}
+	return nil
+}
+
+func (receiver *Store) appliedMigrations(ctx context.Context) (map[int]bool, error) {
+	rows, err := receiver.conn().QueryContext(ctx, getAppliedMigrationsSQL)
+	if err != nil {
+		return nil, queryError(getAppliedMigrationsSQL, err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		var name, checksum string
+		err = rows.Scan(&version, &name, &checksum)
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := findMigration(version)
+		if !ok {
+			return nil, fmt.Errorf("%w: %04d %s", ErrUnknownMigration, version, name)
+		}
+		if migration.checksum() != checksum {
+			return nil, fmt.Errorf("%w: %04d %s", ErrMigrationChecksum, version, name)
+		}
+		applied[version] = true
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+func findMigration(version int) (Migration, bool) {
+	for _, migration := range migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+func (receiver *Store) runMigration(ctx context.Context, migration Migration, up bool) error {
+	statements := migration.Down
+	if up {
+		statements = migration.Up
+	}
+	err := receiver.InTx(ctx, func(tx *Store) error {
+		for _, statement := range statements {
+			// checksums cover the statements as written, before the dialect
+			statement = tx.dialect.ddl(statement)
+			_, err := tx.conn().ExecContext(ctx, statement)
+			if err != nil {
+				return queryError(statement, err)
+			}
+		}
+
+		if !up {
+			_, err := tx.conn().ExecContext(ctx, deleteSchemaMigrationSQL, migration.Version)
+			if err != nil {
+				return queryError(deleteSchemaMigrationSQL, err)
+			}
+			return nil
+		}
+		_, err := tx.conn().ExecContext(ctx, insertSchemaMigrationSQL,
+			sql.Named("version", migration.Version),
+			sql.Named("name", migration.Name),
+			sql.Named("checksum", migration.checksum()),
+			sql.Named("applied_at", now().Unix()),
+		)
+		if err != nil {
+			return queryError(insertSchemaMigrationSQL, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("migration %04d %s: %w", migration.Version, migration.Name, err)
+	}
+	return nil
+}
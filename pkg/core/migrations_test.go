@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_migrationsAreOrdered(t *testing.T) {
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("want version %d, got: %d %s", i+1, migration.Version, migration.Name)
+		}
+		if len(migration.Up) == 0 || len(migration.Down) == 0 {
+			t.Errorf("%04d %s: want up and down statements", migration.Version, migration.Name)
+		}
+	}
+}
+
+func Test_migrateUpAndDown(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	store := NewStore(db)
+	latest := migrations[len(migrations)-1].Version
+	for i := 0; i < 2; i++ {
+		err := Migrate(ctx, db, LatestVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	version, err := store.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latest {
+		t.Errorf("want: %v, got: %v", latest, version)
+	}
+
+	err = Migrate(ctx, db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err = store.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Errorf("want: %v, got: %v", 0, version)
+	}
+	_, err = db.Exec(`SELECT count(*) FROM clients`)
+	if err == nil {
+		t.Error("want clients table to be dropped")
+	}
+
+	err = Migrate(ctx, db, latest+1)
+	if !errors.Is(err, ErrUnknownMigration) {
+		t.Errorf("want: %v, got: %v", ErrUnknownMigration, err)
+	}
+
+	err = Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := LoginForManager("admin", defaultAdminPassword, db)
+	if err != nil || !ok {
+		t.Errorf("want admin to log in, got: %v, %v", ok, err)
+	}
+}
+
+func Test_migrateVerifiesApplied(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	err := Migrate(ctx, db, LatestVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(`UPDATE schema_migrations SET checksum = 'edited' WHERE version = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Migrate(ctx, db, LatestVersion)
+	if !errors.Is(err, ErrMigrationChecksum) {
+		t.Errorf("want: %v, got: %v", ErrMigrationChecksum, err)
+	}
+	_, err = db.Exec(`UPDATE schema_migrations SET checksum = ? WHERE version = 1`,
+		migrations[0].checksum())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(`INSERT INTO schema_migrations VALUES (9999, 'from the future', '', 0)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Init(db)
+	if !errors.Is(err, ErrUnknownMigration) {
+		t.Errorf("want: %v, got: %v", ErrUnknownMigration, err)
+	}
+}
+
+func Test_migrateAdoptsDatabaseWithoutMigrations(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	for _, ddl := range []string{managersDDL, clientsDDL} {
+		_, err := db.Exec(ddl)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := db.Exec(`INSERT INTO clients (login, password, name, phone)
+VALUES ('old', '', '', '')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := GetClientIdByLogin("old", db)
+	if err != nil || id != 1 {
+		t.Errorf("want: 1, got: %v, %v", id, err)
+	}
+}
@@ -0,0 +1,298 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PasswordHasher produces self-describing encoded hashes: the scheme, its
+// parameters and the per-user salt are all stored in the encoded string.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(encoded, password string) (bool, error)
+	// Recognizes reports whether encoded was produced by this scheme.
+	Recognizes(encoded string) bool
+	// NeedsRehash reports whether encoded uses other parameters than the hasher.
+	NeedsRehash(encoded string) bool
+}
+
+var (
+	passwordHasher PasswordHasher = NewBcryptHasher()
+	knownHashers                  = []PasswordHasher{
+		NewBcryptHasher(),
+		NewArgon2idHasher(),
+		NewScryptHasher(),
+	}
+)
+
+// SetPasswordHasher changes the scheme used for new and re-hashed passwords.
+// Hashes of the other known schemes are still accepted on login.
+func SetPasswordHasher(hasher PasswordHasher) {
+	passwordHasher = hasher
+}
+
+func hashPassword(password string) (string, error) {
+	return passwordHasher.Hash(password)
+}
+
+// verifyPassword compares password with the stored value. Values that no
+// known scheme recognizes are legacy plaintext rows and always need rehash.
+func verifyPassword(stored, password string) (ok bool, needsRehash bool, err error) {
+	hasher := findHasher(stored)
+	if hasher == nil {
+		ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+		return ok, ok, nil
+	}
+	ok, err = hasher.Verify(stored, password)
+	if err != nil || !ok {
+		return false, false, err
+	}
+	if !passwordHasher.Recognizes(stored) || passwordHasher.NeedsRehash(stored) {
+		return true, true, nil
+	}
+	return true, false, nil
+}
+
+func findHasher(encoded string) PasswordHasher {
+	if passwordHasher.Recognizes(encoded) {
+		return passwordHasher
+	}
+	for _, hasher := range knownHashers {
+		if hasher.Recognizes(encoded) {
+			return hasher
+		}
+	}
+	return nil
+}
+
+func randomSalt(length int) ([]byte, error) {
+	salt := make([]byte, length)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+var b64 = base64.RawStdEncoding
+
+//---------------bcrypt
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher() *BcryptHasher {
+	return &BcryptHasher{Cost: bcrypt.DefaultCost}
+}
+
+func (receiver *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), receiver.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+func (receiver *BcryptHasher) Verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrMalformedHash, err)
+	}
+	return true, nil
+}
+func (receiver *BcryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+func (receiver *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return true
+	}
+	return cost != receiver.Cost
+}
+
+//---------------argon2id
+// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>
+
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen int
+}
+
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:    1,
+		Memory:  64 * 1024,
+		Threads: 4,
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+}
+
+const argon2idPrefix = "$argon2id$"
+
+func (receiver *Argon2idHasher) Hash(password string) (string, error) {
+	salt, err := randomSalt(receiver.SaltLen)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt,
+		receiver.Time, receiver.Memory, receiver.Threads, receiver.KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version,
+		receiver.Memory, receiver.Time, receiver.Threads,
+		b64.EncodeToString(salt), b64.EncodeToString(key),
+	), nil
+}
+func (receiver *Argon2idHasher) Verify(encoded, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	got := argon2.IDKey([]byte(password), salt,
+		params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1, nil
+}
+func (receiver *Argon2idHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+func (receiver *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Time != receiver.Time ||
+		params.Memory != receiver.Memory ||
+		params.Threads != receiver.Threads ||
+		uint32(len(key)) != receiver.KeyLen ||
+		len(salt) != receiver.SaltLen
+}
+
+func decodeArgon2id(encoded string) (params Argon2idHasher, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrMalformedHash
+	}
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrMalformedHash
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d",
+		&params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	salt, err = b64.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	key, err = b64.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	return params, salt, key, nil
+}
+
+//---------------scrypt
+// $scrypt$ln=15,r=8,p=1$<salt>$<key>
+
+type ScryptHasher struct {
+	LogN    uint
+	R       int
+	P       int
+	KeyLen  int
+	SaltLen int
+}
+
+func NewScryptHasher() *ScryptHasher {
+	return &ScryptHasher{
+		LogN:    15,
+		R:       8,
+		P:       1,
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+}
+
+const scryptPrefix = "$scrypt$"
+
+func (receiver *ScryptHasher) Hash(password string) (string, error) {
+	salt, err := randomSalt(receiver.SaltLen)
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), salt,
+		1<<receiver.LogN, receiver.R, receiver.P, receiver.KeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%sln=%d,r=%d,p=%d$%s$%s",
+		scryptPrefix, receiver.LogN, receiver.R, receiver.P,
+		b64.EncodeToString(salt), b64.EncodeToString(key),
+	), nil
+}
+func (receiver *ScryptHasher) Verify(encoded, password string) (bool, error) {
+	params, salt, key, err := decodeScrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+	got, err := scrypt.Key([]byte(password), salt,
+		1<<params.LogN, params.R, params.P, len(key))
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrMalformedHash, err)
+	}
+	return subtle.ConstantTimeCompare(got, key) == 1, nil
+}
+func (receiver *ScryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, scryptPrefix)
+}
+func (receiver *ScryptHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeScrypt(encoded)
+	if err != nil {
+		return true
+	}
+	return params.LogN != receiver.LogN ||
+		params.R != receiver.R ||
+		params.P != receiver.P ||
+		len(key) != receiver.KeyLen ||
+		len(salt) != receiver.SaltLen
+}
+
+func decodeScrypt(encoded string) (params ScryptHasher, salt, key []byte, err error) {
+	// "", "scrypt", "ln=..,r=..,p=..", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return params, nil, nil, ErrMalformedHash
+	}
+	_, err = fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d",
+		&params.LogN, &params.R, &params.P)
+	if err != nil || params.LogN == 0 || params.LogN > 30 {
+		return params, nil, nil, ErrMalformedHash
+	}
+	salt, err = b64.DecodeString(parts[3])
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	key, err = b64.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMain(m *testing.M) {
+	// default cost makes every login in the tests slow
+	SetPasswordHasher(&BcryptHasher{Cost: bcrypt.MinCost})
+	code := m.Run()
+	stopLocalPostgres()
+	os.Exit(code)
+}
+
+func Test_passwordHashers(t *testing.T) {
+	hashers := []PasswordHasher{
+		&BcryptHasher{Cost: 4},
+		&Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16},
+		&ScryptHasher{LogN: 10, R: 8, P: 1, KeyLen: 32, SaltLen: 16},
+	}
+	for _, hasher := range hashers {
+		encoded, err := hasher.Hash("secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hasher.Recognizes(encoded) {
+			t.Errorf("%T doesn't recognize its own hash %v", hasher, encoded)
+		}
+		if hasher.NeedsRehash(encoded) {
+			t.Errorf("%T wants to rehash its own hash %v", hasher, encoded)
+		}
+
+		ok, err := hasher.Verify(encoded, "secret")
+		if err != nil {
+			t.Error(err)
+		}
+		if !ok {
+			t.Errorf("%T: want true, got false", hasher)
+		}
+
+		ok, err = hasher.Verify(encoded, "not-secret")
+		if err != nil {
+			t.Error(err)
+		}
+		if ok {
+			t.Errorf("%T: want false, got true", hasher)
+		}
+
+		again, err := hasher.Hash("secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again == encoded {
+			t.Errorf("%T: want different salts, got same hash twice", hasher)
+		}
+	}
+}
+
+func Test_verifyPasswordLegacyPlaintext(t *testing.T) {
+	ok, needsRehash, err := verifyPassword("plain", "plain")
+	if err != nil {
+		t.Error(err)
+	}
+	if !ok || !needsRehash {
+		t.Errorf("want: true true, got: %v %v", ok, needsRehash)
+	}
+
+	ok, needsRehash, err = verifyPassword("plain", "other")
+	if err != nil {
+		t.Error(err)
+	}
+	if ok || needsRehash {
+		t.Errorf("want: false false, got: %v %v", ok, needsRehash)
+	}
+}
+
+func Test_loginRehashesLegacyPassword(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	_, err := db.Exec(clientsDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(loginAttemptsDDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO clients (login, password, name, phone)
+VALUES ('legacy', 'plain-password', '', '')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := LoginForClient("legacy", "plain-password", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("want: true, got false")
+	}
+
+	var stored string
+	err = db.QueryRow(getClientPasswordByLoginSQL, "legacy").Scan(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == "plain-password" || !passwordHasher.Recognizes(stored) {
+		t.Errorf("want rehashed password, got: %v", stored)
+	}
+
+	ok, err = LoginForClient("legacy", "plain-password", db)
+	if err != nil {
+		t.Error(err)
+	}
+	if !ok {
+		t.Error("want: true, got false")
+	}
+}
+
+func Test_initHashesAdminPassword(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored string
+	err = db.QueryRow(getManagerPasswordByLoginSQL, "admin").Scan(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == defaultAdminPassword {
+		t.Error("want hashed admin password, got plaintext")
+	}
+
+	ok, err := LoginForManager("admin", defaultAdminPassword, db)
+	if err != nil {
+		t.Error(err)
+	}
+	if !ok {
+		t.Error("want: true, got false")
+	}
+}
@@ -0,0 +1,440 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type ManagerRole string
+
+const (
+	RoleAdmin    ManagerRole = "admin"
+	RoleOperator ManagerRole = "operator"
+	RoleTeller   ManagerRole = "teller"
+	RoleAuditor  ManagerRole = "auditor"
+)
+
+type Permission string
+
+const (
+	PermissionClientsRead       Permission = "clients.read"
+	PermissionClientsWrite      Permission = "clients.write"
+	PermissionAccountsWrite     Permission = "accounts.write"
+	PermissionAccountsReplenish Permission = "accounts.replenish"
+	PermissionServicesWrite     Permission = "services.write"
+	PermissionAtmsWrite         Permission = "atms.write"
+	PermissionAtmsRead          Permission = "atms.read"
+	PermissionManagersWrite     Permission = "managers.write"
+	PermissionLoginsUnlock      Permission = "logins.unlock"
+	PermissionDataImport        Permission = "data.import"
+	PermissionDataExport        Permission = "data.export"
+	PermissionRatesWrite        Permission = "rates.write"
+	PermissionCardsWrite        Permission = "cards.write"
+)
+
+var allPermissions = []Permission{
+	PermissionClientsRead,
+	PermissionClientsWrite,
+	PermissionAccountsWrite,
+	PermissionAccountsReplenish,
+	PermissionServicesWrite,
+	PermissionAtmsWrite,
+	PermissionAtmsRead,
+	PermissionManagersWrite,
+	PermissionLoginsUnlock,
+	PermissionDataImport,
+	PermissionDataExport,
+	PermissionRatesWrite,
+	PermissionCardsWrite,
+}
+
+var rolePermissions = map[ManagerRole][]Permission{
+	RoleAdmin: allPermissions,
+	RoleOperator: {
+		PermissionClientsRead,
+		PermissionClientsWrite,
+		PermissionAccountsWrite,
+		PermissionServicesWrite,
+		PermissionAtmsWrite,
+		PermissionAtmsRead,
+		PermissionLoginsUnlock,
+		PermissionDataImport,
+		PermissionDataExport,
+		PermissionRatesWrite,
+		PermissionCardsWrite,
+	},
+	RoleTeller: {
+		PermissionClientsRead,
+		PermissionClientsWrite,
+		PermissionAccountsWrite,
+		PermissionAccountsReplenish,
+		PermissionCardsWrite,
+	},
+	RoleAuditor: {
+		PermissionClientsRead,
+		PermissionAtmsRead,
+		PermissionDataExport,
+	},
+}
+
+func (receiver *Store) initRoles(ctx context.Context) error {
+	for _, permission := range allPermissions {
+		_, err := receiver.conn().ExecContext(ctx, insertPermissionSQL, string(permission))
+		if err != nil {
+			return queryError(insertPermissionSQL, err)
+		}
+	}
+	for role, permissions := range rolePermissions {
+		_, err := receiver.conn().ExecContext(ctx, insertRoleSQL, string(role))
+		if err != nil {
+			return queryError(insertRoleSQL, err)
+		}
+		for _, permission := range permissions {
+			_, err = receiver.conn().ExecContext(ctx, insertRolePermissionSQL,
+				sql.Named("role", string(role)),
+				sql.Named("permission", string(permission)),
+			)
+			if err != nil {
+				return queryError(insertRolePermissionSQL, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (receiver *Store) Authorize(ctx context.Context, managerId int64, permission Permission) error {
+	var count int
+	err := receiver.conn().QueryRowContext(ctx, countManagerPermissionSQL,
+		sql.Named("manager_id", managerId),
+		sql.Named("permission", string(permission)),
+	).Scan(&count)
+	if err != nil {
+		return queryError(countManagerPermissionSQL, err)
+	}
+	if count == 0 {
+		return fmt.Errorf("%w: manager %d has no %s", ErrPermissionDenied, managerId, permission)
+	}
+	return nil
+}
+
+// GrantRole and RevokeRole change the roles of granteeId, managerId is the
+// manager who does it.
+func (receiver *Store) GrantRole(ctx context.Context, managerId, granteeId int64, role ManagerRole) error {
+	err := receiver.Authorize(ctx, managerId, PermissionManagersWrite)
+	if err != nil {
+		return err
+	}
+	return receiver.grantRole(ctx, granteeId, role)
+}
+func (receiver *Store) grantRole(ctx context.Context, managerId int64, role ManagerRole) error {
+	err := receiver.checkRoleAndManager(ctx, managerId, role)
+	if err != nil {
+		return err
+	}
+	_, err = receiver.conn().ExecContext(ctx, grantRoleToManagerSQL,
+		sql.Named("manager_id", managerId),
+		sql.Named("role", string(role)),
+	)
+	if err != nil {
+		return queryError(grantRoleToManagerSQL, err)
+	}
+	return nil
+}
+func (receiver *Store) RevokeRole(ctx context.Context, managerId, granteeId int64, role ManagerRole) error {
+	err := receiver.Authorize(ctx, managerId, PermissionManagersWrite)
+	if err != nil {
+		return err
+	}
+	err = receiver.checkRoleAndManager(ctx, granteeId, role)
+	if err != nil {
+		return err
+	}
+	_, err = receiver.conn().ExecContext(ctx, revokeRoleFromManagerSQL,
+		sql.Named("manager_id", granteeId),
+		sql.Named("role", string(role)),
+	)
+	if err != nil {
+		return queryError(revokeRoleFromManagerSQL, err)
+	}
+	return nil
+}
+func (receiver *Store) checkRoleAndManager(ctx context.Context,
+	managerId int64, role ManagerRole) error {
+
+	var id int64
+	err := receiver.conn().QueryRowContext(ctx, getRoleIdByNameSQL, string(role)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", ErrUnknownRole, role)
+	}
+	if err != nil {
+		return queryError(getRoleIdByNameSQL, err)
+	}
+	err = receiver.conn().QueryRowContext(ctx, getManagerIdByIdSQL, managerId).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %d", ErrManagerNotFound, managerId)
+	}
+	if err != nil {
+		return queryError(getManagerIdByIdSQL, err)
+	}
+	return nil
+}
+
+func (receiver *Store) ManagerRoles(ctx context.Context, managerId int64) ([]ManagerRole, error) {
+	rows, err := receiver.conn().QueryContext(ctx, getManagerRolesSQL, managerId)
+	if err != nil {
+		return nil, queryError(getManagerRolesSQL, err)
+	}
+	defer rows.Close()
+
+	roles := make([]ManagerRole, 0)
+	var role string
+	for rows.Next() {
+		err = rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, ManagerRole(role))
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// ManagerActions runs manager operations on behalf of one manager, checking
+// the manager's permissions before each call; Store methods that take the
+// acting managerId check it themselves. It lives as long as the request it
+// was made for and uses that request's context.
+type ManagerActions struct {
+	managerId int64
+	store     *Store
+	ctx       context.Context
+}
+
+func (receiver *Store) AsManager(ctx context.Context, managerId int64) *ManagerActions {
+	return &ManagerActions{managerId: managerId, store: receiver, ctx: ctx}
+}
+
+// AsManagerWithSession takes the acting manager from a session token.
+func (receiver *Store) AsManagerWithSession(ctx context.Context, token string) (*ManagerActions, error) {
+	principal, err := receiver.ValidateSession(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if principal.Role != RoleManager {
+		return nil, fmt.Errorf("%w: %s is not a manager", ErrPermissionDenied, principal.Login)
+	}
+	return receiver.AsManager(ctx, principal.Id), nil
+}
+
+func (receiver *ManagerActions) authorize(permission Permission) error {
+	return receiver.store.Authorize(receiver.ctx, receiver.managerId, permission)
+}
+
+func (receiver *ManagerActions) AddClient(client Client) error {
+	return receiver.store.AddClient(receiver.ctx, receiver.managerId, client)
+}
+func (receiver *ManagerActions) AddManager(manager Manager) error {
+	return receiver.store.AddManager(receiver.ctx, receiver.managerId, manager)
+}
+func (receiver *ManagerActions) AddService(service Service) (string, error) {
+	return receiver.store.AddService(receiver.ctx, receiver.managerId, service)
+}
+func (receiver *ManagerActions) AddATM(address string) error {
+	return receiver.store.AddATM(receiver.ctx, receiver.managerId, address)
+}
+func (receiver *ManagerActions) CreateATM(atm Atm) (Atm, error) {
+	return receiver.store.CreateATM(receiver.ctx, receiver.managerId, atm)
+}
+func (receiver *ManagerActions) UpdateATM(atm Atm) (Atm, error) {
+	return receiver.store.UpdateATM(receiver.ctx, receiver.managerId, atm)
+}
+func (receiver *ManagerActions) SetAtmStatus(atmId int64, status AtmStatus, reason string) (Atm, error) {
+	return receiver.store.SetAtmStatus(receiver.ctx, receiver.managerId, atmId, status, reason)
+}
+func (receiver *ManagerActions) DeleteATM(atmId int64, reason string) error {
+	return receiver.store.DeleteATM(receiver.ctx, receiver.managerId, atmId, reason)
+}
+func (receiver *ManagerActions) GetATM(atmId int64) (Atm, error) {
+	err := receiver.authorize(PermissionAtmsRead)
+	if err != nil {
+		return Atm{}, err
+	}
+	return receiver.store.GetATM(receiver.ctx, atmId)
+}
+func (receiver *ManagerActions) ListATMs() ([]Atm, error) {
+	err := receiver.authorize(PermissionAtmsRead)
+	if err != nil {
+		return nil, err
+	}
+	return receiver.store.ListATMs(receiver.ctx)
+}
+func (receiver *ManagerActions) AtmHistory(atmId int64) ([]AtmChange, error) {
+	err := receiver.authorize(PermissionAtmsRead)
+	if err != nil {
+		return nil, err
+	}
+	return receiver.store.AtmHistory(receiver.ctx, atmId)
+}
+func (receiver *ManagerActions) AtmOperations(atmId int64, from, to time.Time) ([]AtmOperation, error) {
+	err := receiver.authorize(PermissionAtmsRead)
+	if err != nil {
+		return nil, err
+	}
+	return receiver.store.AtmOperations(receiver.ctx, atmId, from, to)
+}
+func (receiver *ManagerActions) ReconcileAtm(atmId int64, from, to time.Time) (AtmReconciliation, error) {
+	err := receiver.authorize(PermissionAtmsRead)
+	if err != nil {
+		return AtmReconciliation{}, err
+	}
+	return receiver.store.ReconcileAtm(receiver.ctx, atmId, from, to)
+}
+func (receiver *ManagerActions) LoadCassette(atmId, denomination, count int64) error {
+	return receiver.store.LoadCassette(receiver.ctx, receiver.managerId, atmId, denomination, count)
+}
+func (receiver *ManagerActions) UnloadCassette(atmId, denomination, count int64) error {
+	return receiver.store.UnloadCassette(receiver.ctx, receiver.managerId, atmId, denomination, count)
+}
+func (receiver *ManagerActions) SetLowCashThreshold(atmId, denomination, threshold int64) error {
+	return receiver.store.SetLowCashThreshold(receiver.ctx, receiver.managerId, atmId, denomination, threshold)
+}
+func (receiver *ManagerActions) AtmCassettes(atmId int64) ([]Cassette, error) {
+	err := receiver.authorize(PermissionAtmsRead)
+	if err != nil {
+		return nil, err
+	}
+	return receiver.store.AtmCassettes(receiver.ctx, atmId)
+}
+func (receiver *ManagerActions) LowCashAlerts() ([]Cassette, error) {
+	err := receiver.authorize(PermissionAtmsRead)
+	if err != nil {
+		return nil, err
+	}
+	return receiver.store.LowCashAlerts(receiver.ctx)
+}
+func (receiver *ManagerActions) AddBankAccountToClient(id int64) error {
+	return receiver.store.AddBankAccountToClient(receiver.ctx, receiver.managerId, id)
+}
+func (receiver *ManagerActions) AddBankAccountToService(id int64) error {
+	return receiver.store.AddBankAccountToService(receiver.ctx, receiver.managerId, id)
+}
+func (receiver *ManagerActions) ReplenishBankAccount(clientId, accountNumber, amount int64) (Replenishment, error) {
+	return receiver.store.ReplenishBankAccount(receiver.ctx, receiver.managerId, clientId, accountNumber, amount)
+}
+func (receiver *ManagerActions) IssueCard(clientId, accountNumber int64, pin string) (Card, error) {
+	return receiver.store.IssueCard(receiver.ctx, receiver.managerId, clientId, accountNumber, pin)
+}
+func (receiver *ManagerActions) ReissueCard(cardId int64) (Card, error) {
+	return receiver.store.ReissueCard(receiver.ctx, receiver.managerId, cardId)
+}
+func (receiver *ManagerActions) BlockCard(cardId int64, reason string) error {
+	return receiver.store.BlockCard(receiver.ctx, receiver.managerId, cardId, reason)
+}
+func (receiver *ManagerActions) UnblockCard(cardId int64) error {
+	return receiver.store.UnblockCard(receiver.ctx, receiver.managerId, cardId)
+}
+func (receiver *ManagerActions) ListClientCards(clientId int64) ([]Card, error) {
+	err := receiver.authorize(PermissionClientsRead)
+	if err != nil {
+		return nil, err
+	}
+	return receiver.store.ListClientCards(receiver.ctx, clientId)
+}
+func (receiver *ManagerActions) GetClientIdByLogin(login string) (int64, error) {
+	err := receiver.authorize(PermissionClientsRead)
+	if err != nil {
+		return 0, err
+	}
+	return receiver.store.GetClientIdByLogin(receiver.ctx, login)
+}
+func (receiver *ManagerActions) GetClientIdByPhoneNumber(phone string) (int64, error) {
+	err := receiver.authorize(PermissionClientsRead)
+	if err != nil {
+		return 0, err
+	}
+	return receiver.store.GetClientIdByPhoneNumber(receiver.ctx, phone)
+}
+func (receiver *ManagerActions) BankAccountsList(clientId int64) ([]BankAccount, error) {
+	err := receiver.authorize(PermissionClientsRead)
+	if err != nil {
+		return nil, err
+	}
+	return receiver.store.BankAccountsList(receiver.ctx, clientId)
+}
+
+func (receiver *ManagerActions) UnlockLogin(login string) error {
+	return receiver.store.UnlockLogin(receiver.ctx, receiver.managerId, login)
+}
+func (receiver *ManagerActions) UnlockSource(source string) error {
+	return receiver.store.UnlockSource(receiver.ctx, receiver.managerId, source)
+}
+func (receiver *ManagerActions) RevokeAllSessions(login string, role Role) error {
+	return receiver.store.RevokeAllSessions(receiver.ctx, receiver.managerId, login, role)
+}
+
+func (receiver *ManagerActions) GrantRole(managerId int64, role ManagerRole) error {
+	return receiver.store.GrantRole(receiver.ctx, receiver.managerId, managerId, role)
+}
+func (receiver *ManagerActions) RevokeRole(managerId int64, role ManagerRole) error {
+	return receiver.store.RevokeRole(receiver.ctx, receiver.managerId, managerId, role)
+}
+
+func (receiver *ManagerActions) UploadExchangeRates(rates []ExchangeRate) error {
+	return receiver.store.UploadExchangeRates(receiver.ctx, receiver.managerId, rates)
+}
+
+//Import
+
+func (receiver *ManagerActions) ImportClientsFromJSON() error {
+	return receiver.run((*Store).ImportClientsFromJSON)
+}
+func (receiver *ManagerActions) ImportAtmsFromJSON() error {
+	return receiver.run((*Store).ImportAtmsFromJSON)
+}
+func (receiver *ManagerActions) ImportBankAccountsFromJSON() error {
+	return receiver.run((*Store).ImportBankAccountsFromJSON)
+}
+func (receiver *ManagerActions) ImportClientsFromXML() error {
+	return receiver.run((*Store).ImportClientsFromXML)
+}
+func (receiver *ManagerActions) ImportAtmsFromXML() error {
+	return receiver.run((*Store).ImportAtmsFromXML)
+}
+func (receiver *ManagerActions) ImportBankAccountsFromXML() error {
+	return receiver.run((*Store).ImportBankAccountsFromXML)
+}
+func (receiver *ManagerActions) ImportExchangeRatesFromJSON() error {
+	return receiver.run((*Store).ImportExchangeRatesFromJSON)
+}
+func (receiver *ManagerActions) ImportExchangeRatesFromCSV() error {
+	return receiver.run((*Store).ImportExchangeRatesFromCSV)
+}
+
+//Export
+
+func (receiver *ManagerActions) ExportClientsToJSON() error {
+	return receiver.run((*Store).ExportClientsToJSON)
+}
+func (receiver *ManagerActions) ExportAtmsToJSON() error {
+	return receiver.run((*Store).ExportAtmsToJSON)
+}
+func (receiver *ManagerActions) ExportBankAccountsToJSON() error {
+	return receiver.run((*Store).ExportBankAccountsToJSON)
+}
+func (receiver *ManagerActions) ExportClientsToXML() error {
+	return receiver.run((*Store).ExportClientsToXML)
+}
+func (receiver *ManagerActions) ExportAtmsToXML() error {
+	return receiver.run((*Store).ExportAtmsToXML)
+}
+func (receiver *ManagerActions) ExportBankAccountsToXML() error {
+	return receiver.run((*Store).ExportBankAccountsToXML)
+}
+
+func (receiver *ManagerActions) run(action func(*Store, context.Context, int64) error) error {
+	return action(receiver.store, receiver.ctx, receiver.managerId)
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_managerRoles(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roles, err := ManagerRoles(defaultAdminId, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(roles, []ManagerRole{RoleAdmin}) {
+		t.Errorf("want: %v, got: %v", []ManagerRole{RoleAdmin}, roles)
+	}
+
+	admin := AsManager(defaultAdminId, db)
+	err = admin.AddManager(Manager{Login: "auditor", Password: "pass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = admin.GrantRole(2, RoleAuditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = admin.GrantRole(2, "janitor")
+	if !errors.Is(err, ErrUnknownRole) {
+		t.Errorf("want: %v, got: %v", ErrUnknownRole, err)
+	}
+	err = admin.GrantRole(42, RoleTeller)
+	if !errors.Is(err, ErrManagerNotFound) {
+		t.Errorf("want: %v, got: %v", ErrManagerNotFound, err)
+	}
+
+	auditor := AsManager(2, db)
+	err = auditor.ImportClientsFromJSON()
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("want: %v, got: %v", ErrPermissionDenied, err)
+	}
+	_, err = auditor.ReplenishBankAccount(1, 0, 100)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("want: %v, got: %v", ErrPermissionDenied, err)
+	}
+	err = auditor.GrantRole(2, RoleAdmin)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("want: %v, got: %v", ErrPermissionDenied, err)
+	}
+	_, err = auditor.GetClientIdByLogin("nobody")
+	if errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("want auditor to read clients, got: %v", err)
+	}
+
+	err = admin.GrantRole(2, RoleTeller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = auditor.AddClient(Client{Login: "client", Password: "pass"})
+	if err != nil {
+		t.Errorf("want nil error, got: %v", err)
+	}
+
+	err = admin.RevokeRole(2, RoleTeller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = auditor.AddClient(Client{Login: "other", Password: "pass"})
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("want: %v, got: %v", ErrPermissionDenied, err)
+	}
+
+	// the store checks the acting manager too, not only ManagerActions
+	for name, call := range map[string]func() error{
+		"unlock": func() error { return UnlockLogin(2, "client", db) },
+		"replenish": func() error {
+			_, err := ReplenishBankAccount(2, 1, 0, 100, db)
+			return err
+		},
+		"block":  func() error { return BlockCard(2, 1, "", db) },
+		"grant":  func() error { return GrantRole(2, 2, RoleAdmin, db) },
+		"import": func() error { return ImportClientsFromJSON(2, db) },
+	} {
+		err = call()
+		if !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("%s: want: %v, got: %v", name, ErrPermissionDenied, err)
+		}
+	}
+}
+
+func Test_initKeepsRevokedAdminRole(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddManager(defaultAdminId, Manager{Login: "second", Password: "pass"}, db)
+	if err == nil {
+		err = GrantRole(defaultAdminId, 2, RoleAdmin, db)
+	}
+	if err == nil {
+		err = RevokeRole(2, defaultAdminId, RoleAdmin, db)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles, err := ManagerRoles(defaultAdminId, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 0 {
+		t.Errorf("want the revoked role to stay revoked, got: %v", roles)
+	}
+}
+
+func Test_asManagerWithSession(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddClient(defaultAdminId, Client{Login: "client", Password: "pass"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := LoginForClientWithSession("client", "pass", "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = AsManagerWithSession(session.Token, db)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("want: %v, got: %v", ErrPermissionDenied, err)
+	}
+
+	session, err = LoginForManagerWithSession("admin", defaultAdminPassword, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := AsManagerWithSession(session.Token, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = admin.AddATM("Dushanbe")
+	if err != nil {
+		t.Error(err)
+	}
+}
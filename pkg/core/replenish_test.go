@@ -0,0 +1,135 @@
+package core
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// createDBinFile is used where many connections must share one database,
+// every connection to ":memory:" opens a new empty one.
+func createDBinFile(t *testing.T) (*sql.DB, func()) {
+	dir, err := ioutil.TempDir("", "ib-core")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3",
+		"file:"+filepath.Join(dir, "test.db")+"?_busy_timeout=10000&_foreign_keys=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, func() {
+		_ = db.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func Test_replenishBankAccountValidation(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddClient(defaultAdminId, Client{Login: "client"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddBankAccountToClient(defaultAdminId, 1, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, amount := range []int64{0, -10} {
+		_, err = ReplenishBankAccount(defaultAdminId, 1, 0, amount, db)
+		if err != ErrInvalidAmount {
+			t.Errorf("want: %v, got: %v", ErrInvalidAmount, err)
+		}
+	}
+	_, err = ReplenishBankAccount(defaultAdminId, 1, 1, 10, db)
+	if err == nil {
+		t.Error("want not nil error")
+	}
+
+	replenishment, err := ReplenishBankAccount(defaultAdminId, 1, 0, 10, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replenishment.Balance != 10 || replenishment.Amount != 10 ||
+		replenishment.TransactionId == 0 || replenishment.CreatedAt.IsZero() {
+		t.Errorf("unexpected replenishment: %+v", replenishment)
+	}
+	assertBalance(t, 1, 0, 10, db)
+}
+
+func Test_replenishBankAccountConcurrently(t *testing.T) {
+	runOnEveryDialect(t, func(t *testing.T, store *Store) {
+		db := store.db
+
+		err := Init(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = AddClient(defaultAdminId, Client{Login: "client"}, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = AddBankAccountToClient(defaultAdminId, 1, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		const goroutines = 20
+		const replenishments = 25
+		var wg sync.WaitGroup
+		errs := make(chan error, goroutines*replenishments)
+		balances := make(chan int64, goroutines*replenishments)
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(amount int64) {
+				defer wg.Done()
+				for i := 0; i < replenishments; i++ {
+					replenishment, err := ReplenishBankAccount(defaultAdminId, 1, 0, amount, db)
+					if err != nil {
+						errs <- err
+						continue
+					}
+					balances <- replenishment.Balance
+				}
+			}(int64(g + 1))
+		}
+		wg.Wait()
+		close(errs)
+		close(balances)
+
+		for err := range errs {
+			t.Error(err)
+		}
+
+		var want int64
+		for g := int64(1); g <= goroutines; g++ {
+			want += g * replenishments
+		}
+		assertBalance(t, 1, 0, want, db)
+
+		seen := make(map[int64]bool)
+		for balance := range balances {
+			if seen[balance] {
+				t.Errorf("two replenishments saw the same balance %v", balance)
+			}
+			seen[balance] = true
+		}
+
+		report, err := VerifyLedger(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Ok() {
+			t.Errorf("want ok ledger, got: %+v", report)
+		}
+	})
+}
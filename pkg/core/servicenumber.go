@@ -0,0 +1,85 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	serviceIdDigits      = 9
+	serviceAccountDigits = 6
+	serviceNumberLength  = serviceIdDigits + serviceAccountDigits + 1
+	// legacy numbers were given before the check digit: the service id in 9
+	// digits and the account number in 4
+	legacyAccountDigits = 4
+	legacyNumberLength  = serviceIdDigits + legacyAccountDigits
+)
+
+// ServiceNumber is what payers type to pay for a service: 16 digits, the
+// service id in 9, the account number of the service in 6 and a Luhn check
+// digit, for example 0000000420000010 for account 1 of service 42.
+type ServiceNumber struct {
+	ServiceId     int64
+	AccountNumber int64
+}
+
+// NewServiceNumber fails when an id doesn't fit into its digits.
+func NewServiceNumber(serviceId, accountNumber int64) (ServiceNumber, error) {
+	number := ServiceNumber{ServiceId: serviceId, AccountNumber: accountNumber}
+	if serviceId < 0 || len(strconv.FormatInt(serviceId, 10)) > serviceIdDigits ||
+		accountNumber < 0 || len(strconv.FormatInt(accountNumber, 10)) > serviceAccountDigits {
+
+		return ServiceNumber{}, fmt.Errorf("%w: service %d, account %d",
+			ErrInvalidServiceNumber, serviceId, accountNumber)
+	}
+	return number, nil
+}
+
+func (receiver ServiceNumber) String() string {
+	payload := fmt.Sprintf("%0*d%0*d",
+		serviceIdDigits, receiver.ServiceId,
+		serviceAccountDigits, receiver.AccountNumber)
+	return payload + strconv.Itoa(luhnCheckDigit(payload))
+}
+
+// ParseServiceNumber accepts spaces and dashes between the digits, and the
+// legacy 13-digit numbers payers may still have.
+func ParseServiceNumber(serviceNumber string) (ServiceNumber, error) {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(serviceNumber)
+	if len(digits) == legacyNumberLength && isDigits(digits) {
+		serviceId, _ := strconv.ParseInt(digits[:serviceIdDigits], 10, 64)
+		accountNumber, _ := strconv.ParseInt(digits[serviceIdDigits:], 10, 64)
+		return ServiceNumber{ServiceId: serviceId, AccountNumber: accountNumber}, nil
+	}
+	if len(digits) != serviceNumberLength || !isDigits(digits) {
+		return ServiceNumber{}, fmt.Errorf("%w: %q", ErrInvalidServiceNumber, serviceNumber)
+	}
+	payload := digits[:serviceNumberLength-1]
+	if strconv.Itoa(luhnCheckDigit(payload)) != digits[serviceNumberLength-1:] {
+		return ServiceNumber{}, fmt.Errorf("%w: check digit of %q",
+			ErrInvalidServiceNumber, serviceNumber)
+	}
+	serviceId, _ := strconv.ParseInt(payload[:serviceIdDigits], 10, 64)
+	accountNumber, _ := strconv.ParseInt(payload[serviceIdDigits:], 10, 64)
+	return ServiceNumber{ServiceId: serviceId, AccountNumber: accountNumber}, nil
+}
+
+// luhnCheckDigit is the digit that makes payload followed by it pass the
+// Luhn check.
+func luhnCheckDigit(payload string) int {
+	sum := 0
+	double := true
+	for i := len(payload) - 1; i >= 0; i-- {
+		digit := int(payload[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return (10 - sum%10) % 10
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_serviceNumber(t *testing.T) {
+	for _, number := range []ServiceNumber{
+		{ServiceId: 1},
+		{ServiceId: 42, AccountNumber: 1},
+		{ServiceId: 7, AccountNumber: 123456},
+		{ServiceId: 999999999, AccountNumber: 999999},
+	} {
+		parsed, err := ParseServiceNumber(number.String())
+		if err != nil {
+			t.Fatalf("%v: %v", number, err)
+		}
+		if parsed != number {
+			t.Errorf("want: %v, got: %v", number, parsed)
+		}
+	}
+	if got := (ServiceNumber{ServiceId: 42, AccountNumber: 1}).String(); got != "0000000420000010" {
+		t.Errorf("want: 0000000420000010, got: %v", got)
+	}
+	parsed, err := ParseServiceNumber("0000-00042 000001 0")
+	if err != nil || parsed != (ServiceNumber{ServiceId: 42, AccountNumber: 1}) {
+		t.Errorf("want service 42 account 1, got: %v, %v", parsed, err)
+	}
+	// legacy numbers have no check digit and 4 digits for the account
+	parsed, err = ParseServiceNumber("0000000420001")
+	if err != nil || parsed != (ServiceNumber{ServiceId: 42, AccountNumber: 1}) {
+		t.Errorf("want legacy service 42 account 1, got: %v, %v", parsed, err)
+	}
+
+	for _, number := range []string{
+		"", "1", "12", "abc", "000000042000001a",
+		"0000000420000018", "0000000240000010", "00000004200000100", "000000042001",
+	} {
+		_, err = ParseServiceNumber(number)
+		if !errors.Is(err, ErrInvalidServiceNumber) {
+			t.Errorf("%q: want: %v, got: %v", number, ErrInvalidServiceNumber, err)
+		}
+	}
+	for _, ids := range [][2]int64{{-1, 0}, {1, -1}, {1000000000, 0}, {1, 1000000}} {
+		_, err = NewServiceNumber(ids[0], ids[1])
+		if !errors.Is(err, ErrInvalidServiceNumber) {
+			t.Errorf("%v: want: %v, got: %v", ids, ErrInvalidServiceNumber, err)
+		}
+	}
+}
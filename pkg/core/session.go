@@ -0,0 +1,190 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+var (
+	SessionTTL = 30 * time.Minute
+	RefreshTTL = 7 * 24 * time.Hour
+)
+
+var now = time.Now
+
+const tokenBytes = 32
+
+func (receiver *Store) LoginForClientWithSession(ctx context.Context,
+	login, password, source string) (Session, error) {
+
+	return receiver.loginWithSession(ctx, login, password, source, RoleClient, clientCredentials)
+}
+func (receiver *Store) LoginForManagerWithSession(ctx context.Context,
+	login, password, source string) (Session, error) {
+
+	return receiver.loginWithSession(ctx, login, password, source, RoleManager, managerCredentials)
+}
+func (receiver *Store) loginWithSession(ctx context.Context,
+	login, password, source string, role Role, creds credentials) (Session, error) {
+
+	login = normalizeLogin(login)
+	ok, err := receiver.checkPassword(ctx, login, password, source, creds)
+	if err != nil {
+		return Session{}, err
+	}
+	if !ok {
+		return Session{}, ErrInvalidPass
+	}
+
+	var id int64
+	err = receiver.conn().QueryRowContext(ctx, creds.getIdByLogin, login).Scan(&id)
+	if err != nil {
+		return Session{}, queryError(creds.getIdByLogin, err)
+	}
+
+	return receiver.insertSession(ctx, Principal{Id: id, Login: login, Role: role})
+}
+
+func (receiver *Store) insertSession(ctx context.Context, principal Principal) (Session, error) {
+	token, err := newToken()
+	if err != nil {
+		return Session{}, err
+	}
+	refreshToken, err := newToken()
+	if err != nil {
+		return Session{}, err
+	}
+
+	issuedAt := now()
+	session := Session{
+		Principal:        principal,
+		Token:            token,
+		RefreshToken:     refreshToken,
+		ExpiresAt:        issuedAt.Add(SessionTTL),
+		RefreshExpiresAt: issuedAt.Add(RefreshTTL),
+	}
+	_, err = receiver.conn().ExecContext(ctx, insertSessionSQL,
+		sql.Named("token_hash", hashToken(token)),
+		sql.Named("refresh_hash", hashToken(refreshToken)),
+		sql.Named("login", principal.Login),
+		sql.Named("role", string(principal.Role)),
+		sql.Named("principal_id", principal.Id),
+		sql.Named("expires_at", session.ExpiresAt.Unix()),
+		sql.Named("refresh_expires_at", session.RefreshExpiresAt.Unix()),
+	)
+	if err != nil {
+		return Session{}, queryError(insertSessionSQL, err)
+	}
+	return session, nil
+}
+
+func (receiver *Store) ValidateSession(ctx context.Context, token string) (Principal, error) {
+	var principal Principal
+	var role string
+	var expiresAt int64
+	err := receiver.conn().QueryRowContext(ctx,
+		getSessionByTokenHashSQL,
+		hashToken(token),
+	).Scan(&principal.Login, &role, &principal.Id, &expiresAt)
+	if err == sql.ErrNoRows {
+		return Principal{}, ErrInvalidSession
+	}
+	if err != nil {
+		return Principal{}, queryError(getSessionByTokenHashSQL, err)
+	}
+	if !now().Before(time.Unix(expiresAt, 0)) {
+		return Principal{}, ErrSessionExpired
+	}
+	principal.Role = Role(role)
+	return principal, nil
+}
+
+// RefreshSession rotates both tokens: the old pair stops working.
+func (receiver *Store) RefreshSession(ctx context.Context,
+	refreshToken string) (session Session, err error) {
+
+	err = receiver.InTx(ctx, func(tx *Store) error {
+		var id, refreshExpiresAt int64
+		var principal Principal
+		var role string
+		err := tx.conn().QueryRowContext(ctx,
+			getSessionByRefreshHashSQL,
+			hashToken(refreshToken),
+		).Scan(&id, &principal.Login, &role, &principal.Id, &refreshExpiresAt)
+		if err == sql.ErrNoRows {
+			return ErrInvalidSession
+		}
+		if err != nil {
+			return queryError(getSessionByRefreshHashSQL, err)
+		}
+		if !now().Before(time.Unix(refreshExpiresAt, 0)) {
+			return ErrSessionExpired
+		}
+		principal.Role = Role(role)
+
+		_, err = tx.conn().ExecContext(ctx, revokeSessionByIdSQL, id)
+		if err != nil {
+			return queryError(revokeSessionByIdSQL, err)
+		}
+
+		session, err = tx.insertSession(ctx, principal)
+		return err
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+func (receiver *Store) Logout(ctx context.Context, token string) error {
+	_, err := receiver.conn().ExecContext(ctx, revokeSessionByTokenHashSQL, hashToken(token))
+	if err != nil {
+		return queryError(revokeSessionByTokenHashSQL, err)
+	}
+	return nil
+}
+
+func (receiver *Store) RevokeAllSessions(ctx context.Context,
+	managerId int64, login string, role Role) error {
+
+	err := receiver.Authorize(ctx, managerId, PermissionLoginsUnlock)
+	if err != nil {
+		return err
+	}
+	_, err = receiver.conn().ExecContext(ctx, revokeSessionsByLoginAndRoleSQL,
+		sql.Named("login", normalizeLogin(login)),
+		sql.Named("role", string(role)),
+	)
+	if err != nil {
+		return queryError(revokeSessionsByLoginAndRoleSQL, err)
+	}
+	return nil
+}
+
+func (receiver *Store) PurgeExpiredSessions(ctx context.Context) error {
+	_, err := receiver.conn().ExecContext(ctx, deleteExpiredSessionsSQL, now().Unix())
+	if err != nil {
+		return queryError(deleteExpiredSessionsSQL, err)
+	}
+	return nil
+}
+
+func newToken() (string, error) {
+	token := make([]byte, tokenBytes)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// Only hashes of the tokens are stored, so a leaked table can't be replayed.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_sessionLifecycle(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = LoginForManagerWithSession("admin", "wrong", "", db)
+	if err != ErrInvalidPass {
+		t.Errorf("want: %v, got: %v", ErrInvalidPass, err)
+	}
+	_, err = LoginForManagerWithSession("nobody", "wrong", "", db)
+	if err != ErrInvalidPass {
+		t.Errorf("want: %v, got: %v", ErrInvalidPass, err)
+	}
+
+	session, err := LoginForManagerWithSession("admin", defaultAdminPassword, "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	principal, err := ValidateSession(session.Token, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	principalWant := Principal{Id: 1, Login: "admin", Role: RoleManager}
+	if principal != principalWant {
+		t.Errorf("want: %v, got: %v", principalWant, principal)
+	}
+
+	_, err = ValidateSession(session.RefreshToken, db)
+	if err != ErrInvalidSession {
+		t.Errorf("want: %v, got: %v", ErrInvalidSession, err)
+	}
+
+	refreshed, err := RefreshSession(session.RefreshToken, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ValidateSession(session.Token, db)
+	if err != ErrInvalidSession {
+		t.Errorf("want: %v, got: %v", ErrInvalidSession, err)
+	}
+	_, err = RefreshSession(session.RefreshToken, db)
+	if err != ErrInvalidSession {
+		t.Errorf("want: %v, got: %v", ErrInvalidSession, err)
+	}
+	principal, err = ValidateSession(refreshed.Token, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal != principalWant {
+		t.Errorf("want: %v, got: %v", principalWant, principal)
+	}
+
+	err = Logout(refreshed.Token, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ValidateSession(refreshed.Token, db)
+	if err != ErrInvalidSession {
+		t.Errorf("want: %v, got: %v", ErrInvalidSession, err)
+	}
+}
+
+func Test_sessionExpiryAndRevocation(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddClient(defaultAdminId, Client{Login: "client", Password: "pass"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := LoginForClientWithSession("client", "pass", "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := LoginForClientWithSession("client", "pass", "", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Role != RoleClient || first.Id != 1 {
+		t.Errorf("want client 1, got: %v %v", first.Role, first.Id)
+	}
+
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Now().Add(SessionTTL + time.Minute) }
+	_, err = ValidateSession(first.Token, db)
+	if err != ErrSessionExpired {
+		t.Errorf("want: %v, got: %v", ErrSessionExpired, err)
+	}
+	now = time.Now
+
+	err = RevokeAllSessions(defaultAdminId, "client", RoleClient, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, session := range []Session{first, second} {
+		_, err = ValidateSession(session.Token, db)
+		if err != ErrInvalidSession {
+			t.Errorf("want: %v, got: %v", ErrInvalidSession, err)
+		}
+	}
+}
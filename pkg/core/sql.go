@@ -45,10 +45,151 @@ CREATE TABLE IF NOT EXISTS atms
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     address TEXT
 );`
+	sessionsDDL = `
+CREATE TABLE IF NOT EXISTS sessions
+(
+    id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_hash         TEXT    NOT NULL UNIQUE,
+    refresh_hash       TEXT    NOT NULL UNIQUE,
+    login              TEXT    NOT NULL,
+    role               TEXT    NOT NULL,
+    principal_id       INTEGER NOT NULL,
+    expires_at         INTEGER NOT NULL,
+    refresh_expires_at INTEGER NOT NULL,
+    revoked            INTEGER NOT NULL DEFAULT 0
+);`
+	loginAttemptsDDL = `
+CREATE TABLE IF NOT EXISTS login_attempts
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    scope           TEXT    NOT NULL,
+    key             TEXT    NOT NULL,
+    failures        INTEGER NOT NULL DEFAULT 0,
+    last_failure_at INTEGER NOT NULL DEFAULT 0,
+    blocked_until   INTEGER NOT NULL DEFAULT 0,
+    UNIQUE (scope, key)
+);`
+	rolesDDL = `
+CREATE TABLE IF NOT EXISTS roles
+(
+    id   INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE
+);`
+	permissionsDDL = `
+CREATE TABLE IF NOT EXISTS permissions
+(
+    id   INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE
+);`
+	rolePermissionsDDL = `
+CREATE TABLE IF NOT EXISTS role_permissions
+(
+    role_id       INTEGER NOT NULL REFERENCES roles,
+    permission_id INTEGER NOT NULL REFERENCES permissions,
+    PRIMARY KEY (role_id, permission_id)
+);`
+	managerRolesDDL = `
+CREATE TABLE IF NOT EXISTS manager_roles
+(
+    manager_id INTEGER NOT NULL REFERENCES managers,
+    role_id    INTEGER NOT NULL REFERENCES roles,
+    PRIMARY KEY (manager_id, role_id)
+);`
+	journalTransactionsDDL = `
+CREATE TABLE IF NOT EXISTS journal_transactions
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind       TEXT    NOT NULL,
+    created_at INTEGER NOT NULL
+);`
+	journalPostingsDDL = `
+CREATE TABLE IF NOT EXISTS journal_postings
+(
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    transaction_id INTEGER NOT NULL REFERENCES journal_transactions,
+    account_type   TEXT    NOT NULL,
+    account_id     INTEGER NOT NULL,
+    amount         INTEGER NOT NULL
+);`
+	journalImmutableDDL = `
+CREATE TRIGGER IF NOT EXISTS journal_transactions_no_update
+    BEFORE UPDATE ON journal_transactions
+BEGIN
+    SELECT RAISE(ABORT, 'journal is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS journal_transactions_no_delete
+    BEFORE DELETE ON journal_transactions
+BEGIN
+    SELECT RAISE(ABORT, 'journal is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS journal_postings_no_update
+    BEFORE UPDATE ON journal_postings
+BEGIN
+    SELECT RAISE(ABORT, 'journal is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS journal_postings_no_delete
+    BEFORE DELETE ON journal_postings
+BEGIN
+    SELECT RAISE(ABORT, 'journal is append-only');
+END;`
+	idempotencyKeysDDL = `
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    idempotency_key TEXT PRIMARY KEY,
+    operation       TEXT    NOT NULL,
+    request_hash    TEXT    NOT NULL,
+    transaction_id  INTEGER NOT NULL REFERENCES journal_transactions,
+    created_at      INTEGER NOT NULL
+);`
+	schemaMigrationsDDL = `
+CREATE TABLE IF NOT EXISTS schema_migrations
+(
+    version    INTEGER PRIMARY KEY,
+    name       TEXT    NOT NULL,
+    checksum   TEXT    NOT NULL,
+    applied_at INTEGER NOT NULL
+);`
+	dropInitialSchemaSQL = `
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS journal_postings;
+DROP TABLE IF EXISTS journal_transactions;
+DROP TABLE IF EXISTS manager_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS roles;
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS atms;
+DROP TABLE IF EXISTS bank_accounts_services;
+DROP TABLE IF EXISTS services;
+DROP TABLE IF EXISTS bank_accounts;
+DROP TABLE IF EXISTS clients;
+DROP TABLE IF EXISTS managers;`
+
+	postgresJournalImmutableDDL = `
+CREATE OR REPLACE FUNCTION journal_append_only() RETURNS trigger AS
+$$
+BEGIN
+    RAISE EXCEPTION 'journal is append-only';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS journal_transactions_no_change ON journal_transactions;
+CREATE TRIGGER journal_transactions_no_change
+    BEFORE UPDATE OR DELETE ON journal_transactions
+    FOR EACH ROW EXECUTE PROCEDURE journal_append_only();
+DROP TRIGGER IF EXISTS journal_postings_no_change ON journal_postings;
+CREATE TRIGGER journal_postings_no_change
+    BEFORE UPDATE OR DELETE ON journal_postings
+    FOR EACH ROW EXECUTE PROCEDURE journal_append_only();`
+	postgresDropInitialSchemaSQL = dropInitialSchemaSQL + `
+DROP FUNCTION IF EXISTS journal_append_only();`
+	syncIdSequencePostgresSQL = `
+SELECT setval(pg_get_serial_sequence('%[1]s', 'id'),
+              (SELECT coalesce(max(id), 0) + 1 FROM %[1]s), false);`
 
 	managersInitData = `
 INSERT INTO managers 
-VALUES (1, 'admin', 'top-secret')
+VALUES (1, 'admin', :password)
 ON CONFLICT DO NOTHING ;`
 	insertClientWithoutIdSQL = `
 INSERT INTO clients(login, password, name, phone)
@@ -56,16 +197,6 @@ VALUES (:login, :password, :name, :phone);`
 	insertManagerWithoutIdSQL = `
 INSERT INTO managers(login, password)
 VALUES (:login, :password);`
-	getBankAccountsCountByClientIdSQL = `
-SELECT count(ba.id)
-FROM bank_accounts ba
-    JOIN clients c on ba.client_id = ?;
-`
-	getBankAccountsServicesCountByServiceIdSQL = `
-SELECT count(bas.id)
-FROM bank_accounts_services bas
-         JOIN services s on bas.service_id = ?;
-`
 	insertBankAccountToClientSQL = `
 INSERT INTO bank_accounts (client_id, account_number, balance)
 VALUES (:id, :account_number, :balance);`
@@ -75,17 +206,22 @@ VALUES (:id, :account_number, :balance);`
 	getClientIdByLoginSQL = `
 SELECT id
 FROM clients
-WHERE login = ?;`
+WHERE lower(trim(login)) = ?;`
 	insertAtmWithoutIdSQL = `
 INSERT INTO atms(address)
 VALUES (:address);`
 	getAllAtmAddressesSQL = `
 SELECT atms.address
-FROM atms;`
+FROM atms
+WHERE NOT EXISTS(SELECT 1 FROM atm_deletions d WHERE d.atm_id = atms.id)
+ORDER BY atms.id;`
 	getAllBankAccountsWithoutIdSQL = `
-SELECT balance, account_number
-FROM bank_accounts
-WHERE client_id = ?;`
+SELECT ba.balance, ba.account_number, coalesce(ac.currency, 'TJS')
+FROM bank_accounts ba
+         LEFT JOIN account_currencies ac
+                   ON ac.account_type = 'client' AND ac.account_id = ba.id
+WHERE ba.client_id = ?
+ORDER BY ba.account_number;`
 	getAllClientsDataSQL = `
 SELECT *
 FROM clients;`
@@ -94,21 +230,35 @@ INSERT INTO clients
 VALUES (:id, :login, :password, :name, :phone)
 ON CONFLICT DO NOTHING;`
 	getAllAtmDataSQL = `
-SELECT *
-FROM atms;`
+SELECT a.id, coalesce(a.address, ''), ad.latitude, ad.longitude,
+       coalesce(ad.opening_hours, ''), coalesce(ad.status, 'online'),
+       coalesce(ad.cash_in, FALSE), coalesce(ad.currencies, 'TJS')
+FROM atms a
+         LEFT JOIN atm_details ad ON ad.atm_id = a.id
+WHERE NOT EXISTS(SELECT 1 FROM atm_deletions d WHERE d.atm_id = a.id)
+ORDER BY a.id;`
+	getAllAtmsExportSQL = `
+SELECT a.id, coalesce(a.address, ''), ad.latitude, ad.longitude,
+       coalesce(ad.opening_hours, ''), coalesce(ad.status, 'online'),
+       coalesce(ad.cash_in, FALSE), coalesce(ad.currencies, 'TJS'),
+       d.atm_id IS NOT NULL
+FROM atms a
+         LEFT JOIN atm_details ad ON ad.atm_id = a.id
+         LEFT JOIN atm_deletions d ON d.atm_id = a.id
+ORDER BY a.id;`
 	insertAtmSQL = `
 INSERT INTO atms
 VALUES (:id, :address)
 ON CONFLICT DO NOTHING ;`
 	getAllBankAccountsDataSQL = `
-SELECT *
+SELECT id, client_id, account_number, balance
 FROM bank_accounts;`
 	getAllBankAccountsServicesDataSQL = `
-SELECT *
+SELECT id, service_id, account_number, balance
 FROM bank_accounts_services;`
 
 	insertBankAccountSQL = `
-INSERT INTO bank_accounts
+INSERT INTO bank_accounts (id, client_id, account_number, balance)
 VALUES (:id, :client_id, :account_number, :balance)
 ON CONFLICT DO NOTHING ;
 `
@@ -119,12 +269,6 @@ FROM bank_accounts ba
 WHERE ba.client_id = :id
 AND ba.account_number  = :account_number;`
 
-	updateBalanceByClientIdAndAccountNumberSQL = `
-UPDATE bank_accounts
-SET balance = :balance
-WHERE client_id = :id
-  AND account_number = :account_number;`
-
 	getClientIdByPhoneSQL = `
 SELECT c.id
 FROM clients c
@@ -136,31 +280,31 @@ FROM bank_accounts_services bas
 WHERE bas.service_id = :id
   AND bas.account_number  = :account_number;`
 
-	updateBalanceByServiceIdAndAccountNumberSQL = `
+	increaseBalanceByServiceIdAndAccountNumberSQL = `
 UPDATE bank_accounts_services
-SET balance = :balance
+SET balance = balance + :amount
 WHERE service_id = :id
   AND account_number = :account_number;
 `
 	getClientPasswordByLoginSQL = `
 SELECT password
 FROM clients
-WHERE login = ?;`
+WHERE lower(trim(login)) = ?;`
 
 	getManagerPasswordByLoginSQL = `
 SELECT password
 FROM managers
-WHERE login = ?;`
+WHERE lower(trim(login)) = ?;`
 
-	getManagerLoginByLogin = `
-SELECT login
-FROM managers
-WHERE login = ?;`
+	updateClientPasswordByLoginSQL = `
+UPDATE clients
+SET password = :password
+WHERE lower(trim(login)) = :login;`
 
-	getClientLoginByLogin = `
-SELECT login
-FROM clients
-WHERE login = ?;`
+	updateManagerPasswordByLoginSQL = `
+UPDATE managers
+SET password = :password
+WHERE lower(trim(login)) = :login;`
 
 	insertServiceWithoutIdSQL = `
 INSERT INTO services (name)
@@ -176,4 +320,913 @@ WHERE service_id = ?;`
 SELECT account_number
 FROM bank_accounts
 WHERE client_id = ?;`
+
+	getManagerIdByLoginSQL = `
+SELECT id
+FROM managers
+WHERE lower(trim(login)) = ?;`
+
+	insertSessionSQL = `
+INSERT INTO sessions (token_hash, refresh_hash, login, role, principal_id,
+                      expires_at, refresh_expires_at)
+VALUES (:token_hash, :refresh_hash, :login, :role, :principal_id,
+        :expires_at, :refresh_expires_at);`
+
+	getSessionByTokenHashSQL = `
+SELECT login, role, principal_id, expires_at
+FROM sessions
+WHERE token_hash = ?
+  AND revoked = 0;`
+
+	getSessionByRefreshHashSQL = `
+SELECT id, login, role, principal_id, refresh_expires_at
+FROM sessions
+WHERE refresh_hash = ?
+  AND revoked = 0;`
+
+	revokeSessionByIdSQL = `
+UPDATE sessions
+SET revoked = 1
+WHERE id = ?;`
+
+	revokeSessionByTokenHashSQL = `
+UPDATE sessions
+SET revoked = 1
+WHERE token_hash = ?;`
+
+	revokeSessionsByLoginAndRoleSQL = `
+UPDATE sessions
+SET revoked = 1
+WHERE login = :login
+  AND role = :role;`
+
+	deleteExpiredSessionsSQL = `
+DELETE
+FROM sessions
+WHERE revoked = 1
+   OR refresh_expires_at < ?;`
+
+	getLoginAttemptBlockedUntilSQL = `
+SELECT blocked_until
+FROM login_attempts
+WHERE scope = :scope
+  AND key = :key;`
+
+	incrementLoginFailuresSQL = `
+INSERT INTO login_attempts (scope, key, failures, last_failure_at)
+VALUES (:scope, :key, 1, :now)
+ON CONFLICT (scope, key) DO UPDATE
+    SET failures        = CASE
+                              WHEN login_attempts.last_failure_at < :reset_before THEN 1
+                              ELSE login_attempts.failures + 1
+        END,
+        last_failure_at = :now;`
+
+	getLoginFailuresSQL = `
+SELECT failures
+FROM login_attempts
+WHERE scope = :scope
+  AND key = :key;`
+
+	updateLoginAttemptBlockedUntilSQL = `
+UPDATE login_attempts
+SET blocked_until = :blocked_until
+WHERE scope = :scope
+  AND key = :key;`
+
+	deleteLoginAttemptsSQL = `
+DELETE
+FROM login_attempts
+WHERE scope = :scope
+  AND key = :key;`
+
+	insertRoleSQL = `
+INSERT INTO roles (name)
+VALUES (?)
+ON CONFLICT DO NOTHING;`
+
+	insertPermissionSQL = `
+INSERT INTO permissions (name)
+VALUES (?)
+ON CONFLICT DO NOTHING;`
+
+	insertRolePermissionSQL = `
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r,
+     permissions p
+WHERE r.name = :role
+  AND p.name = :permission
+ON CONFLICT DO NOTHING;`
+
+	grantRoleToManagerSQL = `
+INSERT INTO manager_roles (manager_id, role_id)
+SELECT CAST(:manager_id AS BIGINT), r.id
+FROM roles r
+WHERE r.name = :role
+ON CONFLICT DO NOTHING;`
+
+	revokeRoleFromManagerSQL = `
+DELETE
+FROM manager_roles
+WHERE manager_id = :manager_id
+  AND role_id = (SELECT id FROM roles WHERE name = :role);`
+
+	getRoleIdByNameSQL = `
+SELECT id
+FROM roles
+WHERE name = ?;`
+
+	countManagersWithRoleSQL = `
+SELECT count(*)
+FROM manager_roles mr
+         JOIN roles r ON r.id = mr.role_id
+WHERE r.name = ?;`
+
+	getManagerRolesSQL = `
+SELECT r.name
+FROM manager_roles mr
+         JOIN roles r ON r.id = mr.role_id
+WHERE mr.manager_id = ?
+ORDER BY r.name;`
+
+	countManagerPermissionSQL = `
+SELECT count(*)
+FROM manager_roles mr
+         JOIN role_permissions rp ON rp.role_id = mr.role_id
+         JOIN permissions p ON p.id = rp.permission_id
+WHERE mr.manager_id = :manager_id
+  AND p.name = :permission;`
+
+	getManagerIdByIdSQL = `
+SELECT id
+FROM managers
+WHERE id = ?;`
+
+	getBankAccountIdAndBalanceByClientIdAndAccountNumberSQL = `
+SELECT ba.id, ba.balance
+FROM bank_accounts ba
+WHERE ba.client_id = :id
+  AND ba.account_number = :account_number;`
+
+	getBankAccountIdAndBalanceByServiceIdAndAccountNumberSQL = `
+SELECT bas.id, bas.balance
+FROM bank_accounts_services bas
+WHERE bas.service_id = :id
+  AND bas.account_number = :account_number;`
+
+	insertJournalTransactionSQL = `
+INSERT INTO journal_transactions (kind, created_at)
+VALUES (:kind, :created_at);`
+
+	insertJournalPostingSQL = `
+INSERT INTO journal_postings (transaction_id, account_type, account_id, amount)
+VALUES (:transaction_id, :account_type, :account_id, :amount);`
+
+	getDerivedBalanceSQL = `
+SELECT coalesce(sum(amount), 0)
+FROM journal_postings
+WHERE account_type = :account_type
+  AND account_id = :account_id;`
+
+	getUnbalancedJournalTransactionsSQL = `
+SELECT transaction_id, sum(amount)
+FROM journal_postings
+GROUP BY transaction_id
+HAVING sum(amount) != 0
+ORDER BY transaction_id;`
+
+	getClientBalanceMismatchesSQL = `
+SELECT ba.id, ba.balance, coalesce(sum(jp.amount), 0)
+FROM bank_accounts ba
+         LEFT JOIN journal_postings jp
+                   ON jp.account_type = 'client' AND jp.account_id = ba.id
+GROUP BY ba.id, ba.balance
+HAVING ba.balance != coalesce(sum(jp.amount), 0)
+ORDER BY ba.id;`
+
+	getServiceBalanceMismatchesSQL = `
+SELECT bas.id, bas.balance, coalesce(sum(jp.amount), 0)
+FROM bank_accounts_services bas
+         LEFT JOIN journal_postings jp
+                   ON jp.account_type = 'service' AND jp.account_id = bas.id
+GROUP BY bas.id, bas.balance
+HAVING bas.balance != coalesce(sum(jp.amount), 0)
+ORDER BY bas.id;`
+
+	getAccountOperationsSQL = `
+SELECT jt.id,
+       jt.kind,
+       jt.created_at,
+       jp.amount,
+       coalesce(cp.account_type, ''),
+       coalesce(cba.client_id, cbas.service_id, 0),
+       coalesce(cba.account_number, cbas.account_number, 0)
+FROM journal_postings jp
+         JOIN journal_transactions jt ON jt.id = jp.transaction_id
+         LEFT JOIN journal_postings cp
+                   ON cp.transaction_id = jp.transaction_id AND cp.id != jp.id
+                       AND cp.account_type != 'exchange'
+         LEFT JOIN bank_accounts cba
+                   ON cp.account_type = 'client' AND cba.id = cp.account_id
+         LEFT JOIN bank_accounts_services cbas
+                   ON cp.account_type = 'service' AND cbas.id = cp.account_id
+WHERE jp.account_type = :account_type
+  AND jp.account_id = :account_id`
+
+	getBalanceBeforeSQL = `
+SELECT coalesce(sum(jp.amount), 0)
+FROM journal_postings jp
+         JOIN journal_transactions jt ON jt.id = jp.transaction_id
+WHERE jp.account_type = :account_type
+  AND jp.account_id = :account_id
+  AND jt.created_at < :before;`
+
+	getIdempotencyKeySQL = `
+SELECT operation, request_hash, transaction_id
+FROM idempotency_keys
+WHERE idempotency_key = :key
+  AND created_at >= :expired_before;`
+
+	insertIdempotencyKeySQL = `
+INSERT INTO idempotency_keys (idempotency_key, operation, request_hash,
+                              transaction_id, created_at)
+VALUES (:key, :operation, :request_hash, :transaction_id, :created_at)
+ON CONFLICT (idempotency_key) DO UPDATE
+    SET operation      = excluded.operation,
+        request_hash   = excluded.request_hash,
+        transaction_id = excluded.transaction_id,
+        created_at     = excluded.created_at
+WHERE idempotency_keys.created_at < :expired_before;`
+
+	deleteExpiredIdempotencyKeysSQL = `
+DELETE
+FROM idempotency_keys
+WHERE created_at < ?;`
+
+	increaseBalanceByClientIdAndAccountNumberSQL = `
+UPDATE bank_accounts
+SET balance = balance + :amount
+WHERE client_id = :id
+  AND account_number = :account_number;`
+
+	getClientIdByIdSQL = `
+SELECT id
+FROM clients
+WHERE id = ?;`
+
+	getServiceIdByIdSQL = `
+SELECT id
+FROM services
+WHERE id = ?;`
+
+	isLoginTakenSQL = `
+SELECT EXISTS(SELECT 1 FROM clients WHERE lower(trim(login)) = :login)
+    OR EXISTS(SELECT 1 FROM managers WHERE lower(trim(login)) = :login);`
+
+	getAppliedMigrationsSQL = `
+SELECT version, name, checksum
+FROM schema_migrations
+ORDER BY version;`
+
+	insertSchemaMigrationSQL = `
+INSERT INTO schema_migrations (version, name, checksum, applied_at)
+VALUES (:version, :name, :checksum, :applied_at);`
+
+	deleteSchemaMigrationSQL = `
+DELETE FROM schema_migrations
+WHERE version = ?;`
+
+	accountNumbersUniqueDDL = `
+CREATE UNIQUE INDEX IF NOT EXISTS bank_accounts_client_number
+    ON bank_accounts (client_id, account_number);
+CREATE UNIQUE INDEX IF NOT EXISTS bank_accounts_services_service_number
+    ON bank_accounts_services (service_id, account_number);`
+	dropAccountNumbersUniqueSQL = `
+DROP INDEX IF EXISTS bank_accounts_client_number;
+DROP INDEX IF EXISTS bank_accounts_services_service_number;`
+
+	// SQLite checks REFERENCES only on connections that turned foreign_keys
+	// on, triggers hold on every connection.
+	accountsIntegrityDDL = `
+CREATE TRIGGER IF NOT EXISTS bank_accounts_check_insert
+    BEFORE INSERT ON bank_accounts
+BEGIN
+    SELECT RAISE(ABORT, 'FOREIGN KEY constraint failed')
+    WHERE NOT EXISTS(SELECT 1 FROM clients WHERE id = NEW.client_id);
+    SELECT RAISE(ABORT, 'CHECK constraint failed: balance >= 0')
+    WHERE NEW.balance < 0;
+END;
+CREATE TRIGGER IF NOT EXISTS bank_accounts_check_update
+    BEFORE UPDATE ON bank_accounts
+BEGIN
+    SELECT RAISE(ABORT, 'FOREIGN KEY constraint failed')
+    WHERE NOT EXISTS(SELECT 1 FROM clients WHERE id = NEW.client_id);
+    SELECT RAISE(ABORT, 'CHECK constraint failed: balance >= 0')
+    WHERE NEW.balance < 0;
+END;
+CREATE TRIGGER IF NOT EXISTS clients_check_delete
+    BEFORE DELETE ON clients
+BEGIN
+    SELECT RAISE(ABORT, 'FOREIGN KEY constraint failed')
+    WHERE EXISTS(SELECT 1 FROM bank_accounts WHERE client_id = OLD.id);
+END;
+CREATE TRIGGER IF NOT EXISTS bank_accounts_services_check_insert
+    BEFORE INSERT ON bank_accounts_services
+BEGIN
+    SELECT RAISE(ABORT, 'FOREIGN KEY constraint failed')
+    WHERE NOT EXISTS(SELECT 1 FROM services WHERE id = NEW.service_id);
+    SELECT RAISE(ABORT, 'CHECK constraint failed: balance >= 0')
+    WHERE NEW.balance < 0;
+END;
+CREATE TRIGGER IF NOT EXISTS bank_accounts_services_check_update
+    BEFORE UPDATE ON bank_accounts_services
+BEGIN
+    SELECT RAISE(ABORT, 'FOREIGN KEY constraint failed')
+    WHERE NOT EXISTS(SELECT 1 FROM services WHERE id = NEW.service_id);
+    SELECT RAISE(ABORT, 'CHECK constraint failed: balance >= 0')
+    WHERE NEW.balance < 0;
+END;
+CREATE TRIGGER IF NOT EXISTS services_check_delete
+    BEFORE DELETE ON services
+BEGIN
+    SELECT RAISE(ABORT, 'FOREIGN KEY constraint failed')
+    WHERE EXISTS(SELECT 1 FROM bank_accounts_services WHERE service_id = OLD.id);
+END;`
+	dropAccountsIntegritySQL = `
+DROP TRIGGER IF EXISTS bank_accounts_check_insert;
+DROP TRIGGER IF EXISTS bank_accounts_check_update;
+DROP TRIGGER IF EXISTS clients_check_delete;
+DROP TRIGGER IF EXISTS bank_accounts_services_check_insert;
+DROP TRIGGER IF EXISTS bank_accounts_services_check_update;
+DROP TRIGGER IF EXISTS services_check_delete;`
+
+	// Postgres enforces REFERENCES by itself.
+	postgresAccountsIntegrityDDL = `
+ALTER TABLE bank_accounts
+    ADD CONSTRAINT bank_accounts_balance_check CHECK (balance >= 0);
+ALTER TABLE bank_accounts_services
+    ADD CONSTRAINT bank_accounts_services_balance_check CHECK (balance >= 0);`
+	postgresDropAccountsIntegritySQL = `
+ALTER TABLE bank_accounts
+    DROP CONSTRAINT IF EXISTS bank_accounts_balance_check;
+ALTER TABLE bank_accounts_services
+    DROP CONSTRAINT IF EXISTS bank_accounts_services_balance_check;`
+
+	accountSequencesDDL = `
+CREATE TABLE IF NOT EXISTS account_sequences
+(
+    account_type TEXT    NOT NULL,
+    owner_id     INTEGER NOT NULL,
+    next_number  INTEGER NOT NULL,
+    PRIMARY KEY (account_type, owner_id)
+);`
+	// numbers of existing accounts, made by counting, may have gaps
+	fillAccountSequencesSQL = `
+INSERT INTO account_sequences (account_type, owner_id, next_number)
+SELECT 'client', client_id, max(account_number) + 1
+FROM bank_accounts
+GROUP BY client_id;
+INSERT INTO account_sequences (account_type, owner_id, next_number)
+SELECT 'service', service_id, max(account_number) + 1
+FROM bank_accounts_services
+GROUP BY service_id;`
+	dropAccountSequencesSQL = `
+DROP TABLE IF EXISTS account_sequences;`
+
+	incrementAccountSequenceSQL = `
+INSERT INTO account_sequences (account_type, owner_id, next_number)
+VALUES (:account_type, :owner_id, 1)
+ON CONFLICT (account_type, owner_id) DO UPDATE
+    SET next_number = account_sequences.next_number + 1;`
+
+	reserveAccountSequenceSQL = `
+INSERT INTO account_sequences (account_type, owner_id, next_number)
+VALUES (:account_type, :owner_id, :next_number)
+ON CONFLICT (account_type, owner_id) DO UPDATE
+    SET next_number = CASE
+                          WHEN account_sequences.next_number < excluded.next_number
+                              THEN excluded.next_number
+                          ELSE account_sequences.next_number
+        END;`
+
+	getAccountSequenceSQL = `
+SELECT next_number
+FROM account_sequences
+WHERE account_type = :account_type
+  AND owner_id = :owner_id;`
+
+	accountIdentifiersDDL = `
+CREATE TABLE IF NOT EXISTS account_identifiers
+(
+    identifier   TEXT PRIMARY KEY,
+    account_type TEXT    NOT NULL,
+    account_id   INTEGER NOT NULL,
+    UNIQUE (account_type, account_id)
+);`
+	dropAccountIdentifiersSQL = `
+DROP TABLE IF EXISTS account_identifiers;`
+
+	insertAccountIdentifierSQL = `
+INSERT INTO account_identifiers (identifier, account_type, account_id)
+VALUES (:identifier, :account_type, :account_id)
+ON CONFLICT DO NOTHING;`
+
+	getAccountByIdentifierSQL = `
+SELECT account_type, account_id
+FROM account_identifiers
+WHERE identifier = ?;`
+
+	getBankAccountByIdSQL = `
+SELECT id, client_id, account_number, balance
+FROM bank_accounts
+WHERE id = ?;`
+
+	getBankAccountServiceByIdSQL = `
+SELECT id, service_id, account_number, balance
+FROM bank_accounts_services
+WHERE id = ?;`
+
+	getBankAccountIdentifierSQL = `
+SELECT ai.identifier
+FROM bank_accounts ba
+         JOIN account_identifiers ai
+              ON ai.account_type = 'client' AND ai.account_id = ba.id
+WHERE ba.client_id = :id
+  AND ba.account_number = :account_number;`
+
+	getBankAccountServiceIdentifierSQL = `
+SELECT ai.identifier
+FROM bank_accounts_services bas
+         JOIN account_identifiers ai
+              ON ai.account_type = 'service' AND ai.account_id = bas.id
+WHERE bas.service_id = :id
+  AND bas.account_number = :account_number;`
+
+	getBankAccountsWithoutIdentifierSQL = `
+SELECT ba.id
+FROM bank_accounts ba
+         LEFT JOIN account_identifiers ai
+                   ON ai.account_type = 'client' AND ai.account_id = ba.id
+WHERE ai.identifier IS NULL
+ORDER BY ba.id;`
+
+	getBankAccountsServicesWithoutIdentifierSQL = `
+SELECT bas.id
+FROM bank_accounts_services bas
+         LEFT JOIN account_identifiers ai
+                   ON ai.account_type = 'service' AND ai.account_id = bas.id
+WHERE ai.identifier IS NULL
+ORDER BY bas.id;`
+
+	getOrphanBankAccountsSQL = `
+SELECT ba.id, ba.client_id, ba.account_number, ba.balance
+FROM bank_accounts ba
+         LEFT JOIN clients c ON c.id = ba.client_id
+WHERE c.id IS NULL
+ORDER BY ba.id;`
+
+	getOrphanBankAccountsServicesSQL = `
+SELECT bas.id, bas.service_id, bas.account_number, bas.balance
+FROM bank_accounts_services bas
+         LEFT JOIN services s ON s.id = bas.service_id
+WHERE s.id IS NULL
+ORDER BY bas.id;`
+
+	getDuplicateBankAccountNumbersSQL = `
+SELECT ba.id, ba.client_id, ba.account_number, ba.balance
+FROM bank_accounts ba
+WHERE EXISTS(SELECT 1
+             FROM bank_accounts other
+             WHERE other.client_id = ba.client_id
+               AND other.account_number = ba.account_number
+               AND other.id != ba.id)
+ORDER BY ba.id;`
+
+	getDuplicateBankAccountServiceNumbersSQL = `
+SELECT bas.id, bas.service_id, bas.account_number, bas.balance
+FROM bank_accounts_services bas
+WHERE EXISTS(SELECT 1
+             FROM bank_accounts_services other
+             WHERE other.service_id = bas.service_id
+               AND other.account_number = bas.account_number
+               AND other.id != bas.id)
+ORDER BY bas.id;`
+
+	getNegativeBankAccountsSQL = `
+SELECT id, client_id, account_number, balance
+FROM bank_accounts
+WHERE balance < 0
+ORDER BY id;`
+
+	getNegativeBankAccountsServicesSQL = `
+SELECT id, service_id, account_number, balance
+FROM bank_accounts_services
+WHERE balance < 0
+ORDER BY id;`
+
+	accountCurrenciesDDL = `
+CREATE TABLE IF NOT EXISTS account_currencies
+(
+    account_type TEXT    NOT NULL,
+    account_id   INTEGER NOT NULL,
+    currency     TEXT    NOT NULL,
+    PRIMARY KEY (account_type, account_id)
+);`
+	fillAccountCurrenciesSQL = `
+INSERT INTO account_currencies (account_type, account_id, currency)
+SELECT 'client', id, 'TJS'
+FROM bank_accounts
+UNION ALL
+SELECT 'service', id, 'TJS'
+FROM bank_accounts_services;`
+	dropAccountCurrenciesSQL = `
+DROP TABLE IF EXISTS account_currencies;`
+
+	insertAccountCurrencySQL = `
+INSERT INTO account_currencies (account_type, account_id, currency)
+VALUES (:account_type, :account_id, :currency)
+ON CONFLICT DO NOTHING;`
+
+	getAccountCurrencySQL = `
+SELECT currency
+FROM account_currencies
+WHERE account_type = :account_type
+  AND account_id = :account_id;`
+
+	getAllBankAccountsExportSQL = `
+SELECT ba.id, ba.client_id, ba.account_number, ba.balance, coalesce(ac.currency, 'TJS')
+FROM bank_accounts ba
+         LEFT JOIN account_currencies ac
+                   ON ac.account_type = 'client' AND ac.account_id = ba.id;`
+
+	exchangeRatesDDL = `
+CREATE TABLE IF NOT EXISTS exchange_rates
+(
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    base_currency  TEXT    NOT NULL,
+    quote_currency TEXT    NOT NULL,
+    rate           INTEGER NOT NULL,
+    buy_spread     INTEGER NOT NULL,
+    sell_spread    INTEGER NOT NULL,
+    effective_from INTEGER NOT NULL,
+    created_at     INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS exchange_rates_pair
+    ON exchange_rates (base_currency, quote_currency, effective_from);`
+	fxConversionsDDL = `
+CREATE TABLE IF NOT EXISTS fx_conversions
+(
+    transaction_id   INTEGER PRIMARY KEY REFERENCES journal_transactions,
+    rate_id          INTEGER NOT NULL REFERENCES exchange_rates,
+    from_currency    TEXT    NOT NULL,
+    to_currency      TEXT    NOT NULL,
+    amount           INTEGER NOT NULL,
+    converted_amount INTEGER NOT NULL,
+    rate             INTEGER NOT NULL,
+    spread           INTEGER NOT NULL,
+    applied_rate     INTEGER NOT NULL
+);`
+	dropExchangeRatesSQL = `
+DROP TABLE IF EXISTS fx_conversions;
+DROP TABLE IF EXISTS exchange_rates;`
+
+	insertExchangeRateSQL = `
+INSERT INTO exchange_rates (base_currency, quote_currency, rate, buy_spread, sell_spread,
+                            effective_from, created_at)
+VALUES (:base_currency, :quote_currency, :rate, :buy_spread, :sell_spread,
+        :effective_from, :created_at);`
+
+	getExchangeRateSQL = `
+SELECT id, base_currency, quote_currency, rate, buy_spread, sell_spread, effective_from
+FROM exchange_rates
+WHERE base_currency = :base_currency
+  AND quote_currency = :quote_currency
+  AND effective_from <= :at
+ORDER BY effective_from DESC, id DESC
+LIMIT 1;`
+
+	getExchangeRatesSQL = `
+SELECT er.id, er.base_currency, er.quote_currency, er.rate, er.buy_spread, er.sell_spread,
+       er.effective_from
+FROM exchange_rates er
+WHERE er.effective_from <= :at
+  AND NOT EXISTS(SELECT 1
+                 FROM exchange_rates newer
+                 WHERE newer.base_currency = er.base_currency
+                   AND newer.quote_currency = er.quote_currency
+                   AND newer.effective_from <= :at
+                   AND (newer.effective_from > er.effective_from
+                     OR newer.effective_from = er.effective_from AND newer.id > er.id))
+ORDER BY er.base_currency, er.quote_currency;`
+
+	insertFxConversionSQL = `
+INSERT INTO fx_conversions (transaction_id, rate_id, from_currency, to_currency, amount,
+                            converted_amount, rate, spread, applied_rate)
+VALUES (:transaction_id, :rate_id, :from_currency, :to_currency, :amount,
+        :converted_amount, :rate, :spread, :applied_rate);`
+
+	getFxConversionSQL = `
+SELECT transaction_id, rate_id, from_currency, to_currency, amount,
+       converted_amount, rate, spread, applied_rate
+FROM fx_conversions
+WHERE transaction_id = ?;`
+
+	getFxConversionByIdempotencyKeySQL = `
+SELECT fc.transaction_id, fc.rate_id, fc.from_currency, fc.to_currency, fc.amount,
+       fc.converted_amount, fc.rate, fc.spread, fc.applied_rate
+FROM fx_conversions fc
+         JOIN idempotency_keys ik ON ik.transaction_id = fc.transaction_id
+WHERE ik.idempotency_key = ?;`
+
+	atmOperationsDDL = `
+CREATE TABLE IF NOT EXISTS atm_operations
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    atm_id          INTEGER NOT NULL REFERENCES atms,
+    kind            TEXT    NOT NULL,
+    bank_account_id INTEGER NOT NULL REFERENCES bank_accounts,
+    amount          INTEGER NOT NULL,
+    transaction_id  INTEGER NOT NULL REFERENCES journal_transactions,
+    created_at      INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS atm_operations_account
+    ON atm_operations (bank_account_id, kind, created_at);
+CREATE INDEX IF NOT EXISTS atm_operations_atm
+    ON atm_operations (atm_id, created_at);`
+	dropAtmOperationsSQL = `
+DROP TABLE IF EXISTS atm_operations;`
+
+	withdrawFromBankAccountSQL = `
+UPDATE bank_accounts
+SET balance = balance - :amount
+WHERE client_id = :id
+  AND account_number = :account_number
+  AND balance >= :amount;`
+
+	getAtmOperationsTotalSQL = `
+SELECT coalesce(sum(amount), 0)
+FROM atm_operations
+WHERE bank_account_id = :bank_account_id
+  AND kind = :kind
+  AND created_at >= :since;`
+
+	insertAtmOperationSQL = `
+INSERT INTO atm_operations (atm_id, kind, bank_account_id, amount, transaction_id, created_at)
+VALUES (:atm_id, :kind, :bank_account_id, :amount, :transaction_id, :created_at);`
+
+	getAtmOperationsSQL = `
+SELECT ao.id, ao.atm_id, ao.kind, ba.client_id, ba.account_number, ao.amount,
+       ao.transaction_id, ao.created_at
+FROM atm_operations ao
+         JOIN bank_accounts ba ON ba.id = ao.bank_account_id
+WHERE ao.atm_id = :atm_id
+  AND ao.created_at >= :from
+  AND ao.created_at < :to
+ORDER BY ao.id;`
+
+	getAtmLedgerNetSQL = `
+SELECT coalesce(sum(jp.amount), 0)
+FROM journal_postings jp
+         JOIN journal_transactions jt ON jt.id = jp.transaction_id
+WHERE jp.account_type = 'atm'
+  AND jp.account_id = :atm_id
+  AND jt.created_at >= :from
+  AND jt.created_at < :to;`
+
+	atmCassettesDDL = `
+CREATE TABLE IF NOT EXISTS atm_cassettes
+(
+    atm_id        INTEGER NOT NULL REFERENCES atms,
+    denomination  INTEGER NOT NULL CHECK (denomination > 0),
+    count         INTEGER NOT NULL CHECK (count >= 0),
+    low_threshold INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (atm_id, denomination)
+);
+CREATE TABLE IF NOT EXISTS atm_cassette_log
+(
+    id               INTEGER PRIMARY KEY AUTOINCREMENT,
+    atm_id           INTEGER NOT NULL REFERENCES atms,
+    denomination     INTEGER NOT NULL,
+    change           INTEGER NOT NULL,
+    reason           TEXT    NOT NULL,
+    manager_id       INTEGER REFERENCES managers,
+    atm_operation_id INTEGER REFERENCES atm_operations,
+    created_at       INTEGER NOT NULL
+);`
+	dropAtmCassettesSQL = `
+DROP TABLE IF EXISTS atm_cassette_log;
+DROP TABLE IF EXISTS atm_cassettes;`
+
+	loadAtmCassetteSQL = `
+INSERT INTO atm_cassettes (atm_id, denomination, count)
+VALUES (:atm_id, :denomination, :count)
+ON CONFLICT (atm_id, denomination) DO UPDATE
+    SET count = atm_cassettes.count + excluded.count;`
+
+	unloadAtmCassetteSQL = `
+UPDATE atm_cassettes
+SET count = count - :count
+WHERE atm_id = :atm_id
+  AND denomination = :denomination
+  AND count >= :count;`
+
+	setAtmCassetteThresholdSQL = `
+UPDATE atm_cassettes
+SET low_threshold = :low_threshold
+WHERE atm_id = :atm_id
+  AND denomination = :denomination;`
+
+	insertAtmCassetteLogSQL = `
+INSERT INTO atm_cassette_log (atm_id, denomination, change, reason, manager_id,
+                              atm_operation_id, created_at)
+VALUES (:atm_id, :denomination, :change, :reason, :manager_id,
+        :atm_operation_id, :created_at);`
+
+	getAtmCassettesSQL = `
+SELECT atm_id, denomination, count, low_threshold
+FROM atm_cassettes
+WHERE atm_id = ?
+ORDER BY denomination DESC;`
+
+	getLowCashCassettesSQL = `
+SELECT atm_id, denomination, count, low_threshold
+FROM atm_cassettes
+WHERE count <= low_threshold
+ORDER BY atm_id, denomination DESC;`
+
+	atmDetailsDDL = `
+CREATE TABLE IF NOT EXISTS atm_details
+(
+    atm_id        INTEGER PRIMARY KEY REFERENCES atms,
+    latitude      DOUBLE PRECISION,
+    longitude     DOUBLE PRECISION,
+    opening_hours TEXT    NOT NULL DEFAULT '',
+    status        TEXT    NOT NULL DEFAULT 'online'
+        CHECK (status IN ('online', 'offline', 'maintenance')),
+    cash_in       BOOLEAN NOT NULL DEFAULT FALSE,
+    currencies    TEXT    NOT NULL DEFAULT 'TJS'
+);
+CREATE INDEX IF NOT EXISTS atm_details_location
+    ON atm_details (latitude, longitude);`
+	dropAtmDetailsSQL = `
+DROP TABLE IF EXISTS atm_details;`
+
+	setAtmDetailsSQL = `
+INSERT INTO atm_details (atm_id, latitude, longitude, opening_hours, status, cash_in, currencies)
+VALUES (:atm_id, :latitude, :longitude, :opening_hours, :status, :cash_in, :currencies)
+ON CONFLICT (atm_id) DO UPDATE
+    SET latitude      = excluded.latitude,
+        longitude     = excluded.longitude,
+        opening_hours = excluded.opening_hours,
+        status        = excluded.status,
+        cash_in       = excluded.cash_in,
+        currencies    = excluded.currencies;`
+
+	getAtmsInBoxSQL = `
+SELECT a.id, coalesce(a.address, ''), ad.latitude, ad.longitude, ad.opening_hours,
+       ad.status, ad.cash_in, ad.currencies
+FROM atm_details ad
+         JOIN atms a ON a.id = ad.atm_id
+WHERE ad.latitude BETWEEN :min_latitude AND :max_latitude
+  AND ad.longitude BETWEEN :min_longitude AND :max_longitude
+  AND NOT EXISTS(SELECT 1 FROM atm_deletions d WHERE d.atm_id = a.id);`
+
+	atmLifecycleDDL = `
+CREATE TABLE IF NOT EXISTS atm_deletions
+(
+    atm_id     INTEGER PRIMARY KEY REFERENCES atms,
+    deleted_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS atm_history
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    atm_id     INTEGER NOT NULL REFERENCES atms,
+    action     TEXT    NOT NULL,
+    manager_id INTEGER REFERENCES managers,
+    reason     TEXT    NOT NULL DEFAULT '',
+    state      TEXT    NOT NULL,
+    created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS atm_history_atm
+    ON atm_history (atm_id, id);`
+	dropAtmLifecycleSQL = `
+DROP TABLE IF EXISTS atm_history;
+DROP TABLE IF EXISTS atm_deletions;`
+
+	updateAtmAddressSQL = `
+UPDATE atms
+SET address = :address
+WHERE id = :id
+  AND NOT EXISTS(SELECT 1 FROM atm_deletions d WHERE d.atm_id = atms.id);`
+
+	lockAtmDetailsSQL = `
+INSERT INTO atm_details (atm_id)
+VALUES (?)
+ON CONFLICT (atm_id) DO NOTHING;`
+
+	setAtmStatusSQL = `
+UPDATE atm_details
+SET status = :status
+WHERE atm_id = :atm_id;`
+
+	deleteAtmSQL = `
+INSERT INTO atm_deletions (atm_id, deleted_at)
+VALUES (:atm_id, :deleted_at)
+ON CONFLICT (atm_id) DO NOTHING;`
+
+	getAtmSQL = `
+SELECT a.id, coalesce(a.address, ''), ad.latitude, ad.longitude,
+       coalesce(ad.opening_hours, ''), coalesce(ad.status, 'online'),
+       coalesce(ad.cash_in, FALSE), coalesce(ad.currencies, 'TJS'),
+       d.atm_id IS NOT NULL
+FROM atms a
+         LEFT JOIN atm_details ad ON ad.atm_id = a.id
+         LEFT JOIN atm_deletions d ON d.atm_id = a.id
+WHERE a.id = ?;`
+
+	insertAtmHistorySQL = `
+INSERT INTO atm_history (atm_id, action, manager_id, reason, state, created_at)
+VALUES (:atm_id, :action, :manager_id, :reason, :state, :created_at);`
+
+	getAtmHistorySQL = `
+SELECT id, atm_id, action, coalesce(manager_id, 0), reason, state, created_at
+FROM atm_history
+WHERE atm_id = ?
+ORDER BY id;`
+
+	cardsDDL = `
+CREATE TABLE IF NOT EXISTS cards
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    bank_account_id INTEGER NOT NULL REFERENCES bank_accounts,
+    pan             TEXT    NOT NULL UNIQUE,
+    pin_hash        TEXT    NOT NULL,
+    expires_at      INTEGER NOT NULL,
+    status          TEXT    NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'blocked')),
+    block_reason    TEXT    NOT NULL DEFAULT '',
+    replaced_by     INTEGER REFERENCES cards,
+    created_at      INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS cards_account
+    ON cards (bank_account_id);`
+	dropCardsSQL = `
+DROP TABLE IF EXISTS cards;`
+
+	panExistsSQL = `
+SELECT count(*)
+FROM cards
+WHERE pan = ?;`
+
+	insertCardSQL = `
+INSERT INTO cards (bank_account_id, pan, pin_hash, expires_at, created_at)
+VALUES (:bank_account_id, :pan, :pin_hash, :expires_at, :created_at);`
+
+	blockCardSQL = `
+UPDATE cards
+SET status       = 'blocked',
+    block_reason = :reason
+WHERE id = :id
+  AND status = 'active'
+  AND replaced_by IS NULL;`
+
+	unblockCardSQL = `
+UPDATE cards
+SET status       = 'active',
+    block_reason = ''
+WHERE id = :id
+  AND status = 'blocked'
+  AND replaced_by IS NULL
+  AND expires_at > :now;`
+
+	retireCardSQL = `
+UPDATE cards
+SET status       = 'blocked',
+    block_reason = 'reissued'
+WHERE id = ?
+  AND replaced_by IS NULL;`
+
+	setCardReplacementSQL = `
+UPDATE cards
+SET replaced_by = :replaced_by
+WHERE id = :id;`
+
+	getCardPinHashSQL = `
+SELECT bank_account_id, pin_hash
+FROM cards
+WHERE id = ?;`
+
+	getCardsSQL = `
+SELECT c.id, ba.client_id, ba.account_number, c.pan, c.expires_at, c.status,
+       c.block_reason, coalesce(c.replaced_by, 0), c.created_at
+FROM cards c
+         JOIN bank_accounts ba ON ba.id = c.bank_account_id`
+	getCardByIdSQL = getCardsSQL + `
+WHERE c.id = ?;`
+	getCardByPanSQL = getCardsSQL + `
+WHERE c.pan = ?;`
+	getClientCardsSQL = getCardsSQL + `
+WHERE ba.client_id = ?
+ORDER BY c.id;`
 )
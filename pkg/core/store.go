@@ -0,0 +1,390 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// dbtx is what both *sql.DB and *sql.Tx can do.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Store runs the bank operations on a database. A store bound to a
+// transaction with WithTx runs every operation in it and never commits, so
+// the caller decides whether several operations land together.
+type Store struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	dialect Dialect
+}
+
+// NewStore picks the dialect from the driver db was opened with. SQLite
+// checks REFERENCES only when the DSN has _foreign_keys=1, the account
+// tables are guarded by triggers either way.
+func NewStore(db *sql.DB) *Store {
+	return NewStoreWithDialect(db, detectDialect(db))
+}
+
+func NewStoreWithDialect(db *sql.DB, dialect Dialect) *Store {
+	return &Store{db: db, dialect: dialect}
+}
+
+func (receiver *Store) WithTx(tx *sql.Tx) *Store {
+	return &Store{db: receiver.db, tx: tx, dialect: receiver.dialect}
+}
+
+func (receiver *Store) Dialect() Dialect {
+	return receiver.dialect
+}
+
+func (receiver *Store) conn() dbtx {
+	if receiver.tx != nil {
+		return receiver.dialect.conn(receiver.tx)
+	}
+	return receiver.dialect.conn(receiver.db)
+}
+
+// InTx runs fn with a store bound to one transaction and commits it when fn
+// returns nil. A store that is already bound runs fn in the transaction it
+// is bound to and leaves committing to its owner, but what a failed fn wrote
+// is undone all the same.
+//
+// A fn that reads and then writes should write first. The first write takes
+// the SQLite write lock, and a transaction that read before it can't upgrade
+// its lock while another one writes.
+func (receiver *Store) InTx(ctx context.Context, fn func(tx *Store) error) (err error) {
+	if receiver.tx != nil {
+		return receiver.inSavepoint(ctx, fn)
+	}
+
+	tx, err := receiver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	return fn(receiver.WithTx(tx))
+}
+
+func (receiver *Store) inSavepoint(ctx context.Context, fn func(tx *Store) error) error {
+	_, err := receiver.tx.ExecContext(ctx, "SAVEPOINT in_tx")
+	if err != nil {
+		return err
+	}
+	err = fn(receiver)
+	if err != nil {
+		// a cancelled context has rolled back the whole transaction already
+		_, _ = receiver.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT in_tx")
+		_, _ = receiver.tx.ExecContext(ctx, "RELEASE SAVEPOINT in_tx")
+		return err
+	}
+	_, err = receiver.tx.ExecContext(ctx, "RELEASE SAVEPOINT in_tx")
+	return err
+}
+
+//---------------*sql.DB API
+// Every function below runs one operation of NewStore(db) without a deadline.
+
+func Init(db *sql.DB) error {
+	return NewStore(db).Init(context.Background())
+}
+
+func IsLoginAvailable(login string, db *sql.DB) (bool, error) {
+	return NewStore(db).IsLoginAvailable(context.Background(), login)
+}
+func AddClient(managerId int64, client Client, db *sql.DB) error {
+	return NewStore(db).AddClient(context.Background(), managerId, client)
+}
+func AddManager(managerId int64, manager Manager, db *sql.DB) error {
+	return NewStore(db).AddManager(context.Background(), managerId, manager)
+}
+func AddService(managerId int64, service Service, db *sql.DB) (string, error) {
+	return NewStore(db).AddService(context.Background(), managerId, service)
+}
+func AddBankAccountToClient(managerId, id int64, db *sql.DB) error {
+	return NewStore(db).AddBankAccountToClient(context.Background(), managerId, id)
+}
+func AddBankAccountToService(managerId, id int64, db *sql.DB) error {
+	return NewStore(db).AddBankAccountToService(context.Background(), managerId, id)
+}
+func AddBankAccountToClientInCurrency(managerId, id int64, currency string, db *sql.DB) error {
+	return NewStore(db).AddBankAccountToClientInCurrency(context.Background(), managerId, id, currency)
+}
+func AddBankAccountToServiceInCurrency(managerId, id int64, currency string, db *sql.DB) error {
+	return NewStore(db).AddBankAccountToServiceInCurrency(context.Background(), managerId, id, currency)
+}
+func AddATM(managerId int64, address string, db *sql.DB) error {
+	return NewStore(db).AddATM(context.Background(), managerId, address)
+}
+func GetClientIdByLogin(login string, db *sql.DB) (int64, error) {
+	return NewStore(db).GetClientIdByLogin(context.Background(), login)
+}
+func GetClientIdByPhoneNumber(phone string, db *sql.DB) (int64, error) {
+	return NewStore(db).GetClientIdByPhoneNumber(context.Background(), phone)
+}
+func AtmsList(db *sql.DB) ([]string, error) {
+	return NewStore(db).AtmsList(context.Background())
+}
+func FindNearestATMs(latitude, longitude, radius float64, filter AtmFilter,
+	db *sql.DB) (NearbyAtmsPage, error) {
+
+	return NewStore(db).FindNearestATMs(context.Background(), latitude, longitude, radius, filter)
+}
+func CreateATM(managerId int64, atm Atm, db *sql.DB) (Atm, error) {
+	return NewStore(db).CreateATM(context.Background(), managerId, atm)
+}
+func UpdateATM(managerId int64, atm Atm, db *sql.DB) (Atm, error) {
+	return NewStore(db).UpdateATM(context.Background(), managerId, atm)
+}
+func SetAtmStatus(managerId, atmId int64, status AtmStatus, reason string, db *sql.DB) (Atm, error) {
+	return NewStore(db).SetAtmStatus(context.Background(), managerId, atmId, status, reason)
+}
+func DeleteATM(managerId, atmId int64, reason string, db *sql.DB) error {
+	return NewStore(db).DeleteATM(context.Background(), managerId, atmId, reason)
+}
+func GetATM(atmId int64, db *sql.DB) (Atm, error) {
+	return NewStore(db).GetATM(context.Background(), atmId)
+}
+func ListATMs(db *sql.DB) ([]Atm, error) {
+	return NewStore(db).ListATMs(context.Background())
+}
+func AtmHistory(atmId int64, db *sql.DB) ([]AtmChange, error) {
+	return NewStore(db).AtmHistory(context.Background(), atmId)
+}
+func BankAccountsList(id int64, db *sql.DB) ([]BankAccount, error) {
+	return NewStore(db).BankAccountsList(context.Background(), id)
+}
+func GetAllAccountNumbersByClientId(id int64, db *sql.DB) ([]int64, error) {
+	return NewStore(db).GetAllAccountNumbersByClientId(context.Background(), id)
+}
+
+func ReplenishBankAccount(managerId, clientId, accountNumber, amount int64,
+	db *sql.DB) (Replenishment, error) {
+
+	return NewStore(db).ReplenishBankAccount(context.Background(),
+		managerId, clientId, accountNumber, amount)
+}
+func WithdrawAtATM(atmId, clientId, accountNumber, amount int64,
+	db *sql.DB) (AtmOperation, error) {
+
+	return NewStore(db).WithdrawAtATM(context.Background(),
+		atmId, clientId, accountNumber, amount)
+}
+func DepositAtATM(atmId, clientId, accountNumber, amount int64,
+	db *sql.DB) (AtmOperation, error) {
+
+	return NewStore(db).DepositAtATM(context.Background(),
+		atmId, clientId, accountNumber, amount)
+}
+func AtmOperations(atmId int64, from, to time.Time, db *sql.DB) ([]AtmOperation, error) {
+	return NewStore(db).AtmOperations(context.Background(), atmId, from, to)
+}
+func ReconcileAtm(atmId int64, from, to time.Time, db *sql.DB) (AtmReconciliation, error) {
+	return NewStore(db).ReconcileAtm(context.Background(), atmId, from, to)
+}
+func LoadCassette(managerId, atmId, denomination, count int64, db *sql.DB) error {
+	return NewStore(db).LoadCassette(context.Background(), managerId, atmId, denomination, count)
+}
+func UnloadCassette(managerId, atmId, denomination, count int64, db *sql.DB) error {
+	return NewStore(db).UnloadCassette(context.Background(), managerId, atmId, denomination, count)
+}
+func SetLowCashThreshold(managerId, atmId, denomination, threshold int64, db *sql.DB) error {
+	return NewStore(db).SetLowCashThreshold(context.Background(), managerId, atmId, denomination, threshold)
+}
+func AtmCassettes(atmId int64, db *sql.DB) ([]Cassette, error) {
+	return NewStore(db).AtmCassettes(context.Background(), atmId)
+}
+func LowCashAlerts(db *sql.DB) ([]Cassette, error) {
+	return NewStore(db).LowCashAlerts(context.Background())
+}
+func IssueCard(managerId, clientId, accountNumber int64, pin string, db *sql.DB) (Card, error) {
+	return NewStore(db).IssueCard(context.Background(), managerId, clientId, accountNumber, pin)
+}
+func ReissueCard(managerId, cardId int64, db *sql.DB) (Card, error) {
+	return NewStore(db).ReissueCard(context.Background(), managerId, cardId)
+}
+func BlockCard(managerId, cardId int64, reason string, db *sql.DB) error {
+	return NewStore(db).BlockCard(context.Background(), managerId, cardId, reason)
+}
+func UnblockCard(managerId, cardId int64, db *sql.DB) error {
+	return NewStore(db).UnblockCard(context.Background(), managerId, cardId)
+}
+func CheckCardPin(pan, pin string, db *sql.DB) (Card, error) {
+	return NewStore(db).CheckCardPin(context.Background(), pan, pin)
+}
+func GetCard(cardId int64, db *sql.DB) (Card, error) {
+	return NewStore(db).GetCard(context.Background(), cardId)
+}
+func ListClientCards(clientId int64, db *sql.DB) ([]Card, error) {
+	return NewStore(db).ListClientCards(context.Background(), clientId)
+}
+func TransferToClient(transfer MoneyTransfer, db *sql.DB) error {
+	return NewStore(db).TransferToClient(context.Background(), transfer)
+}
+func TransferByAccountID(transfer AccountTransfer, db *sql.DB) error {
+	return NewStore(db).TransferByAccountID(context.Background(), transfer)
+}
+func FindAccountByID(accountID string, db *sql.DB) (AccountRecord, error) {
+	return NewStore(db).FindAccountByID(context.Background(), accountID)
+}
+func AccountIDOf(account LedgerAccount, ownerId, accountNumber int64, db *sql.DB) (string, error) {
+	return NewStore(db).AccountIDOf(context.Background(), account, ownerId, accountNumber)
+}
+func PayForService(serviceNumber string,
+	amount, payerId, payerAccountNumber int64,
+	db *sql.DB) error {
+
+	return NewStore(db).PayForService(context.Background(),
+		serviceNumber, amount, payerId, payerAccountNumber)
+}
+func MakeServicePayment(payment ServicePayment, db *sql.DB) error {
+	return NewStore(db).MakeServicePayment(context.Background(), payment)
+}
+func TransferWithExchange(transfer MoneyTransfer, db *sql.DB) (Conversion, error) {
+	return NewStore(db).TransferWithExchange(context.Background(), transfer)
+}
+func ConversionOf(transactionId int64, db *sql.DB) (Conversion, error) {
+	return NewStore(db).ConversionOf(context.Background(), transactionId)
+}
+func UploadExchangeRates(managerId int64, rates []ExchangeRate, db *sql.DB) error {
+	return NewStore(db).UploadExchangeRates(context.Background(), managerId, rates)
+}
+func ExchangeRates(at time.Time, db *sql.DB) ([]ExchangeRate, error) {
+	return NewStore(db).ExchangeRates(context.Background(), at)
+}
+
+func LoginForManager(login, password string, db *sql.DB) (bool, error) {
+	return NewStore(db).LoginForManager(context.Background(), login, password, "")
+}
+func LoginForClient(login, password string, db *sql.DB) (bool, error) {
+	return NewStore(db).LoginForClient(context.Background(), login, password, "")
+}
+func LoginForManagerFrom(login, password, source string, db *sql.DB) (bool, error) {
+	return NewStore(db).LoginForManager(context.Background(), login, password, source)
+}
+func LoginForClientFrom(login, password, source string, db *sql.DB) (bool, error) {
+	return NewStore(db).LoginForClient(context.Background(), login, password, source)
+}
+func UnlockLogin(managerId int64, login string, db *sql.DB) error {
+	return NewStore(db).UnlockLogin(context.Background(), managerId, login)
+}
+func UnlockSource(managerId int64, source string, db *sql.DB) error {
+	return NewStore(db).UnlockSource(context.Background(), managerId, source)
+}
+
+func LoginForClientWithSession(login, password, source string, db *sql.DB) (Session, error) {
+	return NewStore(db).LoginForClientWithSession(context.Background(), login, password, source)
+}
+func LoginForManagerWithSession(login, password, source string, db *sql.DB) (Session, error) {
+	return NewStore(db).LoginForManagerWithSession(context.Background(), login, password, source)
+}
+func ValidateSession(token string, db *sql.DB) (Principal, error) {
+	return NewStore(db).ValidateSession(context.Background(), token)
+}
+func RefreshSession(refreshToken string, db *sql.DB) (Session, error) {
+	return NewStore(db).RefreshSession(context.Background(), refreshToken)
+}
+func Logout(token string, db *sql.DB) error {
+	return NewStore(db).Logout(context.Background(), token)
+}
+func RevokeAllSessions(managerId int64, login string, role Role, db *sql.DB) error {
+	return NewStore(db).RevokeAllSessions(context.Background(), managerId, login, role)
+}
+func PurgeExpiredSessions(db *sql.DB) error {
+	return NewStore(db).PurgeExpiredSessions(context.Background())
+}
+
+func Authorize(managerId int64, permission Permission, db *sql.DB) error {
+	return NewStore(db).Authorize(context.Background(), managerId, permission)
+}
+func GrantRole(managerId, granteeId int64, role ManagerRole, db *sql.DB) error {
+	return NewStore(db).GrantRole(context.Background(), managerId, granteeId, role)
+}
+func RevokeRole(managerId, granteeId int64, role ManagerRole, db *sql.DB) error {
+	return NewStore(db).RevokeRole(context.Background(), managerId, granteeId, role)
+}
+func ManagerRoles(managerId int64, db *sql.DB) ([]ManagerRole, error) {
+	return NewStore(db).ManagerRoles(context.Background(), managerId)
+}
+func AsManager(managerId int64, db *sql.DB) *ManagerActions {
+	return NewStore(db).AsManager(context.Background(), managerId)
+}
+func AsManagerWithSession(token string, db *sql.DB) (*ManagerActions, error) {
+	return NewStore(db).AsManagerWithSession(context.Background(), token)
+}
+
+func DerivedBalance(account LedgerAccount, accountId int64, db *sql.DB) (int64, error) {
+	return NewStore(db).DerivedBalance(context.Background(), account, accountId)
+}
+func VerifyLedger(db *sql.DB) (LedgerReport, error) {
+	return NewStore(db).VerifyLedger(context.Background())
+}
+func IntegrityCheck(db *sql.DB) (IntegrityReport, error) {
+	return NewStore(db).IntegrityCheck(context.Background())
+}
+func AccountHistory(clientId, accountNumber int64, filter HistoryFilter,
+	db *sql.DB) (HistoryPage, error) {
+
+	return NewStore(db).AccountHistory(context.Background(), clientId, accountNumber, filter)
+}
+func AccountStatement(clientId, accountNumber int64, from, to time.Time,
+	db *sql.DB) (Statement, error) {
+
+	return NewStore(db).AccountStatement(context.Background(), clientId, accountNumber, from, to)
+}
+func PurgeExpiredIdempotencyKeys(db *sql.DB) error {
+	return NewStore(db).PurgeExpiredIdempotencyKeys(context.Background())
+}
+
+func ExportClientsToJSON(managerId int64, db *sql.DB) error {
+	return NewStore(db).ExportClientsToJSON(context.Background(), managerId)
+}
+func ExportAtmsToJSON(managerId int64, db *sql.DB) error {
+	return NewStore(db).ExportAtmsToJSON(context.Background(), managerId)
+}
+func ExportBankAccountsToJSON(managerId int64, db *sql.DB) error {
+	return NewStore(db).ExportBankAccountsToJSON(context.Background(), managerId)
+}
+func ExportClientsToXML(managerId int64, db *sql.DB) error {
+	return NewStore(db).ExportClientsToXML(context.Background(), managerId)
+}
+func ExportAtmsToXML(managerId int64, db *sql.DB) error {
+	return NewStore(db).ExportAtmsToXML(context.Background(), managerId)
+}
+func ExportBankAccountsToXML(managerId int64, db *sql.DB) error {
+	return NewStore(db).ExportBankAccountsToXML(context.Background(), managerId)
+}
+func ImportClientsFromJSON(managerId int64, db *sql.DB) error {
+	return NewStore(db).ImportClientsFromJSON(context.Background(), managerId)
+}
+func ImportAtmsFromJSON(managerId int64, db *sql.DB) error {
+	return NewStore(db).ImportAtmsFromJSON(context.Background(), managerId)
+}
+func ImportBankAccountsFromJSON(managerId int64, db *sql.DB) error {
+	return NewStore(db).ImportBankAccountsFromJSON(context.Background(), managerId)
+}
+func ImportClientsFromXML(managerId int64, db *sql.DB) error {
+	return NewStore(db).ImportClientsFromXML(context.Background(), managerId)
+}
+func ImportAtmsFromXML(managerId int64, db *sql.DB) error {
+	return NewStore(db).ImportAtmsFromXML(context.Background(), managerId)
+}
+func ImportBankAccountsFromXML(managerId int64, db *sql.DB) error {
+	return NewStore(db).ImportBankAccountsFromXML(context.Background(), managerId)
+}
+func ImportExchangeRatesFromJSON(managerId int64, db *sql.DB) error {
+	return NewStore(db).ImportExchangeRatesFromJSON(context.Background(), managerId)
+}
+func ImportExchangeRatesFromCSV(managerId int64, db *sql.DB) error {
+	return NewStore(db).ImportExchangeRatesFromCSV(context.Background(), managerId)
+}
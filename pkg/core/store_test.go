@@ -0,0 +1,147 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_storeInTx(t *testing.T) {
+	db, cleanup := createDBinFile(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store := NewStore(db)
+	err := store.Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errStop := errors.New("stop")
+	err = store.InTx(ctx, func(tx *Store) error {
+		err := tx.AddClient(ctx, defaultAdminId, Client{Login: "first"})
+		if err != nil {
+			return err
+		}
+		err = tx.AddBankAccountToClient(ctx, defaultAdminId, 1)
+		if err != nil {
+			return err
+		}
+		return errStop
+	})
+	if err != errStop {
+		t.Fatalf("want: %v, got: %v", errStop, err)
+	}
+	available, err := store.IsLoginAvailable(ctx, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !available {
+		t.Error("want client to be rolled back")
+	}
+
+	err = store.InTx(ctx, func(tx *Store) error {
+		err := tx.AddClient(ctx, defaultAdminId, Client{Login: "first"})
+		if err != nil {
+			return err
+		}
+		id, err := tx.GetClientIdByLogin(ctx, "first")
+		if err != nil {
+			return err
+		}
+		return tx.AddBankAccountToClient(ctx, defaultAdminId, id)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := store.BankAccountsList(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 {
+		t.Errorf("want 1 account, got: %v", accounts)
+	}
+}
+
+func Test_storeWithCallerTx(t *testing.T) {
+	db, cleanup := createDBinFile(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	err := NewStore(db).Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddClient(defaultAdminId, Client{Login: "first"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddBankAccountToClient(defaultAdminId, 1, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(db).WithTx(tx)
+	_, err = store.ReplenishBankAccount(ctx, defaultAdminId, 1, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the bound store must not commit on its own
+	err = store.InTx(ctx, func(tx *Store) error {
+		_, err := tx.ReplenishBankAccount(ctx, defaultAdminId, 1, 0, 50)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, 1, 0, 0, db)
+
+	// a failed operation leaves nothing in the transaction of the caller
+	_, err = ReplenishBankAccount(defaultAdminId, 1, 0, 100, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewStore(db).WithTx(tx).TransferToClient(ctx, MoneyTransfer{Amount: 30, SenderId: 1, ReceiverId: 2})
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("want: %v, got: %v", ErrAccountNotFound, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, 1, 0, 100, db)
+}
+
+func Test_storeHonorsContext(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	store := NewStore(db)
+	_, err = store.GetClientIdByLogin(ctx, "admin")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want: %v, got: %v", context.Canceled, err)
+	}
+	err = store.TransferToClient(ctx, MoneyTransfer{Amount: 1, SenderId: 1, ReceiverId: 2})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want: %v, got: %v", context.Canceled, err)
+	}
+}
@@ -1,5 +1,7 @@
 package core
 
+import "time"
+
 type Client struct {
 	Id       int64
 	Login    string
@@ -19,6 +21,9 @@ type BankAccount struct {
 	UserId    int64
 	AccountId int64
 	Balance   int64
+	// Currency is an ISO 4217 code, the balance is in its minor units.
+	Currency   string
+	MinorUnits int
 }
 
 type Service struct {
@@ -26,9 +31,16 @@ type Service struct {
 	Name string
 }
 
+// Atm is in DefaultCurrency, online and open around the clock until told
+// otherwise. Only ATMs with a Location are found by FindNearestATMs.
 type Atm struct {
-	Id      int64
-	Address string
+	Id           int64
+	Address      string
+	Location     *GeoPoint
+	OpeningHours string
+	Status       AtmStatus
+	CashIn       bool
+	Currencies   []string
 }
 
 type MoneyTransfer struct {
@@ -37,6 +49,35 @@ type MoneyTransfer struct {
 	SenderAccountNumber,
 	ReceiverId,
 	ReceiverAccountNumber int64
+	// IdempotencyKey is optional, retries with the same key don't move
+	// money again.
+	IdempotencyKey string
+}
+
+type Replenishment struct {
+	TransactionId int64
+	ClientId      int64
+	AccountNumber int64
+	Amount        int64
+	Balance       int64
+	CreatedAt     time.Time
+}
+
+// AccountTransfer moves money between accounts named by their AccountID.
+// The sender is a client account, the receiver a client or a service one.
+type AccountTransfer struct {
+	Amount            int64
+	SenderAccountID   string
+	ReceiverAccountID string
+	IdempotencyKey    string
+}
+
+type ServicePayment struct {
+	ServiceNumber      string
+	Amount             int64
+	PayerId            int64
+	PayerAccountNumber int64
+	IdempotencyKey     string
 }
 
 type ClientsExport struct {
@@ -48,5 +89,33 @@ type BankAccountsExport struct {
 }
 
 type AtmsExport struct {
-	Atms []Atm
+	Atms []ExportedAtm
+}
+
+// ExportedAtm is an ATM of an export file, the deleted ones are exported
+// too.
+type ExportedAtm struct {
+	Atm
+	Deleted bool
+}
+
+type Role string
+
+const (
+	RoleClient  Role = "client"
+	RoleManager Role = "manager"
+)
+
+type Principal struct {
+	Id    int64
+	Login string
+	Role  Role
+}
+
+type Session struct {
+	Principal
+	Token            string
+	RefreshToken     string
+	ExpiresAt        time.Time
+	RefreshExpiresAt time.Time
 }
@@ -0,0 +1,205 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+type LoginThrottle struct {
+	// FreeFailures consecutive failures are allowed without any delay,
+	// every next one doubles the delay starting from BaseBackoff.
+	FreeFailures int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	// MaxFailures failures of one login lock it for LockoutDuration.
+	MaxFailures     int
+	LockoutDuration time.Duration
+	// MaxSourceFailures failures from one source, whatever logins were
+	// tried, lock the source for LockoutDuration.
+	MaxSourceFailures int
+	// Failures older than ResetAfter are forgotten.
+	ResetAfter time.Duration
+}
+
+var DefaultLoginThrottle = LoginThrottle{
+	FreeFailures:      3,
+	BaseBackoff:       time.Second,
+	MaxBackoff:        time.Minute,
+	MaxFailures:       10,
+	LockoutDuration:   15 * time.Minute,
+	MaxSourceFailures: 50,
+	ResetAfter:        time.Hour,
+}
+
+var loginThrottle = DefaultLoginThrottle
+
+func SetLoginThrottle(throttle LoginThrottle) {
+	loginThrottle = throttle
+}
+
+const sourceScope = "source"
+
+func (receiver *Store) checkLoginThrottle(ctx context.Context, scope, login, source string) error {
+	err := receiver.checkBlockedUntil(ctx, scope, login)
+	if err != nil {
+		return err
+	}
+	if source == "" {
+		return nil
+	}
+	return receiver.checkBlockedUntil(ctx, sourceScope, source)
+}
+func (receiver *Store) checkBlockedUntil(ctx context.Context, scope, key string) error {
+	var blockedUntil int64
+	err := receiver.conn().QueryRowContext(ctx, getLoginAttemptBlockedUntilSQL,
+		sql.Named("scope", scope),
+		sql.Named("key", key),
+	).Scan(&blockedUntil)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return queryError(getLoginAttemptBlockedUntilSQL, err)
+	}
+	until := time.Unix(blockedUntil, 0)
+	if now().Before(until) {
+		return &ThrottleError{Until: until}
+	}
+	return nil
+}
+
+func (receiver *Store) recordLoginFailure(ctx context.Context, scope, login, source string) error {
+	return receiver.InTx(ctx, func(tx *Store) error {
+		failures, err := tx.incrementFailures(ctx, scope, login)
+		if err != nil {
+			return err
+		}
+		err = tx.setBlockedUntil(ctx, scope, login, loginBackoff(failures))
+		if err != nil {
+			return err
+		}
+
+		if source == "" {
+			return nil
+		}
+		failures, err = tx.incrementFailures(ctx, sourceScope, source)
+		if err != nil {
+			return err
+		}
+		var lockout time.Duration
+		if failures >= loginThrottle.MaxSourceFailures {
+			lockout = loginThrottle.LockoutDuration
+		}
+		return tx.setBlockedUntil(ctx, sourceScope, source, lockout)
+	})
+}
+
+func loginBackoff(failures int) time.Duration {
+	if failures >= loginThrottle.MaxFailures {
+		return loginThrottle.LockoutDuration
+	}
+	if failures < loginThrottle.FreeFailures {
+		return 0
+	}
+	backoff := loginThrottle.BaseBackoff
+	for i := loginThrottle.FreeFailures; i < failures; i++ {
+		backoff *= 2
+		if backoff >= loginThrottle.MaxBackoff {
+			return loginThrottle.MaxBackoff
+		}
+	}
+	return backoff
+}
+
+func (receiver *Store) incrementFailures(ctx context.Context,
+	scope, key string) (failures int, err error) {
+
+	current := now()
+	_, err = receiver.conn().ExecContext(ctx, incrementLoginFailuresSQL,
+		sql.Named("scope", scope),
+		sql.Named("key", key),
+		sql.Named("now", current.Unix()),
+		sql.Named("reset_before", current.Add(-loginThrottle.ResetAfter).Unix()),
+	)
+	if err != nil {
+		return 0, queryError(incrementLoginFailuresSQL, err)
+	}
+	err = receiver.conn().QueryRowContext(ctx, getLoginFailuresSQL,
+		sql.Named("scope", scope),
+		sql.Named("key", key),
+	).Scan(&failures)
+	if err != nil {
+		return 0, queryError(getLoginFailuresSQL, err)
+	}
+	return failures, nil
+}
+func (receiver *Store) setBlockedUntil(ctx context.Context,
+	scope, key string, backoff time.Duration) error {
+
+	var blockedUntil int64
+	if backoff > 0 {
+		blockedUntil = now().Add(backoff).Unix()
+	}
+	_, err := receiver.conn().ExecContext(ctx, updateLoginAttemptBlockedUntilSQL,
+		sql.Named("blocked_until", blockedUntil),
+		sql.Named("scope", scope),
+		sql.Named("key", key),
+	)
+	if err != nil {
+		return queryError(updateLoginAttemptBlockedUntilSQL, err)
+	}
+	return nil
+}
+
+func (receiver *Store) resetLoginFailures(ctx context.Context, scope, login string) error {
+	return receiver.deleteLoginAttempts(ctx, scope, login)
+}
+func (receiver *Store) deleteLoginAttempts(ctx context.Context, scope, key string) error {
+	_, err := receiver.conn().ExecContext(ctx, deleteLoginAttemptsSQL,
+		sql.Named("scope", scope),
+		sql.Named("key", key),
+	)
+	if err != nil {
+		return queryError(deleteLoginAttemptsSQL, err)
+	}
+	return nil
+}
+
+// UnlockLogin lets managers lift a lockout of a client or manager login.
+func (receiver *Store) UnlockLogin(ctx context.Context, managerId int64, login string) error {
+	err := receiver.Authorize(ctx, managerId, PermissionLoginsUnlock)
+	if err != nil {
+		return err
+	}
+	login = normalizeLogin(login)
+	err = receiver.deleteLoginAttempts(ctx, string(RoleClient), login)
+	if err != nil {
+		return err
+	}
+	return receiver.deleteLoginAttempts(ctx, string(RoleManager), login)
+}
+func (receiver *Store) UnlockSource(ctx context.Context, managerId int64, source string) error {
+	err := receiver.Authorize(ctx, managerId, PermissionLoginsUnlock)
+	if err != nil {
+		return err
+	}
+	return receiver.deleteLoginAttempts(ctx, sourceScope, source)
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash is verified against for unknown logins.
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		hash, err := hashPassword("dummy-password")
+		if err == nil {
+			dummyHash = hash
+		}
+	})
+	return dummyHash
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_loginBackoffAndLockout(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddClient(defaultAdminId, Client{Login: "client", Password: "pass"}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := time.Now()
+	defer func() { now = time.Now }()
+	now = func() time.Time { return current }
+
+	for _, login := range []string{"client", "unknown"} {
+		for i := 0; i < DefaultLoginThrottle.FreeFailures; i++ {
+			_, err = LoginForClient(login, "wrong", db)
+			if err != ErrInvalidPass {
+				t.Errorf("%v: want: %v, got: %v", login, ErrInvalidPass, err)
+			}
+		}
+		_, err = LoginForClient(login, "wrong", db)
+		if !errors.Is(err, ErrTooManyAttempts) {
+			t.Errorf("%v: want: %v, got: %v", login, ErrTooManyAttempts, err)
+		}
+	}
+
+	_, err = LoginForClient("client", "pass", db)
+	if !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("want: %v, got: %v", ErrTooManyAttempts, err)
+	}
+
+	for i := DefaultLoginThrottle.FreeFailures; i < DefaultLoginThrottle.MaxFailures; i++ {
+		current = current.Add(DefaultLoginThrottle.MaxBackoff)
+		_, err = LoginForClient("client", "wrong", db)
+		if err != ErrInvalidPass {
+			t.Errorf("want: %v, got: %v", ErrInvalidPass, err)
+		}
+	}
+
+	current = current.Add(DefaultLoginThrottle.MaxBackoff)
+	var throttleErr *ThrottleError
+	_, err = LoginForClient("client", "pass", db)
+	if !errors.As(err, &throttleErr) {
+		t.Fatalf("want: %v, got: %v", ErrTooManyAttempts, err)
+	}
+	if !throttleErr.Until.After(current.Add(DefaultLoginThrottle.MaxBackoff)) {
+		t.Errorf("want lockout, got retry after: %v", throttleErr.Until)
+	}
+
+	err = UnlockLogin(defaultAdminId, "client", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := LoginForClient("client", "pass", db)
+	if err != nil {
+		t.Error(err)
+	}
+	if !ok {
+		t.Error("want: true, got false")
+	}
+}
+
+func Test_loginSourceLockout(t *testing.T) {
+	db := createDBinMemory(t)
+	defer db.Close()
+
+	err := Init(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer SetLoginThrottle(DefaultLoginThrottle)
+	throttle := DefaultLoginThrottle
+	throttle.MaxSourceFailures = 3
+	SetLoginThrottle(throttle)
+
+	for _, login := range []string{"a", "b", "c"} {
+		_, err = LoginForManagerFrom(login, "wrong", "10.0.0.1", db)
+		if err != ErrInvalidPass {
+			t.Errorf("want: %v, got: %v", ErrInvalidPass, err)
+		}
+	}
+
+	_, err = LoginForManagerFrom("admin", defaultAdminPassword, "10.0.0.1", db)
+	if !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("want: %v, got: %v", ErrTooManyAttempts, err)
+	}
+
+	ok, err := LoginForManagerFrom("admin", defaultAdminPassword, "10.0.0.2", db)
+	if err != nil || !ok {
+		t.Errorf("want: true <nil>, got: %v %v", ok, err)
+	}
+
+	err = UnlockSource(defaultAdminId, "10.0.0.1", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err = LoginForManagerFrom("admin", defaultAdminPassword, "10.0.0.1", db)
+	if err != nil || !ok {
+		t.Errorf("want: true <nil>, got: %v %v", ok, err)
+	}
+}